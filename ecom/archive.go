@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"ecom.com/cache"
+	"ecom.com/config"
+	"ecom.com/constants"
+	"ecom.com/repository"
+	"ecom.com/services"
+)
+
+const archiveUsage = `usage: go run main.go archive <run|restore> [-days n] [order ids...]
+  run      archive Completed orders older than -days (default archive.retentionDays)
+  restore  move the given archived orders back into the orders table`
+
+// runArchive implements the archive command against the orders DB in
+// appConfig. A running server keeps serving the moved orders either way.
+func runArchive(appConfig config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(archiveUsage)
+	}
+	command := args[0]
+	switch command {
+	case "run", "restore":
+	default:
+		return fmt.Errorf("unknown archive command %q\n%v", command, archiveUsage)
+	}
+	flags := flag.NewFlagSet("archive", flag.ContinueOnError)
+	days := flags.Int("days", appConfig.Archive.RetentionDays, "retention in days for run")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if constants.StorageName(appConfig.Storage) == constants.MEMORY_STORAGE {
+		return errors.New("archive needs sql storage, memory storage is gone with the server")
+	}
+
+	names, dbs, err := openOrderDBs(appConfig)
+	if err != nil {
+		return err
+	}
+	defer closeAll(dbs)
+	var repo repository.ArchiveRepositoryI
+	if len(names) > 0 {
+		router, err := repository.NewShardRouter(appConfig.Database.Driver, names, asDBTX(dbs))
+		if err != nil {
+			return err
+		}
+		repo, err = repository.NewShardedArchiveRepository(router)
+	} else {
+		repo, err = repository.NewArchiveRepository(appConfig.Database.Driver, dbs[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch command {
+	case "run":
+		if *days <= 0 {
+			return errors.New("archive run needs -days or archive.retentionDays above 0")
+		}
+		// This process caches nothing, the reconciler of a running server
+		// drops the statuses it still has cached for the moved orders.
+		archiver := services.NewArchiver(repo, cache.NewMemory(cache.MemoryOptions{}), 0,
+			time.Duration(*days)*24*time.Hour, appConfig.Archive.BatchSize)
+		archived := archiver.Archive(ctx)
+		fmt.Printf("orders: archived %v orders older than %v days\n", archived, *days)
+	case "restore":
+		if flags.NArg() == 0 {
+			return errors.New(archiveUsage)
+		}
+		for _, id := range flags.Args() {
+			err := repo.RestoreOrder(ctx, id)
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("order %v is not archived", id)
+			}
+			if err != nil {
+				return fmt.Errorf("order %v: %w", id, err)
+			}
+			fmt.Printf("orders: restored %v\n", id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package breaker
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"ecom.com/errors"
+)
+
+type State string
+
+const (
+	CLOSED    State = "closed"
+	OPEN      State = "open"
+	HALF_OPEN State = "half_open"
+)
+
+// CircuitBreaker stops calls to a failing dependency. After failureThreshold
+// consecutive failures it opens and rejects calls with errors.ErrCircuitOpen.
+// Once openTimeout has passed it goes half open and lets up to
+// halfOpenSuccesses calls at a time through as probes, rejecting the rest.
+// halfOpenSuccesses successful probes close it again while a single failed
+// probe opens it again.
+type CircuitBreaker struct {
+	name              string
+	failureThreshold  int
+	openTimeout       time.Duration
+	halfOpenSuccesses int
+	isFailure         func(error) bool
+
+	mu        sync.Mutex
+	state     State
+	failures  int
+	successes int
+	openedAt  time.Time
+	trips     int64
+	probes    int // in flight while half open
+	round     int // counts half open periods, a probe belongs to one
+}
+
+// New returns a closed breaker. isFailure decides which errors count against
+// the dependency, e.g. a missing row should not trip it. A failureThreshold
+// of zero or less disables the breaker.
+func New(name string, failureThreshold int, openTimeout time.Duration, halfOpenSuccesses int, isFailure func(error) bool) *CircuitBreaker {
+	if halfOpenSuccesses < 1 {
+		halfOpenSuccesses = 1
+	}
+	return &CircuitBreaker{
+		name:              name,
+		failureThreshold:  failureThreshold,
+		openTimeout:       openTimeout,
+		halfOpenSuccesses: halfOpenSuccesses,
+		isFailure:         isFailure,
+		state:             CLOSED,
+	}
+}
+
+// Execute runs fn unless the breaker is open, or half open with all probes
+// in flight.
+func (b *CircuitBreaker) Execute(fn func() error) error {
+	round, ok := b.allow()
+	if !ok {
+		return errors.ErrCircuitOpen
+	}
+	if round > 0 {
+		// Also when fn panics, or the breaker would stay half open for good.
+		defer b.release(round)
+	}
+	err := fn()
+	b.report(err)
+	return err
+}
+
+func (b *CircuitBreaker) Name() string {
+	return b.name
+}
+
+func (b *CircuitBreaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.halfOpenIfDue()
+	return b.state
+}
+
+// Trips returns how many times the breaker has opened.
+func (b *CircuitBreaker) Trips() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.trips
+}
+
+// allow returns the half open round a probe belongs to, 0 for a call on a
+// closed breaker.
+func (b *CircuitBreaker) allow() (int, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.halfOpenIfDue()
+	switch b.state {
+	case OPEN:
+		return 0, false
+	case HALF_OPEN:
+		if b.probes >= b.halfOpenSuccesses {
+			return 0, false
+		}
+		b.probes++
+		return b.round, true
+	}
+	return 0, true
+}
+
+// release frees the place of a finished probe, unless the breaker has moved
+// on to another round since.
+func (b *CircuitBreaker) release(round int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if round == b.round && b.probes > 0 {
+		b.probes--
+	}
+}
+
+func (b *CircuitBreaker) report(err error) {
+	if b.failureThreshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil && b.isFailure(err) {
+		b.successes = 0
+		b.failures++
+		if b.state == HALF_OPEN || b.failures >= b.failureThreshold {
+			b.open()
+		}
+		return
+	}
+	b.failures = 0
+	if b.state == HALF_OPEN {
+		b.successes++
+		if b.successes >= b.halfOpenSuccesses {
+			b.state = CLOSED
+			b.successes = 0
+			log.Printf("Circuit breaker %s closed", b.name)
+		}
+	}
+}
+
+func (b *CircuitBreaker) open() {
+	if b.state != OPEN {
+		b.trips++
+		log.Printf("Circuit breaker %s opened", b.name)
+	}
+	b.state = OPEN
+	b.openedAt = time.Now()
+	b.failures = 0
+}
+
+func (b *CircuitBreaker) halfOpenIfDue() {
+	if b.state == OPEN && time.Since(b.openedAt) >= b.openTimeout {
+		b.state = HALF_OPEN
+		b.successes = 0
+		b.probes = 0
+		b.round++
+	}
+}
+
+// BatchError turns the per item results of a batch call into a single result
+// for the breaker. The batch only counts as failed if every item failed, one
+// bad row is not a sign of a failing dependency.
+func BatchError(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+	}
+	return errs[0]
+}
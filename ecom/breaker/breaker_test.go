@@ -0,0 +1,159 @@
+package breaker
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	ecomerrors "ecom.com/errors"
+)
+
+var errDown = errors.New("database is down")
+
+func isFailure(err error) bool {
+	return err != nil && err != sql.ErrNoRows
+}
+
+func TestCircuitBreaker_Execute(t *testing.T) {
+	tests := []struct {
+		name      string
+		calls     []error
+		wait      time.Duration
+		wantState State
+		wantTrips int64
+	}{
+		{
+			name:      "stays closed below threshold",
+			calls:     []error{errDown, errDown},
+			wantState: CLOSED,
+		},
+		{
+			name:      "expected errors do not count",
+			calls:     []error{sql.ErrNoRows, sql.ErrNoRows, sql.ErrNoRows, sql.ErrNoRows},
+			wantState: CLOSED,
+		},
+		{
+			name:      "success resets failures",
+			calls:     []error{errDown, errDown, nil, errDown, errDown},
+			wantState: CLOSED,
+		},
+		{
+			name:      "opens at threshold",
+			calls:     []error{errDown, errDown, errDown},
+			wantState: OPEN,
+			wantTrips: 1,
+		},
+		{
+			name:      "half open after timeout",
+			calls:     []error{errDown, errDown, errDown},
+			wait:      60 * time.Millisecond,
+			wantState: HALF_OPEN,
+			wantTrips: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New("test", 3, 50*time.Millisecond, 2, isFailure)
+			for _, callErr := range tt.calls {
+				callErr := callErr
+				b.Execute(func() error { return callErr })
+			}
+			time.Sleep(tt.wait)
+			if got := b.State(); got != tt.wantState {
+				t.Errorf("State() = %v, want %v", got, tt.wantState)
+			}
+			if got := b.Trips(); got != tt.wantTrips {
+				t.Errorf("Trips() = %v, want %v", got, tt.wantTrips)
+			}
+		})
+	}
+}
+
+func TestCircuitBreaker_HalfOpenProbes(t *testing.T) {
+	b := New("test", 1, 20*time.Millisecond, 2, isFailure)
+	b.Execute(func() error { return errDown })
+
+	called := false
+	if err := b.Execute(func() error { called = true; return nil }); err != ecomerrors.ErrCircuitOpen || called {
+		t.Fatalf("Execute() on open breaker = %v, called %v, want %v", err, called, ecomerrors.ErrCircuitOpen)
+	}
+
+	// A failed probe opens it again.
+	time.Sleep(30 * time.Millisecond)
+	b.Execute(func() error { return errDown })
+	if got := b.State(); got != OPEN {
+		t.Fatalf("State() after failed probe = %v, want %v", got, OPEN)
+	}
+
+	// Two good probes close it.
+	time.Sleep(30 * time.Millisecond)
+	b.Execute(func() error { return nil })
+	if got := b.State(); got != HALF_OPEN {
+		t.Fatalf("State() after one probe = %v, want %v", got, HALF_OPEN)
+	}
+	b.Execute(func() error { return nil })
+	if got := b.State(); got != CLOSED {
+		t.Errorf("State() after two probes = %v, want %v", got, CLOSED)
+	}
+	if got := b.Trips(); got != 2 {
+		t.Errorf("Trips() = %v, want 2", got)
+	}
+}
+
+func TestCircuitBreaker_HalfOpenLimitsProbes(t *testing.T) {
+	b := New("test", 1, 20*time.Millisecond, 2, isFailure)
+	b.Execute(func() error { return errDown })
+	time.Sleep(30 * time.Millisecond)
+
+	// Two probes in flight, a third call is rejected.
+	started, finish := make(chan struct{}), make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.Execute(func() error {
+				started <- struct{}{}
+				<-finish
+				return nil
+			})
+		}()
+		<-started
+	}
+	called := false
+	if err := b.Execute(func() error { called = true; return nil }); err != ecomerrors.ErrCircuitOpen || called {
+		t.Errorf("Execute() with all probes in flight = %v, called %v, want %v", err, called, ecomerrors.ErrCircuitOpen)
+	}
+	close(finish)
+	wg.Wait()
+	if got := b.State(); got != CLOSED {
+		t.Errorf("State() after the probes = %v, want %v", got, CLOSED)
+	}
+
+	// A panicking probe gives its place back.
+	single := New("test", 1, 20*time.Millisecond, 1, isFailure)
+	single.Execute(func() error { return errDown })
+	time.Sleep(30 * time.Millisecond)
+	func() {
+		defer func() { recover() }()
+		single.Execute(func() error { panic("boom") })
+	}()
+	if err := single.Execute(func() error { return nil }); err != nil {
+		t.Errorf("Execute() after a panicking probe = %v, want nil", err)
+	}
+	if got := single.State(); got != CLOSED {
+		t.Errorf("State() = %v, want %v", got, CLOSED)
+	}
+}
+
+func TestCircuitBreaker_Disabled(t *testing.T) {
+	b := New("test", 0, time.Second, 1, isFailure)
+	for i := 0; i < 10; i++ {
+		b.Execute(func() error { return errDown })
+	}
+	if got := b.State(); got != CLOSED {
+		t.Errorf("State() = %v, want %v", got, CLOSED)
+	}
+}
@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"time"
+
+	"ecom.com/breaker"
+	"ecom.com/common"
+	"ecom.com/errors"
+)
+
+// IsFailure reports whether err means the cache is in trouble, a miss is not.
+func IsFailure(err error) bool {
+	return err != nil && err != errors.ErrNotFound && err != errors.ErrStaleWrite && err != errors.ErrCachedNotFound
+}
+
+type BreakerCache struct {
+	cache   CacheI
+	breaker *breaker.CircuitBreaker
+}
+
+func NewBreakerCache(cache CacheI, b *breaker.CircuitBreaker) CacheI {
+	return &BreakerCache{cache: cache, breaker: b}
+}
+
+func (c *BreakerCache) SetOrderStatus(orderID, status string) error {
+	return c.breaker.Execute(func() error {
+		return c.cache.SetOrderStatus(orderID, status)
+	})
+}
+
+func (c *BreakerCache) GetOrderStatus(orderID string) (string, error) {
+	var status string
+	err := c.breaker.Execute(func() error {
+		var err error
+		status, err = c.cache.GetOrderStatus(orderID)
+		return err
+	})
+	return status, err
+}
+
+func (c *BreakerCache) SetOrderNotFound(orderID string, ttl time.Duration) error {
+	return c.breaker.Execute(func() error {
+		return c.cache.SetOrderNotFound(orderID, ttl)
+	})
+}
+
+func (c *BreakerCache) DeleteOrderStatus(orderID string) error {
+	return c.breaker.Execute(func() error {
+		return c.cache.DeleteOrderStatus(orderID)
+	})
+}
+
+func (c *BreakerCache) ReplaceOrderStatus(orderID, expected, status string) error {
+	return c.breaker.Execute(func() error {
+		return c.cache.ReplaceOrderStatus(orderID, expected, status)
+	})
+}
+
+func (c *BreakerCache) SampleOrderIDs(n int) ([]string, error) {
+	var ids []string
+	err := c.breaker.Execute(func() error {
+		var err error
+		ids, err = c.cache.SampleOrderIDs(n)
+		return err
+	})
+	return ids, err
+}
+
+func (c *BreakerCache) GetOrder(orderID string) (*common.OrderResponse, int64, error) {
+	var order *common.OrderResponse
+	var version int64
+	err := c.breaker.Execute(func() error {
+		var err error
+		order, version, err = c.cache.GetOrder(orderID)
+		return err
+	})
+	return order, version, err
+}
+
+func (c *BreakerCache) SetOrder(order *common.OrderResponse, version int64) error {
+	return c.breaker.Execute(func() error {
+		return c.cache.SetOrder(order, version)
+	})
+}
+
+func (c *BreakerCache) InvalidateOrder(orderID string) error {
+	return c.breaker.Execute(func() error {
+		return c.cache.InvalidateOrder(orderID)
+	})
+}
+
+func (c *BreakerCache) Stats() Stats {
+	return c.cache.Stats()
+}
+
+func (c *BreakerCache) Close() error {
+	return c.cache.Close()
+}
@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"log"
+	"time"
+
+	"ecom.com/common"
+	"ecom.com/errors"
+)
+
+// Broadcast keeps the local caches of several instances in step. Every
+// change it makes is published on the bus, and changes published by other
+// instances are applied to the wrapped cache. Status updates are applied
+// as is, the cache already refuses to move a status backwards.
+type Broadcast struct {
+	cache       CacheI
+	bus         BusI
+	instanceID  string
+	unsubscribe func()
+}
+
+func NewBroadcastCache(cache CacheI, bus BusI, instanceID string) (CacheI, error) {
+	b := &Broadcast{cache: cache, bus: bus, instanceID: instanceID}
+	unsubscribe, err := bus.Subscribe(b.apply)
+	if err != nil {
+		return nil, err
+	}
+	b.unsubscribe = unsubscribe
+	return b, nil
+}
+
+func (b *Broadcast) SetOrderStatus(orderID, status string) error {
+	if err := b.cache.SetOrderStatus(orderID, status); err != nil {
+		return err
+	}
+	b.publish(Invalidation{Op: opSetStatus, OrderID: orderID, Status: status})
+	return nil
+}
+
+func (b *Broadcast) GetOrderStatus(orderID string) (string, error) {
+	return b.cache.GetOrderStatus(orderID)
+}
+
+// SetOrderNotFound stays local, other instances find out on their own. The
+// status set when the order is created is broadcast and clears them all.
+func (b *Broadcast) SetOrderNotFound(orderID string, ttl time.Duration) error {
+	return b.cache.SetOrderNotFound(orderID, ttl)
+}
+
+func (b *Broadcast) DeleteOrderStatus(orderID string) error {
+	if err := b.cache.DeleteOrderStatus(orderID); err != nil {
+		return err
+	}
+	b.publish(Invalidation{Op: opDeleteStatus, OrderID: orderID})
+	return nil
+}
+
+// ReplaceOrderStatus has the other instances drop their status, theirs may
+// not be the expected one and a status set on them would not move backwards.
+func (b *Broadcast) ReplaceOrderStatus(orderID, expected, status string) error {
+	if err := b.cache.ReplaceOrderStatus(orderID, expected, status); err != nil {
+		return err
+	}
+	b.publish(Invalidation{Op: opDeleteStatus, OrderID: orderID})
+	return nil
+}
+
+func (b *Broadcast) SampleOrderIDs(n int) ([]string, error) {
+	return b.cache.SampleOrderIDs(n)
+}
+
+func (b *Broadcast) GetOrder(orderID string) (*common.OrderResponse, int64, error) {
+	return b.cache.GetOrder(orderID)
+}
+
+func (b *Broadcast) SetOrder(order *common.OrderResponse, version int64) error {
+	return b.cache.SetOrder(order, version)
+}
+
+func (b *Broadcast) InvalidateOrder(orderID string) error {
+	if err := b.cache.InvalidateOrder(orderID); err != nil {
+		return err
+	}
+	b.publish(Invalidation{Op: opInvalidateOrder, OrderID: orderID})
+	return nil
+}
+
+func (b *Broadcast) Stats() Stats {
+	return b.cache.Stats()
+}
+
+func (b *Broadcast) Close() error {
+	b.unsubscribe()
+	if err := b.bus.Close(); err != nil {
+		log.Printf("Error closing invalidation bus: %v", err)
+	}
+	return b.cache.Close()
+}
+
+// publish does not fail the write, the local cache is already updated and
+// the other instances fall back on TTLs and the reconciler.
+func (b *Broadcast) publish(msg Invalidation) {
+	msg.Source = b.instanceID
+	if err := b.bus.Publish(msg); err != nil {
+		log.Printf("Error publishing invalidation %+v: %v", msg, err)
+	}
+}
+
+// apply handles a message from the bus on the wrapped cache, so nothing is
+// published again.
+func (b *Broadcast) apply(msg Invalidation) {
+	if msg.Source == b.instanceID {
+		return
+	}
+	var err error
+	switch msg.Op {
+	case opSetStatus:
+		err = b.cache.SetOrderStatus(msg.OrderID, msg.Status)
+	case opDeleteStatus:
+		err = b.cache.DeleteOrderStatus(msg.OrderID)
+	case opInvalidateOrder:
+		err = b.cache.InvalidateOrder(msg.OrderID)
+	default:
+		log.Printf("Dropping invalidation with unknown op %+v", msg)
+		return
+	}
+	if err != nil && err != errors.ErrStaleWrite {
+		log.Printf("Error applying invalidation %+v: %v", msg, err)
+	}
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"ecom.com/common"
+	"ecom.com/errors"
+)
+
+// newInstances returns n memory caches sharing bus, as n app instances would.
+func newInstances(t *testing.T, bus BusI, n int) []CacheI {
+	instances := []CacheI{}
+	for i := 0; i < n; i++ {
+		c, err := NewBroadcastCache(NewMemory(MemoryOptions{}), bus, string(rune('a'+i)))
+		if err != nil {
+			t.Fatalf("NewBroadcastCache() error = %v", err)
+		}
+		instances = append(instances, c)
+	}
+	return instances
+}
+
+// eventually polls cond, the Redis bus delivers asynchronously.
+func eventually(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBroadcast_SharesChanges(t *testing.T) {
+	buses := map[string]func() BusI{
+		"local": NewLocalBus,
+		"redis": func() BusI {
+			_, server := newTestRedis(t)
+			return NewRedisBus(RedisOptions{Addr: server.Addr()})
+		},
+	}
+	for busName, newBus := range buses {
+		t.Run(busName, func(t *testing.T) {
+			instances := newInstances(t, newBus(), 3)
+			a, b, c := instances[0], instances[1], instances[2]
+
+			if err := a.SetOrderStatus("o1", "Pending"); err != nil {
+				t.Fatalf("SetOrderStatus() error = %v", err)
+			}
+			eventually(t, func() bool {
+				bStatus, _ := b.GetOrderStatus("o1")
+				cStatus, _ := c.GetOrderStatus("o1")
+				return bStatus == "Pending" && cStatus == "Pending"
+			}, "status set on one instance did not reach the others")
+
+			if err := b.SetOrderStatus("o1", "Completed"); err != nil {
+				t.Fatalf("SetOrderStatus() error = %v", err)
+			}
+			eventually(t, func() bool {
+				aStatus, _ := a.GetOrderStatus("o1")
+				cStatus, _ := c.GetOrderStatus("o1")
+				return aStatus == "Completed" && cStatus == "Completed"
+			}, "status update did not reach the other instances")
+
+			// A stale write is neither applied nor broadcast.
+			if err := c.SetOrderStatus("o1", "Processing"); err != errors.ErrStaleWrite {
+				t.Errorf("SetOrderStatus() backwards error = %v, want %v", err, errors.ErrStaleWrite)
+			}
+
+			if err := a.SetOrder(&common.OrderResponse{OrderID: "o1", Status: "Completed"}, 0); err != nil {
+				t.Fatalf("SetOrder() error = %v", err)
+			}
+			if err := c.InvalidateOrder("o1"); err != nil {
+				t.Fatalf("InvalidateOrder() error = %v", err)
+			}
+			eventually(t, func() bool {
+				_, version, err := a.GetOrder("o1")
+				return err == errors.ErrNotFound && version == 1
+			}, "invalidation did not reach the other instances")
+
+			if err := a.DeleteOrderStatus("o1"); err != nil {
+				t.Fatalf("DeleteOrderStatus() error = %v", err)
+			}
+			eventually(t, func() bool {
+				_, bErr := b.GetOrderStatus("o1")
+				_, cErr := c.GetOrderStatus("o1")
+				return bErr == errors.ErrNotFound && cErr == errors.ErrNotFound
+			}, "delete did not reach the other instances")
+
+			for _, instance := range instances {
+				instance.Close()
+			}
+		})
+	}
+}
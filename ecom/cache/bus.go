@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"sync"
+)
+
+// Operations carried by an Invalidation.
+const (
+	opSetStatus       = "set_status"
+	opDeleteStatus    = "delete_status"
+	opInvalidateOrder = "invalidate_order"
+)
+
+// Invalidation tells the other instances that an order changed in the cache
+// of Source.
+type Invalidation struct {
+	Source  string `json:"source"` // instance that made the change
+	Op      string `json:"op"`
+	OrderID string `json:"order_id"`
+	Status  string `json:"status,omitempty"` // set_status only
+}
+
+// BusI carries invalidations between the instances sharing a DB. Every
+// subscriber gets every published message, its own included.
+type BusI interface {
+	Publish(msg Invalidation) error
+	// Subscribe calls handler for each message until the returned func is
+	// called.
+	Subscribe(handler func(Invalidation)) (func(), error)
+	Close() error
+}
+
+// LocalBus delivers messages synchronously to subscribers in the same
+// process. It stands in for a real broker in tests and single host setups.
+type LocalBus struct {
+	handlers map[int]func(Invalidation)
+	nextID   int
+	mutex    *sync.RWMutex
+}
+
+func NewLocalBus() BusI {
+	return &LocalBus{
+		handlers: map[int]func(Invalidation){},
+		mutex:    &sync.RWMutex{},
+	}
+}
+
+func (b *LocalBus) Publish(msg Invalidation) error {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for _, handler := range b.handlers {
+		handler(msg)
+	}
+	return nil
+}
+
+func (b *LocalBus) Subscribe(handler func(Invalidation)) (func(), error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	id := b.nextID
+	b.nextID++
+	b.handlers[id] = handler
+	return func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		delete(b.handlers, id)
+	}, nil
+}
+
+func (b *LocalBus) Close() error {
+	return nil
+}
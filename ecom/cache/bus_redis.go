@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/go-redis/redis"
+)
+
+const invalidationChannel = "cache:invalidations"
+
+// RedisBus publishes invalidations on a Redis pub/sub channel. Delivery is
+// at most once, an instance that is disconnected misses messages and relies
+// on TTLs and the reconciler to catch up.
+type RedisBus struct {
+	client *redis.Client
+}
+
+func NewRedisBus(opts RedisOptions) BusI {
+	return &RedisBus{
+		client: redis.NewClient(&redis.Options{
+			Addr:         opts.Addr,
+			Password:     opts.Password,
+			DB:           opts.DB,
+			DialTimeout:  opts.DialTimeout,
+			ReadTimeout:  opts.ReadTimeout,
+			WriteTimeout: opts.WriteTimeout,
+			PoolSize:     opts.PoolSize,
+		}),
+	}
+}
+
+func (b *RedisBus) Publish(msg Invalidation) error {
+	data, e := json.Marshal(msg)
+	if e != nil {
+		return e
+	}
+	return b.client.Publish(invalidationChannel, string(data)).Err()
+}
+
+func (b *RedisBus) Subscribe(handler func(Invalidation)) (func(), error) {
+	pubsub := b.client.Subscribe(invalidationChannel)
+	// Wait for the confirmation so nothing published after Subscribe
+	// returns is missed.
+	if _, e := pubsub.Receive(); e != nil {
+		pubsub.Close()
+		return nil, e
+	}
+	go func() {
+		for message := range pubsub.Channel() {
+			var msg Invalidation
+			if e := json.Unmarshal([]byte(message.Payload), &msg); e != nil {
+				log.Printf("Dropping malformed invalidation %q: %v", message.Payload, e)
+				continue
+			}
+			handler(msg)
+		}
+	}()
+	return func() { pubsub.Close() }, nil
+}
+
+func (b *RedisBus) Close() error {
+	return b.client.Close()
+}
@@ -1,6 +1,63 @@
 package cache
 
+import (
+	"time"
+
+	"ecom.com/common"
+)
+
 type CacheI interface {
+	// SetOrderStatus never moves an order backwards, a status ranking below
+	// the cached one is rejected with errors.ErrStaleWrite.
 	SetOrderStatus(orderID, status string) error
+	// GetOrderStatus returns errors.ErrCachedNotFound for orders cached as
+	// missing with SetOrderNotFound.
 	GetOrderStatus(orderID string) (string, error)
+	// SetOrderNotFound remembers for ttl that the order does not exist. Any
+	// status set later replaces it, an existing status is never replaced
+	// and errors.ErrStaleWrite is returned.
+	SetOrderNotFound(orderID string, ttl time.Duration) error
+	// DeleteOrderStatus drops the cached status, a missing entry is not an
+	// error.
+	DeleteOrderStatus(orderID string) error
+	// ReplaceOrderStatus sets status, also backwards, only if expected is
+	// still the cached status, otherwise it returns errors.ErrStaleWrite. An
+	// empty status drops the entry. For repairs that must not undo an update
+	// made since the cache was read.
+	ReplaceOrderStatus(orderID, expected, status string) error
+	// SampleOrderIDs returns up to n orders that have a cached status.
+	// Successive calls return different orders, so every entry is seen
+	// eventually.
+	SampleOrderIDs(n int) ([]string, error)
+	// GetOrder returns the cached order and its version. On a miss the
+	// version is still returned, it is the one to pass to SetOrder.
+	GetOrder(orderID string) (*common.OrderResponse, int64, error)
+	// SetOrder caches order only if its entry is still at version, i.e.
+	// nothing invalidated it since version was read. Otherwise it returns
+	// errors.ErrStaleWrite and leaves the newer entry alone.
+	SetOrder(order *common.OrderResponse, version int64) error
+	// InvalidateOrder drops the cached order and bumps its version, so fills
+	// based on data read before the change are rejected.
+	InvalidateOrder(orderID string) error
+	Stats() Stats
+	Close() error
+}
+
+// Stats are the counters a cache keeps about itself.
+type Stats struct {
+	Name        string // tier name, set in Tiers only
+	Entries     int64
+	Evictions   int64 // entries dropped to stay under the size limit
+	Expirations int64 // entries dropped because their TTL passed
+	Hits        int64 // status and order lookups served
+	Misses      int64
+	Tiers       []Stats // per tier of a tiered cache, nearest first
+}
+
+// HitRatio is the share of lookups that were hits, 0 before any lookup.
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
 }
@@ -0,0 +1,396 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"ecom.com/common"
+	"ecom.com/errors"
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestRedis(t *testing.T) (CacheI, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	c := NewRedis(RedisOptions{Addr: server.Addr(), ReadTimeout: time.Second})
+	t.Cleanup(func() { c.Close() })
+	return c, server
+}
+
+func TestCacheI_OrderStatus(t *testing.T) {
+	redisCache, _ := newTestRedis(t)
+	caches := map[string]CacheI{
+		"memory": NewMemory(MemoryOptions{}),
+		"redis":  redisCache,
+		"tiered": newTestTiered(t),
+	}
+	tests := []struct {
+		name    string
+		set     map[string]string
+		get     string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "hit",
+			set:  map[string]string{"o1": "Pending"},
+			get:  "o1",
+			want: "Pending",
+		},
+		{
+			name: "overwrite",
+			set:  map[string]string{"o2": "Pending", "o3": "Completed"},
+			get:  "o3",
+			want: "Completed",
+		},
+		{
+			name:    "miss",
+			get:     "unknown",
+			wantErr: errors.ErrNotFound,
+		},
+	}
+	for cacheName, c := range caches {
+		for _, tt := range tests {
+			t.Run(cacheName+"/"+tt.name, func(t *testing.T) {
+				for id, status := range tt.set {
+					if err := c.SetOrderStatus(id, status); err != nil {
+						t.Fatalf("SetOrderStatus() error = %v", err)
+					}
+				}
+				got, err := c.GetOrderStatus(tt.get)
+				if err != tt.wantErr {
+					t.Errorf("GetOrderStatus() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if got != tt.want {
+					t.Errorf("GetOrderStatus() = %v, want %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestCacheI_ReplaceOrderStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		status   string
+		wantErr  error
+		want     string // "" means not cached
+	}{
+		{name: "backwards", expected: "Completed", status: "Pending", want: "Pending"},
+		{name: "drop", expected: "Completed", status: ""},
+		{name: "moved on", expected: "Processing", status: "Pending", wantErr: errors.ErrStaleWrite, want: "Completed"},
+	}
+	for _, tt := range tests {
+		redisCache, _ := newTestRedis(t)
+		caches := map[string]CacheI{
+			"memory": NewMemory(MemoryOptions{}),
+			"redis":  redisCache,
+			"tiered": newTestTiered(t),
+		}
+		for cacheName, c := range caches {
+			t.Run(cacheName+"/"+tt.name, func(t *testing.T) {
+				if err := c.SetOrderStatus("o1", "Completed"); err != nil {
+					t.Fatalf("SetOrderStatus() error = %v", err)
+				}
+				if err := c.ReplaceOrderStatus("o1", tt.expected, tt.status); err != tt.wantErr {
+					t.Errorf("ReplaceOrderStatus() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				got, err := c.GetOrderStatus("o1")
+				if tt.want == "" && err != errors.ErrNotFound {
+					t.Errorf("GetOrderStatus() = %v, %v, want %v", got, err, errors.ErrNotFound)
+				}
+				if tt.want != "" && (err != nil || got != tt.want) {
+					t.Errorf("GetOrderStatus() = %v, %v, want %v", got, err, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestRedis_ServerDown(t *testing.T) {
+	c, server := newTestRedis(t)
+	if err := c.SetOrderStatus("o1", "Pending"); err != nil {
+		t.Fatalf("SetOrderStatus() error = %v", err)
+	}
+	if got, _ := server.Get(orderStatusKeyPrefix + "o1"); got != "Pending" {
+		t.Errorf("stored value = %q, want %q", got, "Pending")
+	}
+
+	server.Close()
+	_, err := c.GetOrderStatus("o1")
+	if err == nil || err == errors.ErrNotFound {
+		t.Errorf("GetOrderStatus() with server down error = %v, want connection error", err)
+	}
+	if !IsFailure(err) {
+		t.Errorf("IsFailure(%v) = false, want true", err)
+	}
+}
+
+func TestRedis_TTL(t *testing.T) {
+	server := miniredis.RunT(t)
+	c := NewRedis(RedisOptions{Addr: server.Addr(), TTL: 10 * time.Second, CompletedTTL: time.Second})
+	defer c.Close()
+	if err := c.SetOrderStatus("pending", "Pending"); err != nil {
+		t.Fatalf("SetOrderStatus() error = %v", err)
+	}
+	if err := c.SetOrderStatus("completed", "Completed"); err != nil {
+		t.Fatalf("SetOrderStatus() error = %v", err)
+	}
+	if err := c.SetOrder(&common.OrderResponse{OrderID: "completed", Status: "Completed"}, 0); err != nil {
+		t.Fatalf("SetOrder() error = %v", err)
+	}
+	if err := c.InvalidateOrder("pending"); err != nil {
+		t.Fatalf("InvalidateOrder() error = %v", err)
+	}
+
+	server.FastForward(time.Second)
+	if server.Exists(orderStatusKeyPrefix+"completed") || server.Exists(orderDataKeyPrefix+"completed") {
+		t.Errorf("completed order keys still there after CompletedTTL")
+	}
+	if !server.Exists(orderStatusKeyPrefix+"pending") || !server.Exists(orderDataKeyPrefix+"pending") {
+		t.Errorf("pending order keys gone before TTL")
+	}
+	server.FastForward(9 * time.Second)
+	if server.Exists(orderStatusKeyPrefix+"pending") || server.Exists(orderDataKeyPrefix+"pending") {
+		t.Errorf("pending order keys still there after TTL")
+	}
+
+	// Without a TTL keys are kept.
+	forever, foreverServer := newTestRedis(t)
+	if err := forever.SetOrderStatus("o1", "Pending"); err != nil {
+		t.Fatalf("SetOrderStatus() error = %v", err)
+	}
+	if ttl := foreverServer.TTL(orderStatusKeyPrefix + "o1"); ttl != 0 {
+		t.Errorf("TTL() without a configured TTL = %v, want none", ttl)
+	}
+}
+
+func TestCacheI_OrderVersioning(t *testing.T) {
+	redisCache, _ := newTestRedis(t)
+	caches := map[string]CacheI{
+		"memory": NewMemory(MemoryOptions{}),
+		"redis":  redisCache,
+		"tiered": newTestTiered(t),
+	}
+	pending := &common.OrderResponse{OrderID: "o1", UserID: "u1", ItemIDs: []string{"i1"}, TotalAmount: 10, Status: "Pending"}
+	completed := &common.OrderResponse{OrderID: "o1", UserID: "u1", ItemIDs: []string{"i1"}, TotalAmount: 10, Status: "Completed"}
+	for cacheName, c := range caches {
+		t.Run(cacheName, func(t *testing.T) {
+			_, version, err := c.GetOrder("o1")
+			if err != errors.ErrNotFound || version != 0 {
+				t.Fatalf("GetOrder() on empty cache = %v, %v, want version 0 and %v", version, err, errors.ErrNotFound)
+			}
+			// A reader fills the cache with what it read at version 0.
+			if err := c.SetOrder(pending, version); err != nil {
+				t.Fatalf("SetOrder() error = %v", err)
+			}
+			got, _, err := c.GetOrder("o1")
+			if err != nil || !reflect.DeepEqual(got, pending) {
+				t.Fatalf("GetOrder() = %v, %v, want %v", got, err, pending)
+			}
+
+			// The order changes, a slow reader that saw version 0 must not win.
+			if err := c.InvalidateOrder("o1"); err != nil {
+				t.Fatalf("InvalidateOrder() error = %v", err)
+			}
+			_, newVersion, err := c.GetOrder("o1")
+			if err != errors.ErrNotFound || newVersion != 1 {
+				t.Fatalf("GetOrder() after invalidation = %v, %v, want version 1 and %v", newVersion, err, errors.ErrNotFound)
+			}
+			if err := c.SetOrder(pending, version); err != errors.ErrStaleWrite {
+				t.Errorf("SetOrder() with old version error = %v, want %v", err, errors.ErrStaleWrite)
+			}
+			if err := c.SetOrder(completed, newVersion); err != nil {
+				t.Fatalf("SetOrder() with new version error = %v", err)
+			}
+			got, _, err = c.GetOrder("o1")
+			if err != nil || !reflect.DeepEqual(got, completed) {
+				t.Errorf("GetOrder() = %v, %v, want %v", got, err, completed)
+			}
+		})
+	}
+}
+
+func TestCacheI_OrderStatusMonotonic(t *testing.T) {
+	tests := []struct {
+		name    string
+		writes  []string
+		wantErr []error
+		want    string
+	}{
+		{
+			name:    "forward",
+			writes:  []string{"Pending", "Processing", "Completed"},
+			wantErr: []error{nil, nil, nil},
+			want:    "Completed",
+		},
+		{
+			name:    "late pending after completed",
+			writes:  []string{"Pending", "Completed", "Pending"},
+			wantErr: []error{nil, nil, errors.ErrStaleWrite},
+			want:    "Completed",
+		},
+		{
+			name:    "late processing after completed",
+			writes:  []string{"Completed", "Processing"},
+			wantErr: []error{nil, errors.ErrStaleWrite},
+			want:    "Completed",
+		},
+		{
+			name:    "same status again",
+			writes:  []string{"Processing", "Processing"},
+			wantErr: []error{nil, nil},
+			want:    "Processing",
+		},
+		{
+			name:    "skip ahead",
+			writes:  []string{"Pending", "Completed"},
+			wantErr: []error{nil, nil},
+			want:    "Completed",
+		},
+	}
+	for _, tt := range tests {
+		redisCache, _ := newTestRedis(t)
+		caches := map[string]CacheI{
+			"memory": NewMemory(MemoryOptions{}),
+			"redis":  redisCache,
+			"tiered": newTestTiered(t),
+		}
+		for cacheName, c := range caches {
+			t.Run(cacheName+"/"+tt.name, func(t *testing.T) {
+				for i, status := range tt.writes {
+					if err := c.SetOrderStatus("o1", status); err != tt.wantErr[i] {
+						t.Errorf("SetOrderStatus(%v) error = %v, wantErr %v", status, err, tt.wantErr[i])
+					}
+				}
+				got, err := c.GetOrderStatus("o1")
+				if err != nil || got != tt.want {
+					t.Errorf("GetOrderStatus() = %v, %v, want %v", got, err, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestCacheI_SampleAndDeleteOrderStatus(t *testing.T) {
+	redisCache, _ := newTestRedis(t)
+	caches := map[string]CacheI{
+		"memory": NewMemory(MemoryOptions{}),
+		"redis":  redisCache,
+		"tiered": newTestTiered(t),
+	}
+	for cacheName, c := range caches {
+		t.Run(cacheName, func(t *testing.T) {
+			want := map[string]bool{}
+			for _, id := range []string{"o1", "o2", "o3", "o4", "o5"} {
+				want[id] = true
+				if err := c.SetOrderStatus(id, "Pending"); err != nil {
+					t.Fatalf("SetOrderStatus() error = %v", err)
+				}
+			}
+			// Cached order bodies are not statuses and must not be sampled.
+			if err := c.InvalidateOrder("o1"); err != nil {
+				t.Fatalf("InvalidateOrder() error = %v", err)
+			}
+
+			ids, err := c.SampleOrderIDs(2)
+			if err != nil || len(ids) == 0 || len(ids) > 2 {
+				t.Fatalf("SampleOrderIDs(2) = %v, %v, want 1 or 2 ids", ids, err)
+			}
+			ids, err = c.SampleOrderIDs(10)
+			if err != nil {
+				t.Fatalf("SampleOrderIDs(10) error = %v", err)
+			}
+			seen := map[string]bool{}
+			for _, id := range ids {
+				if !want[id] {
+					t.Errorf("SampleOrderIDs() returned unknown id %q", id)
+				}
+				seen[id] = true
+			}
+			if len(seen) > len(want) {
+				t.Errorf("SampleOrderIDs() = %v, more ids than cached", ids)
+			}
+
+			if err := c.DeleteOrderStatus("o2"); err != nil {
+				t.Fatalf("DeleteOrderStatus() error = %v", err)
+			}
+			if _, err := c.GetOrderStatus("o2"); err != errors.ErrNotFound {
+				t.Errorf("GetOrderStatus() after delete error = %v, want %v", err, errors.ErrNotFound)
+			}
+			if err := c.DeleteOrderStatus("o2"); err != nil {
+				t.Errorf("DeleteOrderStatus() of missing entry error = %v", err)
+			}
+			// A deleted status can be set to anything again.
+			if err := c.SetOrderStatus("o3", "Completed"); err != nil {
+				t.Fatalf("SetOrderStatus() error = %v", err)
+			}
+			if err := c.DeleteOrderStatus("o3"); err != nil {
+				t.Fatalf("DeleteOrderStatus() error = %v", err)
+			}
+			if err := c.SetOrderStatus("o3", "Pending"); err != nil {
+				t.Errorf("SetOrderStatus() after delete error = %v", err)
+			}
+		})
+	}
+}
+
+func TestCacheI_OrderNotFound(t *testing.T) {
+	redisCache, _ := newTestRedis(t)
+	caches := map[string]CacheI{
+		"memory": NewMemory(MemoryOptions{}),
+		"redis":  redisCache,
+		"tiered": newTestTiered(t),
+	}
+	for cacheName, c := range caches {
+		t.Run(cacheName, func(t *testing.T) {
+			if err := c.SetOrderNotFound("o1", time.Minute); err != nil {
+				t.Fatalf("SetOrderNotFound() error = %v", err)
+			}
+			if _, err := c.GetOrderStatus("o1"); err != errors.ErrCachedNotFound {
+				t.Errorf("GetOrderStatus() error = %v, want %v", err, errors.ErrCachedNotFound)
+			}
+			if IsFailure(errors.ErrCachedNotFound) {
+				t.Errorf("IsFailure(%v) = true, want false", errors.ErrCachedNotFound)
+			}
+			// The order gets created.
+			if err := c.SetOrderStatus("o1", "Pending"); err != nil {
+				t.Fatalf("SetOrderStatus() error = %v", err)
+			}
+			if got, err := c.GetOrderStatus("o1"); err != nil || got != "Pending" {
+				t.Errorf("GetOrderStatus() after create = %v, %v, want Pending", got, err)
+			}
+			// A lookup that raced with the create cannot hide it again.
+			if err := c.SetOrderNotFound("o1", time.Minute); err != errors.ErrStaleWrite {
+				t.Errorf("SetOrderNotFound() on existing order error = %v, want %v", err, errors.ErrStaleWrite)
+			}
+			if got, err := c.GetOrderStatus("o1"); err != nil || got != "Pending" {
+				t.Errorf("GetOrderStatus() = %v, %v, want Pending", got, err)
+			}
+		})
+	}
+}
+
+func TestCacheI_OrderNotFoundExpires(t *testing.T) {
+	redisCache, server := newTestRedis(t)
+	memory, clock := newTestMemory(MemoryOptions{})
+	caches := map[string]struct {
+		cache   CacheI
+		advance func(time.Duration)
+	}{
+		"memory": {cache: memory, advance: func(d time.Duration) { clock.now = clock.now.Add(d) }},
+		"redis":  {cache: redisCache, advance: server.FastForward},
+	}
+	for cacheName, c := range caches {
+		t.Run(cacheName, func(t *testing.T) {
+			if err := c.cache.SetOrderNotFound("o1", time.Second); err != nil {
+				t.Fatalf("SetOrderNotFound() error = %v", err)
+			}
+			c.advance(time.Second)
+			if _, err := c.cache.GetOrderStatus("o1"); err != errors.ErrNotFound {
+				t.Errorf("GetOrderStatus() after TTL error = %v, want %v", err, errors.ErrNotFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,368 @@
+package cache
+
+import (
+	"container/list"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"ecom.com/common"
+	"ecom.com/constants"
+	err "ecom.com/errors"
+)
+
+// MemoryOptions bound the memory used by a Memory cache. Zero values mean
+// no limit.
+type MemoryOptions struct {
+	MaxEntries      int           // least recently used entries are evicted above this
+	TTL             time.Duration // lifetime of in-flight orders
+	CompletedTTL    time.Duration // lifetime of completed orders, defaults to TTL
+	CleanupInterval time.Duration // how often expired entries are swept
+}
+
+// Order entries live next to the status entries under their own prefix.
+const orderKeyPrefix = "order:"
+
+type memoryEntry struct {
+	key       string
+	value     string // an empty status marks an order cached as not found
+	version   int64
+	expiresAt time.Time // zero means never
+}
+
+// versionEntry keeps the version of an order that is not cached, because it
+// was invalidated, evicted or expired. Were it 0 again, a fill that read 0
+// before the invalidation would pass the stale write check.
+type versionEntry struct {
+	version   int64
+	expiresAt time.Time     // zero means never
+	elem      *list.Element // in versionOrder
+}
+
+// Memory is an in-process CacheI backed by a Go map, for single instance
+// deployments and tests. Entries expire after their TTL and the least
+// recently used ones are evicted once MaxEntries is reached.
+type Memory struct {
+	opts     MemoryOptions
+	entries  map[string]*list.Element
+	lru      *list.List               // front is the most recently used
+	versions map[string]*versionEntry // of orders without an entry, they expire after TTL
+	// Keys of versions, oldest first. Above MaxEntries the oldest versions
+	// are dropped, only a fill that read one before and is still running
+	// could then pass the stale write check.
+	versionOrder *list.List
+	mutex        *sync.Mutex
+	evictions    int64
+	expirations  int64
+	hits         int64
+	misses       int64
+	now          func() time.Time
+	stopChan     chan struct{}
+}
+
+func NewMemory(opts MemoryOptions) CacheI {
+	if opts.CompletedTTL == 0 {
+		opts.CompletedTTL = opts.TTL
+	}
+	m := &Memory{
+		opts:         opts,
+		entries:      map[string]*list.Element{},
+		lru:          list.New(),
+		versions:     map[string]*versionEntry{},
+		versionOrder: list.New(),
+		mutex:        &sync.Mutex{},
+		now:          time.Now,
+		stopChan:     make(chan struct{}),
+	}
+	if opts.CleanupInterval > 0 {
+		go m.cleanup()
+	}
+	return m
+}
+
+func (m *Memory) SetOrderStatus(orderID, status string) error {
+	if m.entries == nil {
+		return err.ErrUnintializedInstance
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if current := m.get(orderID); current != nil && statusRank(current.value) > statusRank(status) {
+		return err.ErrStaleWrite
+	}
+	m.set(&memoryEntry{key: orderID, value: status, expiresAt: m.expiry(status)})
+	return nil
+}
+
+func (m *Memory) GetOrderStatus(orderID string) (string, error) {
+	if m.entries == nil {
+		return "", err.ErrUnintializedInstance
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	entry := m.get(orderID)
+	if entry == nil {
+		m.misses++
+		return "", err.ErrNotFound
+	}
+	m.hits++
+	if entry.value == "" {
+		return "", err.ErrCachedNotFound
+	}
+	return entry.value, nil
+}
+
+func (m *Memory) SetOrderNotFound(orderID string, ttl time.Duration) error {
+	if m.entries == nil {
+		return err.ErrUnintializedInstance
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if current := m.get(orderID); current != nil && current.value != "" {
+		return err.ErrStaleWrite
+	}
+	m.set(&memoryEntry{key: orderID, expiresAt: m.now().Add(ttl)})
+	return nil
+}
+
+func (m *Memory) DeleteOrderStatus(orderID string) error {
+	if m.entries == nil {
+		return err.ErrUnintializedInstance
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if elem, found := m.entries[orderID]; found {
+		m.remove(elem)
+	}
+	return nil
+}
+
+func (m *Memory) ReplaceOrderStatus(orderID, expected, status string) error {
+	if m.entries == nil {
+		return err.ErrUnintializedInstance
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	current := m.get(orderID)
+	if current == nil || current.value != expected {
+		return err.ErrStaleWrite
+	}
+	if status == "" {
+		m.remove(m.entries[orderID])
+		return nil
+	}
+	m.set(&memoryEntry{key: orderID, value: status, expiresAt: m.expiry(status)})
+	return nil
+}
+
+// SampleOrderIDs relies on map iteration starting at a random entry, so
+// repeated calls sample the whole cache.
+func (m *Memory) SampleOrderIDs(n int) ([]string, error) {
+	if m.entries == nil {
+		return nil, err.ErrUnintializedInstance
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ids := []string{}
+	for key, elem := range m.entries {
+		if len(ids) >= n {
+			break
+		}
+		if strings.HasPrefix(key, orderKeyPrefix) || m.expired(elem.Value.(*memoryEntry)) {
+			continue
+		}
+		ids = append(ids, key)
+	}
+	return ids, nil
+}
+
+func (m *Memory) GetOrder(orderID string) (*common.OrderResponse, int64, error) {
+	if m.entries == nil {
+		return nil, 0, err.ErrUnintializedInstance
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := orderKeyPrefix + orderID
+	entry := m.get(key)
+	if entry == nil {
+		m.misses++
+		return nil, m.version(key), err.ErrNotFound
+	}
+	m.hits++
+	order := &common.OrderResponse{}
+	if e := json.Unmarshal([]byte(entry.value), order); e != nil {
+		return nil, entry.version, e
+	}
+	return order, entry.version, nil
+}
+
+func (m *Memory) SetOrder(order *common.OrderResponse, version int64) error {
+	if m.entries == nil {
+		return err.ErrUnintializedInstance
+	}
+	data, e := json.Marshal(order)
+	if e != nil {
+		return e
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := orderKeyPrefix + order.OrderID
+	if current := m.version(key); current != version {
+		return err.ErrStaleWrite
+	}
+	m.dropVersion(key)
+	m.set(&memoryEntry{key: key, value: string(data), version: version, expiresAt: m.expiry(order.Status)})
+	return nil
+}
+
+func (m *Memory) InvalidateOrder(orderID string) error {
+	if m.entries == nil {
+		return err.ErrUnintializedInstance
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := orderKeyPrefix + orderID
+	version := m.version(key) + 1
+	if elem, found := m.entries[key]; found {
+		m.lru.Remove(elem)
+		delete(m.entries, key)
+	}
+	m.setVersion(key, version)
+	return nil
+}
+
+func (m *Memory) Stats() Stats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return Stats{
+		Entries:     int64(m.lru.Len()),
+		Evictions:   m.evictions,
+		Expirations: m.expirations,
+		Hits:        m.hits,
+		Misses:      m.misses,
+	}
+}
+
+func (m *Memory) Close() error {
+	close(m.stopChan)
+	return nil
+}
+
+// expiry picks the TTL by status, completed orders are rarely read again so
+// they can leave the cache sooner.
+func (m *Memory) expiry(status string) time.Time {
+	ttl := m.opts.TTL
+	if status == string(constants.COMPELETED) {
+		ttl = m.opts.CompletedTTL
+	}
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return m.now().Add(ttl)
+}
+
+// get returns the live entry for key and marks it recently used.
+func (m *Memory) get(key string) *memoryEntry {
+	elem, found := m.entries[key]
+	if !found {
+		return nil
+	}
+	entry := elem.Value.(*memoryEntry)
+	if m.expired(entry) {
+		m.remove(elem)
+		m.expirations++
+		return nil
+	}
+	m.lru.MoveToFront(elem)
+	return entry
+}
+
+func (m *Memory) set(entry *memoryEntry) {
+	if elem, found := m.entries[entry.key]; found {
+		elem.Value = entry
+		m.lru.MoveToFront(elem)
+		return
+	}
+	m.entries[entry.key] = m.lru.PushFront(entry)
+	for m.opts.MaxEntries > 0 && m.lru.Len() > m.opts.MaxEntries {
+		m.remove(m.lru.Back())
+		m.evictions++
+	}
+}
+
+func (m *Memory) version(key string) int64 {
+	if entry := m.get(key); entry != nil {
+		return entry.version
+	}
+	if v, found := m.versions[key]; found {
+		if !v.expiresAt.IsZero() && !m.now().Before(v.expiresAt) {
+			m.dropVersion(key)
+			return 0
+		}
+		return v.version
+	}
+	return 0
+}
+
+// setVersion keeps the version of an order without an entry, dropping the
+// oldest versions above MaxEntries.
+func (m *Memory) setVersion(key string, version int64) {
+	m.dropVersion(key)
+	m.versions[key] = &versionEntry{version: version, expiresAt: m.expiry(""), elem: m.versionOrder.PushBack(key)}
+	for m.opts.MaxEntries > 0 && m.versionOrder.Len() > m.opts.MaxEntries {
+		m.dropVersion(m.versionOrder.Front().Value.(string))
+	}
+}
+
+func (m *Memory) dropVersion(key string) {
+	if v, found := m.versions[key]; found {
+		m.versionOrder.Remove(v.elem)
+		delete(m.versions, key)
+	}
+}
+
+func (m *Memory) expired(entry *memoryEntry) bool {
+	return !entry.expiresAt.IsZero() && !m.now().Before(entry.expiresAt)
+}
+
+// remove drops an evicted or expired entry, an order keeps its version.
+func (m *Memory) remove(elem *list.Element) {
+	entry := elem.Value.(*memoryEntry)
+	m.lru.Remove(elem)
+	delete(m.entries, entry.key)
+	if entry.version > 0 {
+		m.setVersion(entry.key, entry.version)
+	}
+}
+
+// cleanup sweeps expired entries that are never read again.
+func (m *Memory) cleanup() {
+	ticker := time.NewTicker(m.opts.CleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.removeExpired()
+		case <-m.stopChan:
+			return
+		}
+	}
+}
+
+func (m *Memory) removeExpired() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for elem := m.lru.Back(); elem != nil; {
+		prev := elem.Prev()
+		if m.expired(elem.Value.(*memoryEntry)) {
+			m.remove(elem)
+			m.expirations++
+		}
+		elem = prev
+	}
+	for key, v := range m.versions {
+		if !v.expiresAt.IsZero() && !m.now().Before(v.expiresAt) {
+			m.dropVersion(key)
+		}
+	}
+}
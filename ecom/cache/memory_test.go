@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"ecom.com/common"
+	"ecom.com/constants"
+	"ecom.com/errors"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestMemory(opts MemoryOptions) (*Memory, *fakeClock) {
+	clock := &fakeClock{now: time.Now()}
+	m := NewMemory(opts).(*Memory)
+	m.now = clock.Now
+	return m, clock
+}
+
+func TestMemory_LRUEviction(t *testing.T) {
+	m, _ := newTestMemory(MemoryOptions{MaxEntries: 2})
+	m.SetOrderStatus("o1", "Pending")
+	m.SetOrderStatus("o2", "Pending")
+	// Reading o1 makes o2 the least recently used.
+	if _, err := m.GetOrderStatus("o1"); err != nil {
+		t.Fatalf("GetOrderStatus(o1) error = %v", err)
+	}
+	m.SetOrderStatus("o3", "Pending")
+
+	tests := []struct {
+		id      string
+		wantErr error
+	}{
+		{id: "o1"},
+		{id: "o2", wantErr: errors.ErrNotFound},
+		{id: "o3"},
+	}
+	for _, tt := range tests {
+		if _, err := m.GetOrderStatus(tt.id); err != tt.wantErr {
+			t.Errorf("GetOrderStatus(%v) error = %v, wantErr %v", tt.id, err, tt.wantErr)
+		}
+	}
+	if stats := m.Stats(); stats.Evictions != 1 || stats.Entries != 2 {
+		t.Errorf("Stats() = %+v, want 1 eviction and 2 entries", stats)
+	}
+}
+
+func TestMemory_TTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		after   time.Duration
+		wantErr error
+	}{
+		{
+			name:   "in-flight before ttl",
+			status: string(constants.PENDING),
+			after:  30 * time.Minute,
+		},
+		{
+			name:    "in-flight after ttl",
+			status:  string(constants.PROCESSING),
+			after:   time.Hour,
+			wantErr: errors.ErrNotFound,
+		},
+		{
+			name:    "completed uses shorter ttl",
+			status:  string(constants.COMPELETED),
+			after:   10 * time.Minute,
+			wantErr: errors.ErrNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, clock := newTestMemory(MemoryOptions{TTL: time.Hour, CompletedTTL: 5 * time.Minute})
+			m.SetOrderStatus("o1", tt.status)
+			clock.now = clock.now.Add(tt.after)
+			got, err := m.GetOrderStatus("o1")
+			if err != tt.wantErr {
+				t.Errorf("GetOrderStatus() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.status {
+				t.Errorf("GetOrderStatus() = %v, want %v", got, tt.status)
+			}
+			wantExpirations := int64(0)
+			if tt.wantErr != nil {
+				wantExpirations = 1
+			}
+			if stats := m.Stats(); stats.Expirations != wantExpirations {
+				t.Errorf("Stats().Expirations = %v, want %v", stats.Expirations, wantExpirations)
+			}
+		})
+	}
+}
+
+func TestMemory_RemoveExpired(t *testing.T) {
+	m, clock := newTestMemory(MemoryOptions{TTL: time.Hour, CompletedTTL: time.Minute})
+	m.SetOrderStatus("o1", string(constants.COMPELETED))
+	m.SetOrderStatus("o2", string(constants.PENDING))
+	clock.now = clock.now.Add(2 * time.Minute)
+	m.removeExpired()
+	if stats := m.Stats(); stats.Entries != 1 || stats.Expirations != 1 {
+		t.Errorf("Stats() = %+v, want 1 entry and 1 expiration", stats)
+	}
+}
+
+func TestMemory_VersionSurvivesEviction(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(m *Memory) // between the read of a fill and its write
+	}{
+		{
+			name: "invalidated and evicted",
+			prepare: func(m *Memory) {
+				m.InvalidateOrder("o1")
+				m.SetOrderStatus("o2", "Pending")
+				m.SetOrderStatus("o3", "Pending")
+			},
+		},
+		{
+			name: "invalidated, filled and evicted",
+			prepare: func(m *Memory) {
+				m.InvalidateOrder("o1")
+				_, version, _ := m.GetOrder("o1")
+				m.SetOrder(&common.OrderResponse{OrderID: "o1", Status: "Completed"}, version)
+				m.SetOrderStatus("o2", "Pending")
+				m.SetOrderStatus("o3", "Pending")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, clock := newTestMemory(MemoryOptions{MaxEntries: 2, TTL: time.Hour})
+			_, version, _ := m.GetOrder("o1")
+			tt.prepare(m)
+			if err := m.SetOrder(&common.OrderResponse{OrderID: "o1", Status: "Pending"}, version); err != errors.ErrStaleWrite {
+				t.Errorf("SetOrder() with the version from before error = %v, want %v", err, errors.ErrStaleWrite)
+			}
+
+			// Versions of uncached orders are forgotten after the TTL.
+			clock.now = clock.now.Add(2 * time.Hour)
+			m.removeExpired()
+			if len(m.versions) != 0 {
+				t.Errorf("versions = %v after the TTL, want none", m.versions)
+			}
+		})
+	}
+}
+
+func TestMemory_VersionsBounded(t *testing.T) {
+	m, _ := newTestMemory(MemoryOptions{MaxEntries: 2})
+	for _, id := range []string{"o1", "o2", "o3", "o4"} {
+		m.InvalidateOrder(id)
+	}
+	if len(m.versions) != 2 || m.versionOrder.Len() != 2 {
+		t.Errorf("versions = %v, want the 2 newest", m.versions)
+	}
+	if _, version, _ := m.GetOrder("o4"); version != 1 {
+		t.Errorf("GetOrder() version of the newest = %v, want 1", version)
+	}
+	if _, version, _ := m.GetOrder("o1"); version != 0 {
+		t.Errorf("GetOrder() version of the oldest = %v, want 0, dropped", version)
+	}
+	// A fill takes its version out of the map.
+	m.SetOrder(&common.OrderResponse{OrderID: "o4", Status: "Pending"}, 1)
+	if len(m.versions) != 1 || m.versionOrder.Len() != 1 {
+		t.Errorf("versions = %v after a fill, want 1", m.versions)
+	}
+}
@@ -1,45 +1,277 @@
 package cache
 
 import (
-	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"ecom.com/common"
+	"ecom.com/constants"
 	err "ecom.com/errors"
+	"github.com/go-redis/redis"
 )
 
+const (
+	orderStatusKeyPrefix = "order:status:"
+	// Hash with the serialized order in "body" and its "version".
+	orderDataKeyPrefix = "order:data:"
+)
+
+// setOrderScript writes the body only if the version has not moved on, the
+// key expires after ARGV[3] milliseconds unless that is 0.
+var setOrderScript = redis.NewScript(`
+local current = tonumber(redis.call('HGET', KEYS[1], 'version') or '0')
+if current ~= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'version', ARGV[1], 'body', ARGV[2])
+if tonumber(ARGV[3]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return 1
+`)
+
+// setStatusScript writes the status only if it does not rank below the
+// cached one, the key expires after ARGV[2] milliseconds unless that is 0.
+var setStatusScript = redis.NewScript(`
+local ranks = ` + luaStatusRanks() + `
+local current = redis.call('GET', KEYS[1])
+if current and (ranks[current] or 0) > (ranks[ARGV[1]] or 0) then
+	return 0
+end
+if tonumber(ARGV[2]) > 0 then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+else
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+// setNotFoundScript marks an order missing unless it has a status.
+var setNotFoundScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current and current ~= '' then
+	return 0
+end
+redis.call('SET', KEYS[1], '', 'PX', ARGV[1])
+return 1
+`)
+
+// replaceStatusScript writes the status, or deletes the key for an empty
+// one, only if the cached status is still the expected one. Like
+// setStatusScript the key expires after ARGV[3] milliseconds.
+var replaceStatusScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+if ARGV[2] == '' then
+	redis.call('DEL', KEYS[1])
+elseif tonumber(ARGV[3]) > 0 then
+	redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+else
+	redis.call('SET', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// RedisOptions are the connection settings of a Redis cache. Zero values
+// keep the go-redis defaults, keys never expire without a TTL.
+type RedisOptions struct {
+	Addr         string
+	Password     string
+	DB           int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	PoolSize     int
+	TTL          time.Duration // lifetime of in-flight orders
+	CompletedTTL time.Duration // lifetime of completed orders, defaults to TTL
+}
+
+// Redis is a CacheI backed by a Redis server, shared by all instances.
 type Redis struct {
-	Ctx               context.Context
-	redisStore        map[string]string
-	redisStoreRWMutex *sync.RWMutex
+	client *redis.Client
+	opts   RedisOptions
+	// SCAN cursor of SampleOrderIDs, 0 starts a new pass.
+	sampleCursor uint64
+	sampleMutex  sync.Mutex
+	hits         atomic.Int64
+	misses       atomic.Int64
 }
 
-func NewRedis(addr, password string, dbNum int) CacheI {
-	return &Redis{
-		Ctx:               context.Background(),
-		redisStore:        map[string]string{},
-		redisStoreRWMutex: &sync.RWMutex{},
+// NewRedis does not fail if the server is down, calls fail until it is back.
+func NewRedis(opts RedisOptions) CacheI {
+	if opts.CompletedTTL == 0 {
+		opts.CompletedTTL = opts.TTL
+	}
+	r := &Redis{
+		opts: opts,
+		client: redis.NewClient(&redis.Options{
+			Addr:         opts.Addr,
+			Password:     opts.Password,
+			DB:           opts.DB,
+			DialTimeout:  opts.DialTimeout,
+			ReadTimeout:  opts.ReadTimeout,
+			WriteTimeout: opts.WriteTimeout,
+			PoolSize:     opts.PoolSize,
+		}),
+	}
+	if e := r.client.Ping().Err(); e != nil {
+		log.Printf("Warning: redis at %s not reachable: %v", opts.Addr, e)
 	}
+	return r
 }
 
 func (r *Redis) SetOrderStatus(orderID, status string) error {
-	if r.redisStore == nil {
-		return err.ErrUnintializedInstance
+	stored, e := setStatusScript.Run(r.client, []string{orderStatusKeyPrefix + orderID}, status, r.ttl(status)).Int64()
+	if e != nil {
+		return e
+	}
+	if stored == 0 {
+		return err.ErrStaleWrite
 	}
-	r.redisStoreRWMutex.Lock()
-	r.redisStore[orderID] = status
-	r.redisStoreRWMutex.Unlock()
 	return nil
 }
 
 func (r *Redis) GetOrderStatus(orderID string) (string, error) {
-	if r.redisStore == nil {
-		return "", err.ErrUnintializedInstance
-	}
-	r.redisStoreRWMutex.RLock()
-	val, found := r.redisStore[orderID]
-	r.redisStoreRWMutex.RUnlock()
-	if !found {
+	val, e := r.client.Get(orderStatusKeyPrefix + orderID).Result()
+	if e == redis.Nil {
+		r.misses.Add(1)
 		return "", err.ErrNotFound
 	}
+	if e != nil {
+		return "", e
+	}
+	r.hits.Add(1)
+	if val == "" {
+		return "", err.ErrCachedNotFound
+	}
 	return val, nil
 }
+
+func (r *Redis) SetOrderNotFound(orderID string, ttl time.Duration) error {
+	stored, e := setNotFoundScript.Run(r.client, []string{orderStatusKeyPrefix + orderID}, ttl.Milliseconds()).Int64()
+	if e != nil {
+		return e
+	}
+	if stored == 0 {
+		return err.ErrStaleWrite
+	}
+	return nil
+}
+
+func (r *Redis) DeleteOrderStatus(orderID string) error {
+	return r.client.Del(orderStatusKeyPrefix + orderID).Err()
+}
+
+func (r *Redis) ReplaceOrderStatus(orderID, expected, status string) error {
+	stored, e := replaceStatusScript.Run(r.client, []string{orderStatusKeyPrefix + orderID}, expected, status, r.ttl(status)).Int64()
+	if e != nil {
+		return e
+	}
+	if stored == 0 {
+		return err.ErrStaleWrite
+	}
+	return nil
+}
+
+// SampleOrderIDs continues a SCAN over the status keys where the previous
+// call stopped, starting over once the whole keyspace was seen.
+func (r *Redis) SampleOrderIDs(n int) ([]string, error) {
+	r.sampleMutex.Lock()
+	defer r.sampleMutex.Unlock()
+	ids := []string{}
+	for len(ids) < n {
+		keys, cursor, e := r.client.Scan(r.sampleCursor, orderStatusKeyPrefix+"*", int64(n-len(ids))).Result()
+		if e != nil {
+			return ids, e
+		}
+		for _, key := range keys {
+			ids = append(ids, strings.TrimPrefix(key, orderStatusKeyPrefix))
+		}
+		r.sampleCursor = cursor
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(ids) > n {
+		ids = ids[:n]
+	}
+	return ids, nil
+}
+
+func (r *Redis) GetOrder(orderID string) (*common.OrderResponse, int64, error) {
+	vals, e := r.client.HMGet(orderDataKeyPrefix+orderID, "version", "body").Result()
+	if e != nil {
+		return nil, 0, e
+	}
+	var version int64
+	if v, ok := vals[0].(string); ok {
+		if version, e = strconv.ParseInt(v, 10, 64); e != nil {
+			return nil, 0, e
+		}
+	}
+	body, ok := vals[1].(string)
+	if !ok || body == "" {
+		r.misses.Add(1)
+		return nil, version, err.ErrNotFound
+	}
+	r.hits.Add(1)
+	order := &common.OrderResponse{}
+	if e := json.Unmarshal([]byte(body), order); e != nil {
+		return nil, version, e
+	}
+	return order, version, nil
+}
+
+func (r *Redis) SetOrder(order *common.OrderResponse, version int64) error {
+	data, e := json.Marshal(order)
+	if e != nil {
+		return e
+	}
+	stored, e := setOrderScript.Run(r.client, []string{orderDataKeyPrefix + order.OrderID}, version, string(data), r.ttl(order.Status)).Int64()
+	if e != nil {
+		return e
+	}
+	if stored == 0 {
+		return err.ErrStaleWrite
+	}
+	return nil
+}
+
+// InvalidateOrder keeps the version for TTL, like the memory cache.
+func (r *Redis) InvalidateOrder(orderID string) error {
+	key := orderDataKeyPrefix + orderID
+	_, e := r.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(key, "version", 1)
+		pipe.HDel(key, "body")
+		if r.opts.TTL > 0 {
+			pipe.PExpire(key, r.opts.TTL)
+		}
+		return nil
+	})
+	return e
+}
+
+// ttl is how many milliseconds the keys of an order with status live, 0 is
+// forever.
+func (r *Redis) ttl(status string) int64 {
+	if status == string(constants.COMPELETED) {
+		return r.opts.CompletedTTL.Milliseconds()
+	}
+	return r.opts.TTL.Milliseconds()
+}
+
+// Stats only has the hits and misses seen by this instance, the server does
+// its own eviction and expiry.
+func (r *Redis) Stats() Stats {
+	return Stats{Hits: r.hits.Load(), Misses: r.misses.Load()}
+}
+
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,32 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"ecom.com/constants"
+)
+
+// statusRanks orders the statuses an order moves through. A cached status
+// is only replaced by one of the same or a higher rank, so a late write can
+// never move an order backwards. Unknown statuses rank lowest.
+var statusRanks = map[string]int{
+	string(constants.PENDING):    1,
+	string(constants.PROCESSING): 2,
+	string(constants.COMPELETED): 3,
+}
+
+func statusRank(status string) int {
+	return statusRanks[status]
+}
+
+// luaStatusRanks renders statusRanks as a Lua table for the Redis scripts.
+func luaStatusRanks() string {
+	fields := make([]string, 0, len(statusRanks))
+	for status, rank := range statusRanks {
+		fields = append(fields, fmt.Sprintf("[%q]=%d", status, rank))
+	}
+	sort.Strings(fields)
+	return "{" + strings.Join(fields, ", ") + "}"
+}
@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"ecom.com/common"
+	"ecom.com/errors"
+)
+
+// Tiered puts a small, short lived near cache (L1, in process) in front of
+// a shared cache (L2, Redis). Reads try L1 first and fill it from L2, writes
+// go to L2 and then L1. L2 stays the source of truth, versions handed out by
+// GetOrder are L2 versions. Changes other instances make to L2 reach L1
+// through a bus if L1 is wrapped in a Broadcast, otherwise L1 catches up
+// when its entries expire.
+type Tiered struct {
+	l1       CacheI
+	l2       CacheI
+	l1Hits   atomic.Int64
+	l1Misses atomic.Int64
+	l2Hits   atomic.Int64
+	l2Misses atomic.Int64
+}
+
+func NewTieredCache(l1, l2 CacheI) CacheI {
+	return &Tiered{l1: l1, l2: l2}
+}
+
+// SetOrderStatus updates L2 first, a write it rejects as stale also drops
+// the L1 entry, which is at least as old.
+func (t *Tiered) SetOrderStatus(orderID, status string) error {
+	if err := t.l2.SetOrderStatus(orderID, status); err != nil {
+		if err == errors.ErrStaleWrite {
+			t.evictStatus(orderID)
+		}
+		return err
+	}
+	if err := t.l1.SetOrderStatus(orderID, status); err != nil {
+		t.evictStatus(orderID)
+	}
+	return nil
+}
+
+func (t *Tiered) GetOrderStatus(orderID string) (string, error) {
+	if status, err := t.l1.GetOrderStatus(orderID); err == nil || err == errors.ErrCachedNotFound {
+		t.l1Hits.Add(1)
+		return status, err
+	}
+	t.l1Misses.Add(1)
+	status, err := t.l2.GetOrderStatus(orderID)
+	if err != nil {
+		if err == errors.ErrCachedNotFound {
+			// L2 holds the TTL of the marker, it is not copied into L1.
+			t.l2Hits.Add(1)
+		} else if err == errors.ErrNotFound {
+			t.l2Misses.Add(1)
+		}
+		return "", err
+	}
+	t.l2Hits.Add(1)
+	// L1 keeps what it has if a newer status got there first.
+	_ = t.l1.SetOrderStatus(orderID, status)
+	return status, nil
+}
+
+func (t *Tiered) SetOrderNotFound(orderID string, ttl time.Duration) error {
+	if err := t.l2.SetOrderNotFound(orderID, ttl); err != nil {
+		return err
+	}
+	return t.l1.SetOrderNotFound(orderID, ttl)
+}
+
+func (t *Tiered) DeleteOrderStatus(orderID string) error {
+	if err := t.l2.DeleteOrderStatus(orderID); err != nil {
+		return err
+	}
+	return t.l1.DeleteOrderStatus(orderID)
+}
+
+// ReplaceOrderStatus compares against L2, L1 is dropped either way and
+// refilled from L2 on the next read.
+func (t *Tiered) ReplaceOrderStatus(orderID, expected, status string) error {
+	err := t.l2.ReplaceOrderStatus(orderID, expected, status)
+	if err == nil || err == errors.ErrStaleWrite {
+		t.evictStatus(orderID)
+	}
+	return err
+}
+
+func (t *Tiered) SampleOrderIDs(n int) ([]string, error) {
+	return t.l2.SampleOrderIDs(n)
+}
+
+// GetOrder fills L1 only if nothing invalidated it while L2 was read.
+func (t *Tiered) GetOrder(orderID string) (*common.OrderResponse, int64, error) {
+	order, l1Version, err := t.l1.GetOrder(orderID)
+	if err == nil {
+		// L1 versions mean nothing outside L1, callers only need the
+		// version on a miss.
+		t.l1Hits.Add(1)
+		return order, 0, nil
+	}
+	t.l1Misses.Add(1)
+	order, version, err := t.l2.GetOrder(orderID)
+	if err != nil {
+		if err == errors.ErrNotFound {
+			t.l2Misses.Add(1)
+		}
+		return nil, version, err
+	}
+	t.l2Hits.Add(1)
+	_ = t.l1.SetOrder(order, l1Version)
+	return order, version, nil
+}
+
+// SetOrder reads the L1 version before writing L2, so an invalidation that
+// lands in between keeps the order out of L1.
+func (t *Tiered) SetOrder(order *common.OrderResponse, version int64) error {
+	_, l1Version, _ := t.l1.GetOrder(order.OrderID)
+	if err := t.l2.SetOrder(order, version); err != nil {
+		return err
+	}
+	_ = t.l1.SetOrder(order, l1Version)
+	return nil
+}
+
+// InvalidateOrder invalidates L2 first, L1 could otherwise be refilled from
+// the old L2 entry.
+func (t *Tiered) InvalidateOrder(orderID string) error {
+	if err := t.l2.InvalidateOrder(orderID); err != nil {
+		return err
+	}
+	return t.l1.InvalidateOrder(orderID)
+}
+
+// Stats reports the lookups served by either tier as hits, and the lookups
+// of each tier in Tiers.
+func (t *Tiered) Stats() Stats {
+	l1 := t.l1.Stats()
+	l1.Name, l1.Hits, l1.Misses, l1.Tiers = "l1", t.l1Hits.Load(), t.l1Misses.Load(), nil
+	l2 := t.l2.Stats()
+	l2.Name, l2.Hits, l2.Misses, l2.Tiers = "l2", t.l2Hits.Load(), t.l2Misses.Load(), nil
+	return Stats{
+		Entries:     l1.Entries,
+		Evictions:   l1.Evictions,
+		Expirations: l1.Expirations,
+		Hits:        l1.Hits + l2.Hits,
+		Misses:      l2.Misses,
+		Tiers:       []Stats{l1, l2},
+	}
+}
+
+func (t *Tiered) Close() error {
+	if err := t.l1.Close(); err != nil {
+		log.Printf("Error closing near cache: %v", err)
+	}
+	return t.l2.Close()
+}
+
+func (t *Tiered) evictStatus(orderID string) {
+	if err := t.l1.DeleteOrderStatus(orderID); err != nil {
+		log.Printf("Error evicting order %v from the near cache: %v", orderID, err)
+	}
+}
@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"ecom.com/common"
+	"ecom.com/errors"
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestTiered(t *testing.T) CacheI {
+	l2, _ := newTestRedis(t)
+	return NewTieredCache(NewMemory(MemoryOptions{TTL: time.Minute}), l2)
+}
+
+func TestTiered_NearCacheTTL(t *testing.T) {
+	server := miniredis.RunT(t)
+	l1, clock := newTestMemory(MemoryOptions{TTL: time.Second})
+	c := NewTieredCache(l1, NewRedis(RedisOptions{Addr: server.Addr()}))
+	defer c.Close()
+	// Another instance without a bus, it only shares L2.
+	other := NewRedis(RedisOptions{Addr: server.Addr()})
+	defer other.Close()
+
+	if err := c.SetOrderStatus("o1", "Pending"); err != nil {
+		t.Fatalf("SetOrderStatus() error = %v", err)
+	}
+	if err := other.SetOrderStatus("o1", "Completed"); err != nil {
+		t.Fatalf("SetOrderStatus() on L2 error = %v", err)
+	}
+	if got, _ := c.GetOrderStatus("o1"); got != "Pending" {
+		t.Errorf("GetOrderStatus() before L1 expiry = %v, want the L1 value Pending", got)
+	}
+	clock.now = clock.now.Add(time.Second)
+	if got, _ := c.GetOrderStatus("o1"); got != "Completed" {
+		t.Errorf("GetOrderStatus() after L1 expiry = %v, want Completed from L2", got)
+	}
+	if got, _ := c.GetOrderStatus("o1"); got != "Completed" {
+		t.Errorf("GetOrderStatus() after refill = %v, want Completed", got)
+	}
+	if _, err := c.GetOrderStatus("o2"); err != errors.ErrNotFound {
+		t.Errorf("GetOrderStatus() of unknown order error = %v, want %v", err, errors.ErrNotFound)
+	}
+
+	stats := c.Stats()
+	want := []struct {
+		name         string
+		hits, misses int64
+	}{
+		{name: "l1", hits: 2, misses: 2},
+		{name: "l2", hits: 1, misses: 1},
+	}
+	if len(stats.Tiers) != len(want) {
+		t.Fatalf("Stats().Tiers = %+v, want %v tiers", stats.Tiers, len(want))
+	}
+	for i, w := range want {
+		tier := stats.Tiers[i]
+		if tier.Name != w.name || tier.Hits != w.hits || tier.Misses != w.misses {
+			t.Errorf("Stats().Tiers[%v] = %+v, want %v with %v hits and %v misses", i, tier, w.name, w.hits, w.misses)
+		}
+	}
+	if stats.Hits != 3 || stats.Misses != 1 || stats.HitRatio() != 0.75 {
+		t.Errorf("Stats() = %+v, ratio %v, want 3 hits, 1 miss, ratio 0.75", stats, stats.HitRatio())
+	}
+}
+
+func TestTiered_BusInvalidatesNearCache(t *testing.T) {
+	server := miniredis.RunT(t)
+	bus := NewLocalBus()
+	newInstance := func(id string) CacheI {
+		l1, err := NewBroadcastCache(NewMemory(MemoryOptions{TTL: time.Hour}), bus, id)
+		if err != nil {
+			t.Fatalf("NewBroadcastCache() error = %v", err)
+		}
+		return NewTieredCache(l1, NewRedis(RedisOptions{Addr: server.Addr()}))
+	}
+	a, b := newInstance("a"), newInstance("b")
+
+	if err := a.SetOrderStatus("o1", "Pending"); err != nil {
+		t.Fatalf("SetOrderStatus() error = %v", err)
+	}
+	if got, _ := b.GetOrderStatus("o1"); got != "Pending" {
+		t.Fatalf("GetOrderStatus() = %v, want Pending", got)
+	}
+	if err := a.SetOrderStatus("o1", "Completed"); err != nil {
+		t.Fatalf("SetOrderStatus() error = %v", err)
+	}
+	if got, _ := b.GetOrderStatus("o1"); got != "Completed" {
+		t.Errorf("GetOrderStatus() on other instance = %v, want Completed", got)
+	}
+
+	// b caches the order body in L1, a invalidates it.
+	_, version, _ := b.GetOrder("o1")
+	if err := b.SetOrder(&common.OrderResponse{OrderID: "o1", Status: "Completed"}, version); err != nil {
+		t.Fatalf("SetOrder() error = %v", err)
+	}
+	if _, _, err := b.GetOrder("o1"); err != nil {
+		t.Fatalf("GetOrder() error = %v", err)
+	}
+	if err := a.InvalidateOrder("o1"); err != nil {
+		t.Fatalf("InvalidateOrder() error = %v", err)
+	}
+	if _, version, err := b.GetOrder("o1"); err != errors.ErrNotFound || version != 1 {
+		t.Errorf("GetOrder() on other instance after invalidation = %v, %v, want L2 version 1 and %v", version, err, errors.ErrNotFound)
+	}
+}
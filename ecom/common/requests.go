@@ -10,3 +10,8 @@ type MetricRequest struct {
 	OrderId        string
 	ProcessingTime int
 }
+
+type RateLimitRequest struct {
+	Rate  *float64 `json:"rate" binding:"required"`
+	Burst int      `json:"burst"`
+}
@@ -19,6 +19,69 @@ type OrderStatusResponse struct {
 }
 
 type Metrics struct {
-	TotalOrdersReceived   int64   `json:"total_orders_received"`
-	AverageProcessingTime float64 `json:"average_processing_time"` // In seconds
+	TotalOrdersReceived      int64                  `json:"total_orders_received"`
+	AverageProcessingTime    float64                `json:"average_processing_time"`      // In seconds
+	AverageRateLimitWaitTime float64                `json:"average_rate_limit_wait_time"` // In seconds
+	CircuitBreakers          []CircuitBreakerStatus `json:"circuit_breakers"`
+	Queues                   []QueueStatus          `json:"queues"`
+	Cache                    CacheStatus            `json:"cache"`
+	Reconciler               ReconcilerStatus       `json:"reconciler"`
+}
+
+type ReconcilerStatus struct {
+	Runs       int64 `json:"runs"`
+	Checked    int64 `json:"checked"`
+	Missing    int64 `json:"missing"`
+	Mismatched int64 `json:"mismatched"`
+	Repaired   int64 `json:"repaired"`
+	Suspects   int64 `json:"suspects"`
+}
+
+type CacheStatus struct {
+	Name        string        `json:"name,omitempty"`
+	Entries     int64         `json:"entries"`
+	Evictions   int64         `json:"evictions"`
+	Expirations int64         `json:"expirations"`
+	Hits        int64         `json:"hits"`
+	Misses      int64         `json:"misses"`
+	HitRatio    float64       `json:"hit_ratio"`
+	Tiers       []CacheStatus `json:"tiers,omitempty"`
+}
+
+type QueueStatus struct {
+	Name        string `json:"name"`
+	Panics      int64  `json:"panics"`
+	FailedItems int64  `json:"failed_items"`
+	LostMetrics int64  `json:"lost_metrics"`
+	Paused      bool   `json:"paused"`
+}
+
+type CircuitBreakerStatus struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+	Trips int64  `json:"trips"`
+}
+
+type HealthResponse struct {
+	Status          string                 `json:"status"`
+	CircuitBreakers []CircuitBreakerStatus `json:"circuit_breakers"`
+	PausedQueues    []string               `json:"paused_queues"`
+}
+
+type ReadinessResponse struct {
+	Status string       `json:"status"`
+	WarmUp WarmUpStatus `json:"warm_up"`
+}
+
+type WarmUpStatus struct {
+	Loaded     int64 `json:"loaded"`
+	Batches    int64 `json:"batches"`
+	DurationMs int64 `json:"duration_ms"`
+	TimedOut   bool  `json:"timed_out"`
+}
+
+type RateLimitResponse struct {
+	Queue string  `json:"queue"`
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
 }
@@ -2,33 +2,108 @@ package config
 
 import (
 	"log"
+	"net"
+	"net/url"
 	"os"
 
+	"ecom.com/constants"
 	"gopkg.in/yaml.v2"
 )
 
+// RateLimit configures a token bucket. A rate of zero disables limiting.
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`  // tokens per second
+	Burst int     `yaml:"burst"` // bucket size
+}
+
+// Batch configures batched queue processing. A size of 1 or less disables it.
+type Batch struct {
+	Size   int `yaml:"size"`   // max items per batch
+	WaitMs int `yaml:"waitMs"` // max wait for a batch to fill
+}
+
+// Shard is one orders DB of a sharded setup, it uses database.driver.
+type Shard struct {
+	Name string `yaml:"name"` // place on the hash ring, renaming a shard moves its orders
+	DSN  string `yaml:"dsn"`
+}
+
 // Config holds the configuration settings from the YAML file.
 type Config struct {
 	Server struct {
-		Port string `yaml:"port"`
+		Port             string `yaml:"port"`
+		RequestTimeoutMs int    `yaml:"requestTimeoutMs"` // deadline of API requests, DB queries are canceled with them, 0 disables
 	} `yaml:"server"`
+	Storage  string `yaml:"storage"` // sql or memory, memory ignores database and metrics
 	Database struct {
-		Driver string `yaml:"driver"`
-		DSN    string `yaml:"dsn"`
+		Driver   string   `yaml:"driver"`
+		DSN      string   `yaml:"dsn"`
+		Shards   []Shard  `yaml:"shards"`   // orders are spread over these by user id instead of dsn
+		Replicas []string `yaml:"replicas"` // read-only copies of dsn that serve reads
 	} `yaml:"database"`
 	Metrics struct {
-		Driver string `yaml:"driver"`
-		DSN    string `yaml:"dsn"`
+		Driver   string   `yaml:"driver"`
+		DSN      string   `yaml:"dsn"`
+		Replicas []string `yaml:"replicas"` // read-only copies of dsn that serve reads
 	} `yaml:"metrics"`
+	SQLite struct {
+		WAL           bool `yaml:"wal"`           // write-ahead log, reads do not wait for writes
+		BusyTimeoutMs int  `yaml:"busyTimeoutMs"` // wait for a lock before failing with "database is locked", 0 keeps the driver default
+		SingleWriter  bool `yaml:"singleWriter"`  // all writes through one connection, reads through a pool
+		ReadConns     int  `yaml:"readConns"`     // size of the read pool with singleWriter, 0 is unlimited
+	} `yaml:"sqlite"`
+	Migrations struct {
+		Manual bool `yaml:"manual"` // never migrate on startup, refuse to start with pending migrations
+	} `yaml:"migrations"`
 	Queue struct {
-		WorkerPool    int `yaml:"workerPool"`
-		QueueCapacity int `yaml:"queueCapacity"`
+		WorkerPool          int       `yaml:"workerPool"`
+		QueueCapacity       int       `yaml:"queueCapacity"`
+		CreationRateLimit   RateLimit `yaml:"creationRateLimit"`
+		ProcessingRateLimit RateLimit `yaml:"processingRateLimit"`
+		ProcessingBatch     Batch     `yaml:"processingBatch"`
 	} `yaml:"queue"`
+	CircuitBreaker struct {
+		FailureThreshold  int `yaml:"failureThreshold"`  // consecutive failures before opening, 0 disables
+		OpenTimeoutMs     int `yaml:"openTimeoutMs"`     // time before half open probes
+		HalfOpenSuccesses int `yaml:"halfOpenSuccesses"` // successful probes needed to close
+	} `yaml:"circuitBreaker"`
+	Cache struct {
+		Type                   string `yaml:"type"`                   // memory, redis or tiered
+		MaxEntries             int    `yaml:"maxEntries"`             // memory only, 0 is unbounded
+		TTLSeconds             int    `yaml:"ttlSeconds"`             // memory and redis, in-flight orders
+		CompletedTTLSeconds    int    `yaml:"completedTtlSeconds"`    // memory and redis, completed orders
+		CleanupIntervalSeconds int    `yaml:"cleanupIntervalSeconds"` // memory only, expired entry sweep
+		NotFoundTTLSeconds     int    `yaml:"notFoundTtlSeconds"`     // how long unknown order ids are cached, 0 disables
+		Bus                    string `yaml:"bus"`                    // memory and tiered, "" or redis, shares changes between instances
+		NearCache              struct {
+			MaxEntries int `yaml:"maxEntries"`
+			TTLMs      int `yaml:"ttlMs"`
+		} `yaml:"nearCache"` // tiered only, the in-process tier in front of redis
+	} `yaml:"cache"`
 	Redis struct {
-		Addr     string `yaml:"addr"`
-		Password string `yaml:"password"`
-		DB       int    `yaml:"db"`
+		Addr           string `yaml:"addr"`
+		Password       string `yaml:"password"`
+		DB             int    `yaml:"db"`
+		DialTimeoutMs  int    `yaml:"dialTimeoutMs"`
+		ReadTimeoutMs  int    `yaml:"readTimeoutMs"`
+		WriteTimeoutMs int    `yaml:"writeTimeoutMs"`
+		PoolSize       int    `yaml:"poolSize"`
 	} `yaml:"redis"`
+	Reconciler struct {
+		IntervalSeconds int `yaml:"intervalSeconds"` // time between runs, 0 disables
+		SampleSize      int `yaml:"sampleSize"`      // cached statuses checked per run
+	} `yaml:"reconciler"`
+	Archive struct {
+		IntervalSeconds int `yaml:"intervalSeconds"` // time between runs, 0 disables
+		RetentionDays   int `yaml:"retentionDays"`   // Completed orders older than this are archived, 0 disables
+		BatchSize       int `yaml:"batchSize"`       // orders moved per transaction
+	} `yaml:"archive"`
+	WarmUp struct {
+		Enabled       bool `yaml:"enabled"`
+		WindowMinutes int  `yaml:"windowMinutes"` // completed orders created this recently are loaded too
+		BatchSize     int  `yaml:"batchSize"`     // orders read per query
+		BudgetMs      int  `yaml:"budgetMs"`      // time limit, 0 is unlimited
+	} `yaml:"warmUp"`
 }
 
 // AppConfig is a global instance of Config.
@@ -44,4 +119,40 @@ func LoadConfig(path string) {
 	if err != nil {
 		log.Fatalf("Error parsing config file: %v", err)
 	}
+	applyEnv(&AppConfig)
+}
+
+// applyEnv overrides the file with the environment docker-compose sets.
+// DB_HOST points both the orders and the metrics DB at one Postgres
+// database, they keep their tables apart.
+func applyEnv(cfg *Config) {
+	if host := os.Getenv("DB_HOST"); host != "" {
+		port := os.Getenv("DB_PORT")
+		if port == "" {
+			port = "5432"
+		}
+		sslMode := os.Getenv("DB_SSLMODE")
+		if sslMode == "" {
+			sslMode = "disable"
+		}
+		dsn := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD")),
+			Host:     net.JoinHostPort(host, port),
+			Path:     "/" + os.Getenv("DB_NAME"),
+			RawQuery: url.Values{"sslmode": {sslMode}}.Encode(),
+		}
+		cfg.Database.Driver, cfg.Database.DSN = string(constants.POSTGRES_DRIVER), dsn.String()
+		cfg.Metrics.Driver, cfg.Metrics.DSN = string(constants.POSTGRES_DRIVER), dsn.String()
+	}
+	if host := os.Getenv("REDIS_HOST"); host != "" {
+		port := os.Getenv("REDIS_PORT")
+		if port == "" {
+			port = "6379"
+		}
+		cfg.Redis.Addr = net.JoinHostPort(host, port)
+	}
+	if cacheType := os.Getenv("CACHE_TYPE"); cacheType != "" {
+		cfg.Cache.Type = cacheType
+	}
 }
@@ -11,6 +11,57 @@ const (
 type MetricName string
 
 const (
-	PROCESSING_TIME MetricName = "processing_time"
-	CREATION_TIME   MetricName = "creation_time"
+	PROCESSING_TIME      MetricName = "processing_time"
+	CREATION_TIME        MetricName = "creation_time"
+	RATE_LIMIT_WAIT_TIME MetricName = "rate_limit_wait_time"
+)
+
+type CacheType string
+
+const (
+	MEMORY_CACHE CacheType = "memory"
+	REDIS_CACHE  CacheType = "redis"
+	TIERED_CACHE CacheType = "tiered"
+)
+
+type BusType string
+
+const (
+	REDIS_BUS BusType = "redis"
+)
+
+type DriverName string
+
+const (
+	SQLITE_DRIVER   DriverName = "sqlite3"
+	POSTGRES_DRIVER DriverName = "postgres"
+)
+
+type StorageName string
+
+const (
+	SQL_STORAGE    StorageName = "sql"
+	MEMORY_STORAGE StorageName = "memory"
+)
+
+type SchemaName string
+
+const (
+	ORDERS_SCHEMA  SchemaName = "orders"
+	METRICS_SCHEMA SchemaName = "metrics"
+)
+
+type BreakerName string
+
+const (
+	ORDERS_DB_BREAKER  BreakerName = "orders_db"
+	METRICS_DB_BREAKER BreakerName = "metrics_db"
+	CACHE_BREAKER      BreakerName = "cache"
+)
+
+type QueueName string
+
+const (
+	ORDER_CREATION_QUEUE   QueueName = "order_creation"
+	ORDER_PROCESSING_QUEUE QueueName = "order_processing"
 )
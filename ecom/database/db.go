@@ -3,75 +3,64 @@ package database
 import (
 	"database/sql"
 	"log"
+	"strings"
 
+	"ecom.com/constants"
+	_ "github.com/lib/pq"           // PostgreSQL driver
 	_ "github.com/mattn/go-sqlite3" // SQLite driver
 )
 
+// ConnectDB opens the orders DB and applies pending migrations.
 func ConnectDB(driver string, dsn string) *sql.DB {
-	db, err := sql.Open(driver, dsn)
-	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
-	}
-
-	// Create table if not exists
-	query := `CREATE TABLE IF NOT EXISTS users (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        name TEXT NOT NULL,
-        email TEXT NOT NULL UNIQUE
-    );`
+	return Connect(driver, dsn, constants.ORDERS_SCHEMA, true)
+}
 
-	if _, err = db.Exec(query); err != nil {
-		log.Fatal("Failed to create table:", err)
-	}
+// ConnectMetricsDB opens the metrics DB and applies pending migrations.
+func ConnectMetricsDB(driver string, dsn string) *sql.DB {
+	return Connect(driver, dsn, constants.METRICS_SCHEMA, true)
+}
 
-	// Create orders if not exists
-	ordersQuery := `CREATE TABLE IF NOT EXISTS orders (
-		order_id TEXT PRIMARY KEY,
-		user_id TEXT NOT NULL,
-		total_amount DECIMAL(10,2) NOT NULL,
-		status TEXT CHECK (status IN ('Pending', 'Processing', 'Completed')) NOT NULL
-	);`
-	_, err = db.Exec(ordersQuery)
+// Connect opens a DB and makes sure its schema is the one this build
+// expects. Pending migrations are applied if migrate is set, otherwise they
+// have to be applied with the migrate command first. A DB migrated by a
+// newer build is never used.
+func Connect(driver string, dsn string, schema constants.SchemaName, migrate bool) *sql.DB {
+	db, err := Open(driver, dsn)
 	if err != nil {
-		log.Fatalf("Error creating orders table: %v", err)
+		log.Fatal("Failed to connect to database:", err)
 	}
-
-	// Create items if not exists
-	itemQuery := `CREATE TABLE IF NOT EXISTS items (
-		item_id TEXT,
-		amount DECIMAL(10,2) NOT NULL,
-		order_id TEXT NOT NULL,
-		FOREIGN KEY (order_id) REFERENCES orders(order_id) ON DELETE CASCADE ON UPDATE CASCADE
-		PRIMARY KEY (item_id, order_id)
-	);`
-	_, err = db.Exec(itemQuery)
+	migrator, err := NewMigrator(db, driver, schema)
 	if err != nil {
-		log.Fatalf("Error creating orders table: %v", err)
+		log.Fatalf("Failed to load %v migrations: %v", schema, err)
+	}
+	if migrate {
+		applied, err := migrator.Up()
+		if err != nil {
+			log.Fatalf("Failed to migrate %v database: %v", schema, err)
+		}
+		if applied > 0 {
+			log.Printf("Applied %v migrations to the %v database, now at version %v", applied, schema, migrator.Latest())
+		}
+	}
+	if err := migrator.Check(); err != nil {
+		log.Fatalf("Refusing to start on the %v database: %v. Run: go run main.go migrate up", schema, err)
 	}
-
 	return db
 }
 
-func CloseDB(db *sql.DB) {
-	db.Close()
-}
-
-func ConnectMetricsDB(driver string, dsn string) *sql.DB {
-	db, err := sql.Open(driver, dsn)
-	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+// Open is sql.Open with foreign keys turned on for SQLite, which leaves them
+// off unless asked, so items need their order on every backend.
+func Open(driver string, dsn string) (*sql.DB, error) {
+	if constants.DriverName(driver) == constants.SQLITE_DRIVER && !strings.Contains(dsn, "_foreign_keys=") {
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		dsn += separator + "_foreign_keys=1"
 	}
+	return sql.Open(driver, dsn)
+}
 
-	metricsQuery := `CREATE TABLE IF NOT EXISTS metrics (
-		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		order_id TEXT NOT NULL,
-		metric_name TEXT NOT NULL,
-		duration REAL
-	);`
-	_, err = db.Exec(metricsQuery)
-	if err != nil {
-		log.Fatalf("Error creating metrics table: %v", err)
-	}
-	return db
+func CloseDB(db *sql.DB) {
+	db.Close()
 }
@@ -0,0 +1,319 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	stderrors "errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecom.com/constants"
+	"ecom.com/errors"
+)
+
+// Migrations live in migrations/<dialect>/<schema>/ as pairs of
+// NNNN_name.up.sql and NNNN_name.down.sql files, applied in version order.
+//
+//go:embed migrations seed
+var sqlFiles embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// legacyChecks find the changes of a migration in DBs whose tables were
+// created at startup before migrations existed. A migration whose changes are
+// there is recorded as applied without running it.
+var legacyChecks = map[string]map[constants.SchemaName]map[int]string{
+	"sqlite": {
+		// Added by the cache warm-up before it had a migration.
+		constants.ORDERS_SCHEMA: {2: `SELECT COUNT(*) FROM pragma_table_info('orders') WHERE name = 'created_at'`},
+	},
+}
+
+// MigrationStatus is a migration and when it was applied, nil if pending.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// Migrator applies and rolls back the migrations of one schema, keeping
+// track of them in the schema_migrations table of its DB. Several schemas
+// can live in the same DB.
+type Migrator struct {
+	db         *sql.DB
+	dialect    string
+	schema     constants.SchemaName
+	migrations []Migration // by version
+}
+
+func NewMigrator(db *sql.DB, driver string, schema constants.SchemaName) (*Migrator, error) {
+	dialect, err := dialectOf(constants.DriverName(driver))
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := loadMigrations(dialect, schema)
+	if err != nil {
+		return nil, err
+	}
+	m := &Migrator{db: db, dialect: dialect, schema: schema, migrations: migrations}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		schema_name TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (schema_name, version)
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func dialectOf(driver constants.DriverName) (string, error) {
+	switch driver {
+	case constants.SQLITE_DRIVER:
+		return "sqlite", nil
+	case constants.POSTGRES_DRIVER:
+		return "postgres", nil
+	}
+	return "", fmt.Errorf("no migrations for driver %q", driver)
+}
+
+func loadMigrations(dialect string, schema constants.SchemaName) ([]Migration, error) {
+	dir := path.Join("migrations", dialect, string(schema))
+	files, err := fs.ReadDir(sqlFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		name, direction, ok := strings.Cut(strings.TrimSuffix(file.Name(), ".sql"), ".")
+		versionText, migrationName, found := strings.Cut(name, "_")
+		version, err := strconv.Atoi(versionText)
+		if !ok || !found || err != nil || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("bad migration file name %v", file.Name())
+		}
+		body, err := sqlFiles.ReadFile(path.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migration, exists := byVersion[version]
+		if !exists {
+			migration = &Migration{Version: version, Name: migrationName}
+			byVersion[version] = migration
+		} else if migration.Name != migrationName {
+			return nil, fmt.Errorf("two migrations with version %v", version)
+		}
+		if direction == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+	migrations := []Migration{}
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %v %v needs an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Latest is the version the schema has once every migration is applied.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Status lists the known migrations, followed by applied ones this build
+// does not know about.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	statuses := []MigrationStatus{}
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if appliedAt, found := applied[migration.Version]; found {
+			status.AppliedAt = &appliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	unknown := []MigrationStatus{}
+	for version, appliedAt := range applied {
+		appliedAt := appliedAt
+		unknown = append(unknown, MigrationStatus{Version: version, Name: "unknown", AppliedAt: &appliedAt})
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Version < unknown[j].Version })
+	return append(statuses, unknown...), nil
+}
+
+// Check returns errors.ErrSchemaOutdated if migrations are pending and
+// errors.ErrSchemaUnknown if the DB has migrations this build does not know,
+// e.g. it was migrated by a newer release.
+func (m *Migrator) Check() error {
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if status.Name == "unknown" {
+			return fmt.Errorf("%w: version %v", errors.ErrSchemaUnknown, status.Version)
+		}
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			return fmt.Errorf("%w: version %v %v", errors.ErrSchemaOutdated, status.Version, status.Name)
+		}
+	}
+	return nil
+}
+
+// Up applies the pending migrations in order, each in its own transaction,
+// and returns how many were applied.
+func (m *Migrator) Up() (int, error) {
+	if err := m.Check(); err == nil || !isOutdated(err) {
+		return 0, err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, migration := range m.migrations {
+		if _, found := applied[migration.Version]; found {
+			continue
+		}
+		script := migration.Up
+		present, err := m.present(migration)
+		if err != nil {
+			return count, fmt.Errorf("migration %v %v: %w", migration.Version, migration.Name, err)
+		}
+		if present {
+			script = ""
+		}
+		err = m.run(script, `INSERT INTO schema_migrations (schema_name, version, name) VALUES (?, ?, ?)`, string(m.schema), migration.Version, migration.Name)
+		if err != nil {
+			return count, fmt.Errorf("migration %v %v: %w", migration.Version, migration.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Down rolls back the last steps applied migrations, newest first.
+func (m *Migrator) Down(steps int) (int, error) {
+	if err := m.Check(); err != nil && !isOutdated(err) {
+		return 0, err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+		migration := m.migrations[i]
+		if _, found := applied[migration.Version]; !found {
+			continue
+		}
+		err := m.run(migration.Down, `DELETE FROM schema_migrations WHERE schema_name = ? AND version = ?`, string(m.schema), migration.Version)
+		if err != nil {
+			return count, fmt.Errorf("rolling back migration %v %v: %w", migration.Version, migration.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// present tells if the changes of migration are in the DB although it was
+// never recorded, see legacyChecks.
+func (m *Migrator) present(migration Migration) (bool, error) {
+	query, found := legacyChecks[m.dialect][m.schema][migration.Version]
+	if !found {
+		return false, nil
+	}
+	var count int
+	if err := m.db.QueryRow(query).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// run executes a migration script, if any, and its bookkeeping in one
+// transaction.
+func (m *Migrator) run(script string, bookkeeping string, args ...any) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	if script != "" {
+		if _, err := tx.Exec(script); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err := tx.Exec(m.bind(bookkeeping), args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *Migrator) applied() (map[int]time.Time, error) {
+	rows, err := m.db.Query(m.bind(`SELECT version, applied_at FROM schema_migrations WHERE schema_name = ?`), string(m.schema))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt sql.NullTime
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt.Time
+	}
+	return applied, rows.Err()
+}
+
+// bind turns ? placeholders into $n ones for postgres.
+func (m *Migrator) bind(query string) string {
+	if m.dialect != "postgres" {
+		return query
+	}
+	for n := 1; strings.Contains(query, "?"); n++ {
+		query = strings.Replace(query, "?", "$"+strconv.Itoa(n), 1)
+	}
+	return query
+}
+
+func isOutdated(err error) bool {
+	return stderrors.Is(err, errors.ErrSchemaOutdated)
+}
+
+// Seed loads the sample orders of seed/orders.sql into an orders DB.
+func Seed(db *sql.DB) error {
+	script, err := sqlFiles.ReadFile("seed/orders.sql")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(string(script))
+	return err
+}
@@ -0,0 +1,196 @@
+package database
+
+import (
+	"database/sql"
+	stderrors "errors"
+	"path/filepath"
+	"testing"
+
+	"ecom.com/constants"
+	"ecom.com/errors"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	for _, dialect := range []string{"sqlite", "postgres"} {
+		for _, schema := range []constants.SchemaName{constants.ORDERS_SCHEMA, constants.METRICS_SCHEMA} {
+			migrations, err := loadMigrations(dialect, schema)
+			if err != nil {
+				t.Fatalf("loadMigrations(%v, %v) error = %v", dialect, schema, err)
+			}
+			for i, migration := range migrations {
+				if migration.Version != i+1 {
+					t.Errorf("loadMigrations(%v, %v)[%v] version = %v, want %v", dialect, schema, i, migration.Version, i+1)
+				}
+			}
+		}
+	}
+}
+
+func newTestMigrator(t *testing.T, schema constants.SchemaName) (*Migrator, *sql.DB) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	m, err := NewMigrator(db, "sqlite3", schema)
+	if err != nil {
+		t.Fatalf("NewMigrator() error = %v", err)
+	}
+	return m, db
+}
+
+func TestMigrator_UpDown(t *testing.T) {
+	m, db := newTestMigrator(t, constants.ORDERS_SCHEMA)
+	if err := m.Check(); !stderrors.Is(err, errors.ErrSchemaOutdated) {
+		t.Errorf("Check() on empty DB error = %v, want %v", err, errors.ErrSchemaOutdated)
+	}
+	applied, err := m.Up()
+	if err != nil || applied != m.Latest() {
+		t.Fatalf("Up() = %v, %v, want %v", applied, err, m.Latest())
+	}
+	if err := m.Check(); err != nil {
+		t.Errorf("Check() after Up error = %v", err)
+	}
+	if applied, err := m.Up(); err != nil || applied != 0 {
+		t.Errorf("Up() when current = %v, %v, want 0", applied, err)
+	}
+	if err := Seed(db); err != nil {
+		t.Fatalf("Seed() error = %v", err)
+	}
+	if err := Seed(db); err != nil {
+		t.Errorf("Seed() twice error = %v", err)
+	}
+
+	rolledBack, err := m.Down(m.Latest() - 1)
+	if err != nil || rolledBack != m.Latest()-1 {
+		t.Fatalf("Down(%v) = %v, %v", m.Latest()-1, rolledBack, err)
+	}
+	if _, err := db.Exec(`SELECT 1 FROM replication_position`); err == nil {
+		t.Errorf("replication_position still exists after rolling back its migration")
+	}
+	if _, err := db.Exec(`SELECT 1 FROM orders_archive`); err == nil {
+		t.Errorf("orders_archive still exists after rolling back its migration")
+	}
+	if _, err := db.Exec(`SELECT created_at FROM orders`); err == nil {
+		t.Errorf("orders.created_at still exists after rolling back its migration")
+	}
+	if err := m.Check(); !stderrors.Is(err, errors.ErrSchemaOutdated) {
+		t.Errorf("Check() after Down error = %v, want %v", err, errors.ErrSchemaOutdated)
+	}
+	statuses, err := m.Status()
+	if err != nil || len(statuses) == 0 {
+		t.Fatalf("Status() = %+v, %v", statuses, err)
+	}
+	pending := 0
+	for _, status := range statuses[1:] {
+		if status.AppliedAt == nil {
+			pending++
+		}
+	}
+	if len(statuses) != m.Latest() || statuses[0].AppliedAt == nil || pending != m.Latest()-1 {
+		t.Errorf("Status() = %+v, want first applied and the others pending", statuses)
+	}
+
+	if rolledBack, err := m.Down(10); err != nil || rolledBack != 1 {
+		t.Errorf("Down(10) = %v, %v, want 1", rolledBack, err)
+	}
+	if _, err := db.Exec(`SELECT 1 FROM orders`); err == nil {
+		t.Errorf("orders table still exists after rolling back everything")
+	}
+}
+
+func TestMigrator_UnknownVersion(t *testing.T) {
+	m, db := newTestMigrator(t, constants.METRICS_SCHEMA)
+	if _, err := m.Up(); err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+	// A newer release migrated this DB.
+	if _, err := db.Exec(`INSERT INTO schema_migrations (schema_name, version, name) VALUES ('metrics', 99, 'from_the_future')`); err != nil {
+		t.Fatalf("insert error = %v", err)
+	}
+	if err := m.Check(); !stderrors.Is(err, errors.ErrSchemaUnknown) {
+		t.Errorf("Check() error = %v, want %v", err, errors.ErrSchemaUnknown)
+	}
+	if _, err := m.Up(); !stderrors.Is(err, errors.ErrSchemaUnknown) {
+		t.Errorf("Up() error = %v, want %v", err, errors.ErrSchemaUnknown)
+	}
+	if _, err := m.Down(1); !stderrors.Is(err, errors.ErrSchemaUnknown) {
+		t.Errorf("Down() error = %v, want %v", err, errors.ErrSchemaUnknown)
+	}
+}
+
+func TestMigrator_SharedDatabase(t *testing.T) {
+	orders, db := newTestMigrator(t, constants.ORDERS_SCHEMA)
+	metrics, err := NewMigrator(db, "sqlite3", constants.METRICS_SCHEMA)
+	if err != nil {
+		t.Fatalf("NewMigrator() error = %v", err)
+	}
+	if _, err := orders.Up(); err != nil {
+		t.Fatalf("orders Up() error = %v", err)
+	}
+	// The orders versions must not count as applied metrics migrations.
+	if err := metrics.Check(); !stderrors.Is(err, errors.ErrSchemaOutdated) {
+		t.Errorf("metrics Check() error = %v, want %v", err, errors.ErrSchemaOutdated)
+	}
+	if applied, err := metrics.Up(); err != nil || applied != metrics.Latest() {
+		t.Fatalf("metrics Up() = %v, %v, want %v", applied, err, metrics.Latest())
+	}
+	if _, err := metrics.Down(10); err != nil {
+		t.Fatalf("metrics Down() error = %v", err)
+	}
+	if err := orders.Check(); err != nil {
+		t.Errorf("orders Check() after metrics Down error = %v", err)
+	}
+}
+
+func TestMigrator_LegacyDatabase(t *testing.T) {
+	m, db := newTestMigrator(t, constants.ORDERS_SCHEMA)
+	// Tables created at startup before migrations existed.
+	_, err := db.Exec(`CREATE TABLE orders (
+		order_id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		total_amount DECIMAL(10,2) NOT NULL,
+		status TEXT CHECK (status IN ('Pending', 'Processing', 'Completed')) NOT NULL
+	);
+	INSERT INTO orders VALUES ('o1', 'u1', 1.0, 'Pending');`)
+	if err != nil {
+		t.Fatalf("legacy schema error = %v", err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatalf("Up() on legacy DB error = %v", err)
+	}
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM orders WHERE created_at IS NULL`).Scan(&count); err != nil || count != 1 {
+		t.Errorf("legacy orders = %v, %v, want 1 kept without created_at", count, err)
+	}
+}
+
+func TestMigrator_LegacyDatabaseWithCreatedAt(t *testing.T) {
+	m, db := newTestMigrator(t, constants.ORDERS_SCHEMA)
+	// Tables created at startup after the cache warm-up added created_at.
+	_, err := db.Exec(`CREATE TABLE orders (
+		order_id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		total_amount DECIMAL(10,2) NOT NULL,
+		status TEXT CHECK (status IN ('Pending', 'Processing', 'Completed')) NOT NULL,
+		created_at TIMESTAMP
+	);
+	INSERT INTO orders VALUES ('o1', 'u1', 1.0, 'Pending', '2026-01-01 00:00:00');`)
+	if err != nil {
+		t.Fatalf("legacy schema error = %v", err)
+	}
+	if applied, err := m.Up(); err != nil || applied != m.Latest() {
+		t.Fatalf("Up() on legacy DB = %v, %v, want %v", applied, err, m.Latest())
+	}
+	if err := m.Check(); err != nil {
+		t.Errorf("Check() after Up error = %v", err)
+	}
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM orders WHERE created_at IS NOT NULL`).Scan(&count); err != nil || count != 1 {
+		t.Errorf("legacy orders = %v, %v, want 1 kept with its created_at", count, err)
+	}
+	if rolledBack, err := m.Down(m.Latest() - 1); err != nil || rolledBack != m.Latest()-1 {
+		t.Errorf("Down(%v) = %v, %v", m.Latest()-1, rolledBack, err)
+	}
+}
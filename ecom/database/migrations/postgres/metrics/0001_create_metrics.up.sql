@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS metrics (
+	id SERIAL PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT now(),
+	order_id TEXT NOT NULL,
+	metric_name TEXT NOT NULL,
+	duration DOUBLE PRECISION
+);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS users (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	email TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+	order_id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	total_amount NUMERIC(10,2) NOT NULL,
+	status TEXT CHECK (status IN ('Pending', 'Processing', 'Completed')) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS items (
+	item_id TEXT,
+	amount NUMERIC(10,2) NOT NULL,
+	order_id TEXT NOT NULL REFERENCES orders(order_id) ON DELETE CASCADE ON UPDATE CASCADE,
+	PRIMARY KEY (item_id, order_id)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE orders ADD COLUMN created_at TIMESTAMPTZ DEFAULT now();
+CREATE INDEX orders_created_at_idx ON orders (created_at);
@@ -0,0 +1,2 @@
+-- Set when an archived order is moved back, the retention job leaves it.
+ALTER TABLE orders ADD COLUMN restored_at TIMESTAMPTZ;
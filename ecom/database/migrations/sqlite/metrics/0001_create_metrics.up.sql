@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS metrics (
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	order_id TEXT NOT NULL,
+	metric_name TEXT NOT NULL,
+	duration REAL
+);
@@ -0,0 +1,7 @@
+-- Dedupe ledger, lives next to metrics so both are written in one transaction.
+CREATE TABLE IF NOT EXISTS processed_items (
+	item_id TEXT NOT NULL,
+	stage TEXT NOT NULL,
+	processed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (item_id, stage)
+);
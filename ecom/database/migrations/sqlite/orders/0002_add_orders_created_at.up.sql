@@ -0,0 +1,3 @@
+-- SQLite cannot add a column with a non constant default, inserts set it.
+ALTER TABLE orders ADD COLUMN created_at TIMESTAMP;
+CREATE INDEX orders_created_at_idx ON orders (created_at);
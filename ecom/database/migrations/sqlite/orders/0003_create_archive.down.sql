@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS items_archive;
+DROP TABLE IF EXISTS orders_archive;
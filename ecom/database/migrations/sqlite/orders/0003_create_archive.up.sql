@@ -0,0 +1,17 @@
+-- Orders moved out of orders by the retention job, with their items.
+CREATE TABLE IF NOT EXISTS orders_archive (
+	order_id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	total_amount DECIMAL(10,2) NOT NULL,
+	status TEXT NOT NULL,
+	created_at TIMESTAMP,
+	archived_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS items_archive (
+	item_id TEXT,
+	amount DECIMAL(10,2) NOT NULL,
+	order_id TEXT NOT NULL,
+	FOREIGN KEY (order_id) REFERENCES orders_archive(order_id) ON DELETE CASCADE,
+	PRIMARY KEY (item_id, order_id)
+);
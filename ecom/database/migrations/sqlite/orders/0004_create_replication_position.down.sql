@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS orders_insert_position;
+DROP TRIGGER IF EXISTS orders_update_position;
+DROP TRIGGER IF EXISTS orders_delete_position;
+DROP TRIGGER IF EXISTS items_insert_position;
+DROP TRIGGER IF EXISTS items_update_position;
+DROP TRIGGER IF EXISTS items_delete_position;
+DROP TABLE IF EXISTS replication_position;
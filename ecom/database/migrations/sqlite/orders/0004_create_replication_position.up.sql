@@ -0,0 +1,26 @@
+-- Counts the changes to orders and items. A copy of the file serving as a
+-- read replica reports how far it got, like the WAL LSN of Postgres.
+CREATE TABLE IF NOT EXISTS replication_position (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	position INTEGER NOT NULL
+);
+INSERT OR IGNORE INTO replication_position (id, position) VALUES (1, 0);
+
+CREATE TRIGGER IF NOT EXISTS orders_insert_position AFTER INSERT ON orders BEGIN
+	UPDATE replication_position SET position = position + 1;
+END;
+CREATE TRIGGER IF NOT EXISTS orders_update_position AFTER UPDATE ON orders BEGIN
+	UPDATE replication_position SET position = position + 1;
+END;
+CREATE TRIGGER IF NOT EXISTS orders_delete_position AFTER DELETE ON orders BEGIN
+	UPDATE replication_position SET position = position + 1;
+END;
+CREATE TRIGGER IF NOT EXISTS items_insert_position AFTER INSERT ON items BEGIN
+	UPDATE replication_position SET position = position + 1;
+END;
+CREATE TRIGGER IF NOT EXISTS items_update_position AFTER UPDATE ON items BEGIN
+	UPDATE replication_position SET position = position + 1;
+END;
+CREATE TRIGGER IF NOT EXISTS items_delete_position AFTER DELETE ON items BEGIN
+	UPDATE replication_position SET position = position + 1;
+END;
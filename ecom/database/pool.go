@@ -0,0 +1,201 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ecom.com/constants"
+)
+
+// SQLiteOptions tune a SQLite file that many queue workers write to at once.
+type SQLiteOptions struct {
+	WAL          bool          // readers do not block the writer and the other way round
+	BusyTimeout  time.Duration // wait this long for a lock before "database is locked", 0 keeps the driver default
+	SingleWriter bool          // one connection writes, reads use a pool of ReadConns
+	ReadConns    int           // 0 is unlimited
+}
+
+// maxCachedStmts bounds the statement cache, in case a query whose text
+// depends on the input is not run Unprepared.
+const maxCachedStmts = 100
+
+type unpreparedKey struct{}
+
+// Unprepared makes the queries on ctx run without a cached statement. Queries
+// built for their input, like IN lists, must use it or they fill the cache.
+func Unprepared(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unpreparedKey{}, true)
+}
+
+// DB is what the repositories run on. Writes and transactions go to Writer,
+// reads to Reader. Both are the same pool unless SQLite has a single writer.
+// With replicas reads go to them instead, see readPool. Statements run
+// outside a transaction are prepared once and reused.
+type DB struct {
+	Writer *sql.DB
+	Reader *sql.DB
+
+	replicas  []*replica
+	positions *positions    // nil without replicas or without positions
+	next      atomic.Uint64 // round robin over replicas
+
+	mu    sync.Mutex
+	stmts map[*sql.DB]map[string]*sql.Stmt
+}
+
+func NewDB(writer *sql.DB, reader *sql.DB) *DB {
+	return &DB{Writer: writer, Reader: reader, stmts: map[*sql.DB]map[string]*sql.Stmt{}}
+}
+
+// ConnectPool is Connect for the service, SQLite gets opts applied.
+func ConnectPool(driver string, dsn string, schema constants.SchemaName, migrate bool, opts SQLiteOptions) *DB {
+	if constants.DriverName(driver) != constants.SQLITE_DRIVER {
+		db := Connect(driver, dsn, schema, migrate)
+		return NewDB(db, db)
+	}
+	params := []string{}
+	if opts.WAL {
+		params = append(params, "_journal_mode=WAL")
+	}
+	if opts.BusyTimeout > 0 {
+		params = append(params, fmt.Sprintf("_busy_timeout=%d", opts.BusyTimeout.Milliseconds()))
+	}
+	writer := Connect(driver, withParams(dsn, params...), schema, migrate)
+	if !opts.SingleWriter {
+		return NewDB(writer, writer)
+	}
+	writer.SetMaxOpenConns(1)
+	reader, err := Open(driver, withParams(dsn, append(params, "_query_only=1")...))
+	if err != nil {
+		log.Fatalf("Failed to open readers of the %v database: %v", schema, err)
+	}
+	reader.SetMaxOpenConns(opts.ReadConns)
+	return NewDB(writer, reader)
+}
+
+func withParams(dsn string, params ...string) string {
+	if len(params) == 0 {
+		return dsn
+	}
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	return dsn + separator + strings.Join(params, "&")
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	stmt, err := db.stmt(ctx, db.Writer, query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return db.Writer.ExecContext(ctx, query, args...)
+	}
+	return stmt.ExecContext(ctx, args...)
+}
+
+// QueryContext and QueryRowContext go to the readers or replicas, the
+// repositories only read with them.
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	pool := db.readPool(ctx)
+	stmt, err := db.stmt(ctx, pool, query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return pool.QueryContext(ctx, query, args...)
+	}
+	return stmt.QueryContext(ctx, args...)
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	pool := db.readPool(ctx)
+	stmt, err := db.stmt(ctx, pool, query)
+	if err != nil || stmt == nil {
+		// Row carries a failed prepare to Scan.
+		return pool.QueryRowContext(ctx, query, args...)
+	}
+	return stmt.QueryRowContext(ctx, args...)
+}
+
+func (db *DB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return db.Writer.PrepareContext(ctx, query)
+}
+
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return db.Writer.BeginTx(ctx, opts)
+}
+
+// stmt returns the cached statement for query on pool, preparing it on first
+// use. It is nil for Unprepared queries and once the cache is full, the query
+// then runs unprepared.
+func (db *DB) stmt(ctx context.Context, pool *sql.DB, query string) (*sql.Stmt, error) {
+	if ctx.Value(unpreparedKey{}) != nil {
+		return nil, nil
+	}
+	db.mu.Lock()
+	cached := db.stmts[pool]
+	if cached == nil {
+		cached = map[string]*sql.Stmt{}
+		db.stmts[pool] = cached
+	}
+	stmt, ok := cached[query]
+	full := len(cached) >= maxCachedStmts
+	db.mu.Unlock()
+	if ok || full {
+		return stmt, nil
+	}
+
+	// Prepared without the lock, the single writer may be busy.
+	stmt, err := pool.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if other, ok := db.stmts[pool][query]; ok {
+		stmt.Close()
+		return other, nil
+	}
+	if db.stmts[pool] == nil || len(db.stmts[pool]) >= maxCachedStmts {
+		// Closed meanwhile or filled up by others.
+		stmt.Close()
+		return nil, nil
+	}
+	db.stmts[pool][query] = stmt
+	return stmt, nil
+}
+
+// Close accepts a nil db, the container has none with in-memory storage.
+func (db *DB) Close() error {
+	if db == nil {
+		return nil
+	}
+	db.mu.Lock()
+	for _, cached := range db.stmts {
+		for _, stmt := range cached {
+			stmt.Close()
+		}
+	}
+	db.stmts = map[*sql.DB]map[string]*sql.Stmt{}
+	db.mu.Unlock()
+	err := db.Writer.Close()
+	if db.Reader != db.Writer {
+		if readerErr := db.Reader.Close(); err == nil {
+			err = readerErr
+		}
+	}
+	for _, r := range db.replicas {
+		if replicaErr := r.db.Close(); err == nil {
+			err = replicaErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ecom.com/constants"
+)
+
+func TestConnectPool(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        SQLiteOptions
+		wantSplit   bool
+		wantJournal string
+	}{
+		{
+			name:        "defaults",
+			wantJournal: "delete",
+		},
+		{
+			name:        "wal with a single writer",
+			opts:        SQLiteOptions{WAL: true, BusyTimeout: time.Second, SingleWriter: true, ReadConns: 2},
+			wantSplit:   true,
+			wantJournal: "wal",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := ConnectPool(string(constants.SQLITE_DRIVER), filepath.Join(t.TempDir(), "test.db"), constants.ORDERS_SCHEMA, true, tt.opts)
+			defer db.Close()
+
+			if split := db.Reader != db.Writer; split != tt.wantSplit {
+				t.Errorf("separate readers = %v, want %v", split, tt.wantSplit)
+			}
+			var journal string
+			if err := db.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&journal); err != nil || journal != tt.wantJournal {
+				t.Errorf("journal_mode = %v, %v, want %v", journal, err, tt.wantJournal)
+			}
+
+			insert := `INSERT INTO orders (order_id, user_id, total_amount, status) VALUES (?, 'u', 1, 'Pending')`
+			if _, err := db.ExecContext(ctx, insert, "1"); err != nil {
+				t.Fatalf("ExecContext() error = %v", err)
+			}
+			stmt := db.stmts[db.Writer][insert]
+			if _, err := db.ExecContext(ctx, insert, "2"); err != nil {
+				t.Fatalf("ExecContext() error = %v", err)
+			}
+			if stmt == nil || db.stmts[db.Writer][insert] != stmt {
+				t.Errorf("insert was not prepared once and reused")
+			}
+			var count int
+			if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM orders").Scan(&count); err != nil || count != 2 {
+				t.Errorf("orders = %v, %v, want 2", count, err)
+			}
+			if tt.wantSplit {
+				if _, err := db.Reader.ExecContext(ctx, insert, "3"); err == nil {
+					t.Errorf("write through the readers error = nil, want query only")
+				}
+			}
+		})
+	}
+}
+
+func TestDB_CloseNil(t *testing.T) {
+	var db *DB
+	if err := db.Close(); err != nil {
+		t.Errorf("Close() of nil = %v, want nil", err)
+	}
+}
+
+func TestDB_Unprepared(t *testing.T) {
+	ctx := context.Background()
+	db := ConnectPool(string(constants.SQLITE_DRIVER), filepath.Join(t.TempDir(), "test.db"), constants.ORDERS_SCHEMA, true, SQLiteOptions{})
+	defer db.Close()
+
+	insert := `INSERT INTO orders (order_id, user_id, total_amount, status) VALUES (?, 'u', 1, 'Pending')`
+	if _, err := db.ExecContext(Unprepared(ctx), insert, "1"); err != nil {
+		t.Fatalf("ExecContext() error = %v", err)
+	}
+	var count int
+	query := `SELECT COUNT(*) FROM orders WHERE order_id IN (?)`
+	if err := db.QueryRowContext(Unprepared(ctx), query, "1").Scan(&count); err != nil || count != 1 {
+		t.Errorf("orders = %v, %v, want 1", count, err)
+	}
+	if cached := len(db.stmts[db.Writer]); cached != 0 {
+		t.Errorf("cached statements = %v, want 0", cached)
+	}
+	if _, err := db.ExecContext(ctx, insert, "2"); err != nil {
+		t.Fatalf("ExecContext() error = %v", err)
+	}
+	if db.stmts[db.Writer][insert] == nil {
+		t.Errorf("insert without Unprepared was not cached")
+	}
+}
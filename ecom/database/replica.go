@@ -0,0 +1,175 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ecom.com/constants"
+)
+
+// replicaRecheck is how long a replica that is behind a position is not
+// asked again, a burst of reads with one token costs one query.
+const replicaRecheck = 20 * time.Millisecond
+
+// replica is a read-only copy of the primary, a Postgres standby or a copy
+// of a SQLite file.
+type replica struct {
+	db *sql.DB
+
+	mu       sync.Mutex
+	position uint64 // last one read, only grows on a live replica
+	checked  time.Time
+}
+
+// positions reads replication positions, a larger one is later. Postgres has
+// the WAL LSN, SQLite the change counter of migration 0004 of the orders
+// schema.
+type positions struct {
+	primary string // query on the primary
+	replica string // query on a replica
+	parse   func(string) (uint64, error)
+}
+
+func newPositions(driver string, schema constants.SchemaName) *positions {
+	switch {
+	case constants.DriverName(driver) == constants.POSTGRES_DRIVER:
+		// A replica that is not in recovery is a primary itself.
+		return &positions{
+			primary: `SELECT pg_current_wal_lsn()::text`,
+			replica: `SELECT COALESCE(pg_last_wal_replay_lsn(), pg_current_wal_lsn())::text`,
+			parse:   parseLSN,
+		}
+	case constants.DriverName(driver) == constants.SQLITE_DRIVER && schema == constants.ORDERS_SCHEMA:
+		query := `SELECT position FROM replication_position`
+		return &positions{primary: query, replica: query, parse: func(s string) (uint64, error) {
+			return strconv.ParseUint(s, 10, 64)
+		}}
+	}
+	return nil
+}
+
+// parseLSN turns a Postgres LSN like 16/B374D848 into a number.
+func parseLSN(lsn string) (uint64, error) {
+	hi, lo, ok := strings.Cut(lsn, "/")
+	if !ok {
+		return 0, fmt.Errorf("invalid LSN %q", lsn)
+	}
+	high, err := strconv.ParseUint(hi, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q: %w", lsn, err)
+	}
+	low, err := strconv.ParseUint(lo, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q: %w", lsn, err)
+	}
+	return high<<32 | low, nil
+}
+
+func (p *positions) read(ctx context.Context, db *sql.DB, query string) (uint64, error) {
+	var position string
+	if err := db.QueryRowContext(ctx, query).Scan(&position); err != nil {
+		return 0, err
+	}
+	return p.parse(position)
+}
+
+type readAfterKey struct{ db *DB }
+
+type primaryKey struct{}
+
+// ReadAfter makes the reads of db on ctx skip replicas that have not reached
+// position, they go to the primary if none has.
+func ReadAfter(ctx context.Context, db *DB, position uint64) context.Context {
+	return context.WithValue(ctx, readAfterKey{db: db}, position)
+}
+
+// Primary makes the reads on ctx go to the primary of every DB, for reads
+// that must see the latest writes whatever the client knows.
+func Primary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// ConnectReplicas opens the read-only replicas of db at dsns, reads go to
+// them from then on. The replicas must have the schema of the primary,
+// Postgres streams it to them, a SQLite copy is taken after migrating.
+func (db *DB) ConnectReplicas(driver string, schema constants.SchemaName, dsns []string, opts SQLiteOptions) error {
+	for _, dsn := range dsns {
+		if constants.DriverName(driver) == constants.SQLITE_DRIVER {
+			params := []string{"_query_only=1"}
+			if opts.BusyTimeout > 0 {
+				params = append(params, fmt.Sprintf("_busy_timeout=%d", opts.BusyTimeout.Milliseconds()))
+			}
+			dsn = withParams(dsn, params...)
+		}
+		conn, err := Open(driver, dsn)
+		if err != nil {
+			return err
+		}
+		if err := conn.Ping(); err != nil {
+			conn.Close()
+			return fmt.Errorf("replica of the %v database: %w", schema, err)
+		}
+		db.replicas = append(db.replicas, &replica{db: conn})
+	}
+	if len(db.replicas) > 0 {
+		db.positions = newPositions(driver, schema)
+	}
+	return nil
+}
+
+// Position is the current position of the primary, 0 if db has no replicas
+// or no positions. A token of it makes later reads see what was written
+// before.
+func (db *DB) Position(ctx context.Context) (uint64, error) {
+	if db.positions == nil {
+		return 0, nil
+	}
+	return db.positions.read(ctx, db.Reader, db.positions.primary)
+}
+
+// readPool picks the pool for a read on ctx: the next replica that reached
+// the position ctx reads after, else the primary readers.
+func (db *DB) readPool(ctx context.Context) *sql.DB {
+	if len(db.replicas) == 0 || ctx.Value(primaryKey{}) != nil {
+		return db.Reader
+	}
+	after, _ := ctx.Value(readAfterKey{db: db}).(uint64)
+	start := db.next.Add(1)
+	for i := range db.replicas {
+		r := db.replicas[(start+uint64(i))%uint64(len(db.replicas))]
+		if after == 0 || db.reached(ctx, r, after) {
+			return r.db
+		}
+	}
+	return db.Reader
+}
+
+// reached tells if r is at position or later. A replica without positions
+// never is, the read goes to the primary.
+func (db *DB) reached(ctx context.Context, r *replica, position uint64) bool {
+	if db.positions == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.position >= position {
+		return true
+	}
+	if time.Since(r.checked) < replicaRecheck {
+		return false
+	}
+	current, err := db.positions.read(ctx, r.db, db.positions.replica)
+	r.checked = time.Now()
+	if err != nil {
+		log.Printf("Error reading the position of a replica: %v", err)
+		return false
+	}
+	r.position = current
+	return current >= position
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"ecom.com/constants"
+)
+
+func TestDB_Replicas(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	driver := string(constants.SQLITE_DRIVER)
+	opts := SQLiteOptions{WAL: true, SingleWriter: true}
+	db := ConnectPool(driver, filepath.Join(dir, "primary.db"), constants.ORDERS_SCHEMA, true, opts)
+	defer db.Close()
+
+	// Replicas are copies of the primary taken after the first and the
+	// second order, the third is only on the primary.
+	insert := `INSERT INTO orders (order_id, user_id, total_amount, status) VALUES (?, 'u', 1, 'Pending')`
+	positions := []uint64{}
+	replicas := []string{}
+	for _, id := range []string{"1", "2", "3"} {
+		if _, err := db.ExecContext(ctx, insert, id); err != nil {
+			t.Fatalf("ExecContext() error = %v", err)
+		}
+		var position uint64
+		if err := db.QueryRowContext(ctx, `SELECT position FROM replication_position`).Scan(&position); err != nil {
+			t.Fatalf("reading the position error = %v", err)
+		}
+		positions = append(positions, position)
+		if id != "3" {
+			replica := filepath.Join(dir, "replica"+id+".db")
+			if _, err := db.ExecContext(ctx, `VACUUM INTO ?`, replica); err != nil {
+				t.Fatalf("copying the primary error = %v", err)
+			}
+			replicas = append(replicas, replica)
+		}
+	}
+	if position, _ := db.Position(ctx); position != 0 {
+		t.Errorf("Position() without replicas = %v, want 0", position)
+	}
+	if err := db.ConnectReplicas(driver, constants.ORDERS_SCHEMA, replicas, opts); err != nil {
+		t.Fatalf("ConnectReplicas() error = %v", err)
+	}
+	position, err := db.Position(ctx)
+	if err != nil || position != positions[2] {
+		t.Fatalf("Position() = %v, %v, want %v", position, err, positions[2])
+	}
+	other := NewDB(db.Writer, db.Reader)
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		wantCounts []int // order counts seen over several reads
+	}{
+		{name: "round robin", ctx: ctx, wantCounts: []int{1, 2}},
+		{name: "after the first order", ctx: ReadAfter(ctx, db, positions[0]), wantCounts: []int{1, 2}},
+		{name: "after the second order", ctx: ReadAfter(ctx, db, positions[1]), wantCounts: []int{2}},
+		{name: "after the third order", ctx: ReadAfter(ctx, db, position), wantCounts: []int{3}},
+		{name: "token of another DB", ctx: ReadAfter(ctx, other, position), wantCounts: []int{1, 2}},
+		{name: "primary", ctx: Primary(ctx), wantCounts: []int{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := map[int]bool{}
+			for i := 0; i < 6; i++ {
+				var count int
+				if err := db.QueryRowContext(tt.ctx, "SELECT COUNT(*) FROM orders").Scan(&count); err != nil {
+					t.Fatalf("QueryRowContext() error = %v", err)
+				}
+				seen[count] = true
+			}
+			counts := []int{}
+			for count := range seen {
+				counts = append(counts, count)
+			}
+			sort.Ints(counts)
+			if len(counts) != len(tt.wantCounts) {
+				t.Fatalf("order counts = %v, want %v", counts, tt.wantCounts)
+			}
+			for i := range counts {
+				if counts[i] != tt.wantCounts[i] {
+					t.Fatalf("order counts = %v, want %v", counts, tt.wantCounts)
+				}
+			}
+		})
+	}
+}
+
+func TestParseLSN(t *testing.T) {
+	tests := []struct {
+		lsn     string
+		want    uint64
+		wantErr bool
+	}{
+		{lsn: "0/0", want: 0},
+		{lsn: "16/B374D848", want: 0x16B374D848},
+		{lsn: "B374D848", wantErr: true},
+		{lsn: "16/zz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.lsn, func(t *testing.T) {
+			got, err := parseLSN(tt.lsn)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseLSN(%v) = %v, %v, want %v, error %v", tt.lsn, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
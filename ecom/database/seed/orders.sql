@@ -0,0 +1,16 @@
+-- Sample orders in every status, safe to run more than once.
+-- sqlite3 orders.db < database/seed/orders.sql, or go run main.go migrate seed
+INSERT INTO orders (order_id, user_id, total_amount, status, created_at) VALUES
+	('sample-order-1', 'sample-user-1', 99.99, 'Pending', CURRENT_TIMESTAMP),
+	('sample-order-2', 'sample-user-1', 25.50, 'Processing', CURRENT_TIMESTAMP),
+	('sample-order-3', 'sample-user-2', 310.00, 'Completed', CURRENT_TIMESTAMP),
+	('sample-order-4', 'sample-user-3', 12.75, 'Completed', CURRENT_TIMESTAMP)
+ON CONFLICT (order_id) DO NOTHING;
+
+INSERT INTO items (item_id, amount, order_id) VALUES
+	('item1', 49.99, 'sample-order-1'),
+	('item2', 50.00, 'sample-order-1'),
+	('item3', 25.50, 'sample-order-2'),
+	('item1', 310.00, 'sample-order-3'),
+	('item4', 12.75, 'sample-order-4')
+ON CONFLICT (item_id, order_id) DO NOTHING;
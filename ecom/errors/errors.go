@@ -8,3 +8,10 @@ import (
 var ErrNotFound = errors.New("NotFound")
 var ErrUnintializedInstance = errors.New("not initialized")
 var ErrSqlNOtFound = sql.ErrNoRows
+var ErrAlreadyProcessed = errors.New("already processed")
+var ErrCircuitOpen = errors.New("circuit breaker open")
+var ErrStaleWrite = errors.New("stale write")
+var ErrCachedNotFound = errors.New("cached as not found")
+var ErrSchemaOutdated = errors.New("schema has pending migrations")
+var ErrSchemaUnknown = errors.New("schema has migrations this build does not know")
+var ErrDuplicateKey = errors.New("duplicate key")
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"context"
+	stderrors "errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StatusClientClosedRequest is the non-standard status nginx logs for a
+// client that went away before it got a response.
+const StatusClientClosedRequest = 499
+
+// writeContextError answers a request whose context ended before the service
+// was done and reports whether err was such a case. These are not server
+// errors: either the client gave up or the request ran out of time.
+func writeContextError(c *gin.Context, err error) bool {
+	switch {
+	case stderrors.Is(err, context.Canceled):
+		log.Printf("Request %s %s canceled by the client", c.Request.Method, c.Request.URL.Path)
+		c.JSON(StatusClientClosedRequest, gin.H{"error": "Request canceled"})
+	case stderrors.Is(err, context.DeadlineExceeded):
+		log.Printf("Request %s %s exceeded its deadline", c.Request.Method, c.Request.URL.Path)
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Request timed out"})
+	default:
+		return false
+	}
+	return true
+}
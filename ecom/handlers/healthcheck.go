@@ -3,9 +3,60 @@ package handlers
 import (
 	"net/http"
 
+	"ecom.com/breaker"
+	"ecom.com/common"
+	"ecom.com/queue"
+	"ecom.com/services"
+
 	"github.com/gin-gonic/gin"
 )
 
-func HealthChecksHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "success"})
+type HealthHandler struct {
+	Breakers []*breaker.CircuitBreaker
+	Queues   map[string]queue.QueueI
+	WarmUp   *services.WarmUp
+}
+
+func NewHealthHandler(breakers []*breaker.CircuitBreaker, queues map[string]queue.QueueI, warmUp *services.WarmUp) *HealthHandler {
+	return &HealthHandler{Breakers: breakers, Queues: queues, WarmUp: warmUp}
+}
+
+// HealthChecksHandler reports "degraded" while a circuit breaker is not closed.
+func (h *HealthHandler) HealthChecksHandler(c *gin.Context) {
+	resp := common.HealthResponse{
+		Status:          "success",
+		CircuitBreakers: services.CircuitBreakerStatuses(h.Breakers),
+		PausedQueues:    []string{},
+	}
+	for _, b := range resp.CircuitBreakers {
+		if b.State != string(breaker.CLOSED) {
+			resp.Status = "degraded"
+		}
+	}
+	for name, q := range h.Queues {
+		if q.Paused() {
+			resp.PausedQueues = append(resp.PausedQueues, name)
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// ReadinessHandler reports 503 until the cache warm-up is done.
+func (h *HealthHandler) ReadinessHandler(c *gin.Context) {
+	stats := h.WarmUp.Stats()
+	resp := common.ReadinessResponse{
+		Status: "ready",
+		WarmUp: common.WarmUpStatus{
+			Loaded:     stats.Loaded,
+			Batches:    stats.Batches,
+			DurationMs: stats.Duration.Milliseconds(),
+			TimedOut:   stats.TimedOut,
+		},
+	}
+	if !h.WarmUp.Ready() {
+		resp.Status = "warming_up"
+		c.JSON(http.StatusServiceUnavailable, resp)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
 }
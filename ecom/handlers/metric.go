@@ -19,8 +19,11 @@ func NewMetricHandler(service *services.Metric) *MetricHandler {
 // MetricsHandler handles GET /metrics requests.
 // It calls the metrics service to retrieve metrics data and returns it as JSON.
 func (h *MetricHandler) GetMetricsHandler(c *gin.Context) {
-	metrics, err := h.Service.GetMetrics()
+	metrics, err := h.Service.GetMetrics(c.Request.Context())
 	if err != nil {
+		if writeContextError(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch metrics"})
 		return
 	}
@@ -26,8 +26,11 @@ func (h *OrderHandler) CreateOrderHandler(c *gin.Context) {
 		return
 	}
 
-	orderID, err := h.Service.CreateOrder(req.UserID, req.ItemIDs, req.TotalAmount)
+	orderID, err := h.Service.CreateOrder(c.Request.Context(), req.UserID, req.ItemIDs, req.TotalAmount)
 	if err != nil {
+		if writeContextError(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
 		return
 	}
@@ -38,12 +41,15 @@ func (h *OrderHandler) CreateOrderHandler(c *gin.Context) {
 
 func (h *OrderHandler) GetOrderHandler(c *gin.Context) {
 	orderID := c.Param("id")
-	order, err := h.Service.GetOrder(orderID)
+	order, err := h.Service.GetOrder(c.Request.Context(), orderID)
 	if err != nil {
 		if err == errors.ErrSqlNOtFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 			return
 		}
+		if writeContextError(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order status"})
 		return
 	}
@@ -51,10 +57,35 @@ func (h *OrderHandler) GetOrderHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, order)
 }
 
+// ReprocessOrderHandler handles POST /orders/:id/reprocess requests. The
+// order is processed again even if the ledger says it already was.
+func (h *OrderHandler) ReprocessOrderHandler(c *gin.Context) {
+	orderID := c.Param("id")
+	if err := h.Service.ReprocessOrder(c.Request.Context(), orderID); err != nil {
+		if err == errors.ErrSqlNOtFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return
+		}
+		if writeContextError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reprocess order"})
+		return
+	}
+	c.JSON(http.StatusOK, &common.OrderAckResponse{Message: "Order queued for reprocessing", OrderID: orderID})
+}
+
 func (h *OrderHandler) GetOrderStatusHandler(c *gin.Context) {
 	orderID := c.Param("id")
-	status, err := h.Service.GetOrderStatus(orderID)
+	status, err := h.Service.GetOrderStatus(c.Request.Context(), orderID)
 	if err != nil {
+		if err == errors.ErrSqlNOtFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return
+		}
+		if writeContextError(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order status"})
 		return
 	}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+
+	"ecom.com/common"
+	"ecom.com/queue"
+
+	"github.com/gin-gonic/gin"
+)
+
+type QueueHandler struct {
+	Queues map[string]queue.QueueI
+}
+
+func NewQueueHandler(queues map[string]queue.QueueI) *QueueHandler {
+	return &QueueHandler{Queues: queues}
+}
+
+// GetRateLimitHandler handles GET /queues/:name/rate-limit requests.
+func (h *QueueHandler) GetRateLimitHandler(c *gin.Context) {
+	q, ok := h.Queues[c.Param("name")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Queue not found"})
+		return
+	}
+	ratePerSec, burst := q.RateLimit()
+	c.JSON(http.StatusOK, common.RateLimitResponse{Queue: q.Name(), Rate: ratePerSec, Burst: burst})
+}
+
+// SetRateLimitHandler handles PUT /queues/:name/rate-limit requests.
+// A rate of 0 removes the limit.
+func (h *QueueHandler) SetRateLimitHandler(c *gin.Context) {
+	q, ok := h.Queues[c.Param("name")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Queue not found"})
+		return
+	}
+	req := common.RateLimitRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if *req.Rate < 0 || req.Burst < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rate and burst must not be negative"})
+		return
+	}
+	q.SetRateLimit(*req.Rate, req.Burst)
+	ratePerSec, burst := q.RateLimit()
+	c.JSON(http.StatusOK, common.RateLimitResponse{Queue: q.Name(), Rate: ratePerSec, Burst: burst})
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
+	"os"
 
 	"ecom.com/config"
-	"ecom.com/database"
 	"ecom.com/logger"
 	"ecom.com/routes"
 	"ecom.com/server"
@@ -23,16 +25,55 @@ Start the Gin server.
 */
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		config.LoadConfig("config/config.yaml")
+		if err := runMigrate(config.AppConfig, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "archive" {
+		config.LoadConfig("config/config.yaml")
+		if err := runArchive(config.AppConfig, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "reshard" {
+		config.LoadConfig("config/config.yaml")
+		if err := runReshard(config.AppConfig, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	storage := flag.String("storage", "", "sql or memory, overrides storage in the config")
+	flag.Parse()
 	logger.InitLogger("app.log", 10, 5, 30, true)
 	logger.Logger.Println("Logger initialized")
 	config.LoadConfig("config/config.yaml")
+	if *storage != "" {
+		config.AppConfig.Storage = *storage
+	}
 
 	container := server.NewContainer(config.AppConfig)
-	defer database.CloseDB(container.DB)
-	defer database.CloseDB(container.MetricDB)
+	defer container.DB.Close()
+	for _, db := range container.ShardDBs {
+		defer db.Close()
+	}
+	defer container.MetricDB.Close()
+	defer container.Cache.Close()
 
 	container.OrderService.GetOrderCreationQueue().StartOrderProcessor()
 	container.OrderService.GetOrderProcessQueue().StartOrderProcessor()
+	container.Reconciler.Start()
+	defer container.Reconciler.Stop()
+	container.Archiver.Start()
+	defer container.Archiver.Stop()
+	if config.AppConfig.WarmUp.Enabled {
+		go container.WarmUp.Run(context.Background())
+	} else {
+		container.WarmUp.Skip()
+	}
 
 	r := gin.Default()
 	routes.RegisterRoutes(r, container.RoutesCfg)
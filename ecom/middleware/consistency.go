@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+
+	"ecom.com/database"
+	"github.com/gin-gonic/gin"
+)
+
+// ConsistencyHeader carries the read-your-writes token of the orders DB.
+const ConsistencyHeader = "X-Consistency-Token"
+
+// ConsistencyMiddleware gives the responses of requests that write, any
+// method but GET and HEAD, the position the primary of db reached once the
+// handler ran. A client that sends it back only reads from replicas that
+// caught up with it, so it sees what the request wrote. Reads get no token,
+// they would cost a query on the primary each. A malformed token is ignored.
+//
+// POST /orders waits for the creation worker to store the order, so the token
+// covers it. Processing and reprocess write after the request answered, reads
+// of those rely on the primary fallback of getOrder in the order service.
+func ConsistencyMiddleware(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if token := c.GetHeader(ConsistencyHeader); token != "" {
+			if position, err := strconv.ParseUint(token, 10, 64); err == nil {
+				ctx = database.ReadAfter(ctx, db, position)
+				c.Request = c.Request.WithContext(ctx)
+			}
+		}
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+		w := &tokenWriter{ResponseWriter: c.Writer, ctx: ctx, db: db}
+		c.Writer = w
+		c.Next()
+		w.setToken()
+	}
+}
+
+// tokenWriter sets the token header right before the response goes out,
+// headers written later are lost.
+type tokenWriter struct {
+	gin.ResponseWriter
+	ctx  context.Context
+	db   *database.DB
+	done bool
+}
+
+func (w *tokenWriter) setToken() {
+	if w.done || w.Written() {
+		return
+	}
+	w.done = true
+	position, err := w.db.Position(w.ctx)
+	if err != nil {
+		log.Printf("Error reading the position of the orders database: %v", err)
+	} else if position > 0 {
+		w.Header().Set(ConsistencyHeader, strconv.FormatUint(position, 10))
+	}
+}
+
+func (w *tokenWriter) WriteHeader(code int) {
+	w.setToken()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *tokenWriter) WriteHeaderNow() {
+	w.setToken()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *tokenWriter) Write(data []byte) (int, error) {
+	w.setToken()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *tokenWriter) WriteString(s string) (int, error) {
+	w.setToken()
+	return w.ResponseWriter.WriteString(s)
+}
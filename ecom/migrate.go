@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"ecom.com/config"
+	"ecom.com/constants"
+	"ecom.com/database"
+)
+
+const migrateUsage = `usage: go run main.go migrate <up|down|status|seed> [-db orders|metrics|all] [-steps n]
+  up      apply pending migrations
+  down    roll back the last -steps migrations (default 1)
+  status  list migrations and when they were applied
+  seed    load sample orders into the orders DB, the first shard with database.shards`
+
+type migrateTarget struct {
+	schema constants.SchemaName
+	shard  string // name of the orders shard, "" without shards
+	driver string
+	dsn    string
+}
+
+func (t migrateTarget) String() string {
+	if t.shard != "" {
+		return string(t.schema) + "/" + t.shard
+	}
+	return string(t.schema)
+}
+
+// runMigrate implements the migrate command against the DBs in appConfig.
+func runMigrate(appConfig config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	command := args[0]
+	switch command {
+	case "up", "down", "status", "seed":
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%v", command, migrateUsage)
+	}
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	only := flags.String("db", "all", "orders, metrics or all")
+	steps := flags.Int("steps", 1, "migrations to roll back with down")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	targets := []migrateTarget{}
+	if shards := appConfig.Database.Shards; len(shards) > 0 {
+		for _, shard := range shards {
+			targets = append(targets, migrateTarget{schema: constants.ORDERS_SCHEMA, shard: shard.Name, driver: appConfig.Database.Driver, dsn: shard.DSN})
+		}
+	} else {
+		targets = append(targets, migrateTarget{schema: constants.ORDERS_SCHEMA, driver: appConfig.Database.Driver, dsn: appConfig.Database.DSN})
+	}
+	targets = append(targets, migrateTarget{schema: constants.METRICS_SCHEMA, driver: appConfig.Metrics.Driver, dsn: appConfig.Metrics.DSN})
+	for _, target := range targets {
+		if *only != "all" && *only != string(target.schema) {
+			continue
+		}
+		if command == "seed" && target.schema != constants.ORDERS_SCHEMA {
+			continue
+		}
+		if command == "seed" && target.shard != "" && target.shard != appConfig.Database.Shards[0].Name {
+			// One copy of the sample orders, reshard places them.
+			continue
+		}
+		if err := migrateOne(target, command, *steps); err != nil {
+			return fmt.Errorf("%v: %w", target, err)
+		}
+	}
+	return nil
+}
+
+func migrateOne(target migrateTarget, command string, steps int) error {
+	db, err := database.Open(target.driver, target.dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	migrator, err := database.NewMigrator(db, target.driver, target.schema)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up()
+		fmt.Printf("%v: applied %v migrations\n", target, applied)
+		return err
+	case "down":
+		rolledBack, err := migrator.Down(steps)
+		fmt.Printf("%v: rolled back %v migrations\n", target, rolledBack)
+		return err
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%v: %04d %-30v %v\n", target, status.Version, status.Name, applied)
+		}
+		return nil
+	case "seed":
+		if err := migrator.Check(); err != nil {
+			return err
+		}
+		if err := database.Seed(db); err != nil {
+			return err
+		}
+		fmt.Printf("%v: loaded sample data\n", target)
+	}
+	return nil
+}
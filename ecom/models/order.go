@@ -1,8 +1,11 @@
 package models
 
+import "time"
+
 type Order struct {
-	OrderID     string  `json:"order_id"`
-	UserID      string  `json:"user_id"`
-	TotalAmount float64 `json:"total_amount"`
-	Status      string  `json:"status"`
+	OrderID     string    `json:"order_id"`
+	UserID      string    `json:"user_id"`
+	TotalAmount float64   `json:"total_amount"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"` // only read by ListActiveOrders
 }
@@ -0,0 +1,8 @@
+package models
+
+// ProcessedItem is a ledger entry for a queue item claimed by a stage.
+type ProcessedItem struct {
+	ItemID string
+	Stage  string
+	Force  bool
+}
@@ -3,5 +3,19 @@ package queue
 type QueueI interface {
 	StartOrderProcessor() error
 	StopOrderProcessor()
-	Enqueue(item Item)
+	// Enqueue returns false if the item was dropped.
+	Enqueue(item Item) bool
+	Name() string
+	SetRateLimit(ratePerSec float64, burst int)
+	RateLimit() (float64, int)
+	Paused() bool
+	Stats() QueueStats
+}
+
+type QueueStats struct {
+	Name        string
+	Panics      int64 // worker panics recovered
+	FailedItems int64 // items given up on after panicking every retry
+	LostMetrics int64 // metric rows of processed items that could not be stored
+	Paused      bool
 }
@@ -1,95 +1,530 @@
 package queue
 
 import (
+	"context"
 	"log"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"ecom.com/breaker"
 	"ecom.com/cache"
 	"ecom.com/constants"
+	"ecom.com/errors"
 	"ecom.com/models"
 	"ecom.com/repository"
 )
 
+const (
+	// How often paused workers look at the circuit breakers again.
+	breakerPollInterval = 100 * time.Millisecond
+	// How often an item that panicked a worker is retried before it is failed.
+	maxPanicRetries = 2
+	// How often metric rows that failed to be stored are tried again, and
+	// how long to wait before.
+	maxMetricRetries = 1
+	metricRetryDelay = 100 * time.Millisecond
+)
+
 type Item struct {
-	Id    string
-	Value any
+	Id       string
+	Value    any
+	Force    bool // process even if the ledger has it for this stage
+	Attempts int  // times a worker panicked on it
+	Claimed  bool // holds its ledger entry from an earlier attempt
+}
+
+// BatchConfig makes a worker take up to Size items, or whatever arrived
+// within Wait of the first one, and hand them over together.
+type BatchConfig struct {
+	Size int
+	Wait time.Duration
 }
 
 type Queue struct {
+	name             string
 	orderQueue       chan Item
 	workerPool       int
 	wg               sync.WaitGroup
 	orderRepo        repository.OrderRepositoryI
 	metricRepo       repository.MetricRepositoryI
-	processOrderFunc func(item Item)
+	ledger           repository.LedgerRepositoryI
+	processOrderFunc func(item Item) error
+	processBatchFunc func(items []Item) []error
+	batch            BatchConfig
 	cache            cache.CacheI
+	limiter          *RateLimiter
+	breakers         []*breaker.CircuitBreaker
+	paused           atomic.Bool
+	probing          atomic.Bool
+	panics           atomic.Int64
+	failed           atomic.Int64
+	lostMetrics      atomic.Int64
+	ctx              context.Context
+	cancel           context.CancelFunc
 	stopChan         chan struct{} // Channel for graceful shutdown
+	// Held for writing while stopping, so nothing is sent on the closed
+	// orderQueue or adds a worker after wg.Wait started.
+	stopMu  sync.RWMutex
+	stopped bool
+}
+
+func NewQueue(name string, poolSize int, queueCapacity int, limiter *RateLimiter, processOrderFunc func(item Item) error, metricRepo repository.MetricRepositoryI, ledger repository.LedgerRepositoryI, orderRepo repository.OrderRepositoryI, cache cache.CacheI, breakers ...*breaker.CircuitBreaker) QueueI {
+	q := newQueue(name, poolSize, queueCapacity, limiter, metricRepo, ledger, orderRepo, cache, breakers)
+	q.processOrderFunc = processOrderFunc
+	return q
+}
+
+// NewBatchQueue returns a queue whose workers process items in batches.
+// processBatchFunc must return one result per item, in order.
+func NewBatchQueue(name string, poolSize int, queueCapacity int, limiter *RateLimiter, batch BatchConfig, processBatchFunc func(items []Item) []error, metricRepo repository.MetricRepositoryI, ledger repository.LedgerRepositoryI, orderRepo repository.OrderRepositoryI, cache cache.CacheI, breakers ...*breaker.CircuitBreaker) QueueI {
+	q := newQueue(name, poolSize, queueCapacity, limiter, metricRepo, ledger, orderRepo, cache, breakers)
+	q.processBatchFunc = processBatchFunc
+	q.batch = batch
+	return q
 }
 
-func NewQueue(poolSize int, queueCapacity int, processOrderFunc func(item Item), metricRepo repository.MetricRepositoryI, orderRepo repository.OrderRepositoryI, cache cache.CacheI) QueueI {
+func newQueue(name string, poolSize int, queueCapacity int, limiter *RateLimiter, metricRepo repository.MetricRepositoryI, ledger repository.LedgerRepositoryI, orderRepo repository.OrderRepositoryI, cache cache.CacheI, breakers []*breaker.CircuitBreaker) *Queue {
+	if limiter == nil {
+		limiter = NewRateLimiter(0, 0)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Queue{
-		orderQueue:       make(chan Item, queueCapacity),
-		workerPool:       poolSize,
-		wg:               sync.WaitGroup{},
-		orderRepo:        orderRepo,
-		metricRepo:       metricRepo,
-		processOrderFunc: processOrderFunc,
-		cache:            cache,
-		stopChan:         make(chan struct{}),
+		name:       name,
+		orderQueue: make(chan Item, queueCapacity),
+		workerPool: poolSize,
+		wg:         sync.WaitGroup{},
+		orderRepo:  orderRepo,
+		metricRepo: metricRepo,
+		ledger:     ledger,
+		cache:      cache,
+		limiter:    limiter,
+		breakers:   breakers,
+		ctx:        ctx,
+		cancel:     cancel,
+		stopChan:   make(chan struct{}),
 	}
 }
 
 func (q *Queue) StartOrderProcessor() error {
 	for i := 0; i < q.workerPool; i++ {
-		q.wg.Add(1)
-		go q.worker(q.processOrderFunc)
+		q.spawnWorker()
 	}
 	return nil
 }
 
-func (q *Queue) worker(processOrderFunc func(item Item)) {
+func (q *Queue) spawnWorker() {
+	q.wg.Add(1)
+	if q.processBatchFunc != nil {
+		go q.batchWorker(q.processBatchFunc)
+	} else {
+		go q.worker(q.processOrderFunc)
+	}
+}
+
+// workerState is what a worker holds, so supervise can clean up after a panic.
+type workerState struct {
+	items []Item
+	probe bool
+}
+
+// supervise recovers a panicking worker. The items it held are retried up to
+// maxPanicRetries times and then marked failed, and a new worker replaces it
+// so the pool stays at full size.
+func (q *Queue) supervise(state *workerState) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	q.panics.Add(1)
+	log.Printf("Queue %s worker panicked: %v\n%s", q.name, r, debug.Stack())
+	if state.probe {
+		q.probing.Store(false)
+	}
+	q.stopMu.RLock()
+	defer q.stopMu.RUnlock()
+	var failed []Item
+	if q.stopped {
+		// Shutting down, neither retry the items nor replace the worker.
+		for _, item := range state.items {
+			q.failed.Add(1)
+			log.Printf("Queue %s dropping item %v, stopped after a panic: %v", q.name, item.Id, r)
+		}
+		q.release(state.items)
+		return
+	}
+	for _, item := range state.items {
+		if item.Attempts < maxPanicRetries {
+			item.Attempts++
+			if q.enqueue(item) {
+				log.Printf("Queue %s retrying item %v, attempt %v", q.name, item.Id, item.Attempts)
+				continue
+			}
+			log.Printf("Queue %s marking item %v failed, no room to retry it: %v", q.name, item.Id, r)
+		} else {
+			log.Printf("Queue %s marking item %v failed after %v attempts: %v", q.name, item.Id, item.Attempts+1, r)
+		}
+		q.failed.Add(1)
+		failed = append(failed, item)
+	}
+	q.release(failed)
+	q.spawnWorker()
+}
+
+func (q *Queue) worker(processOrderFunc func(item Item) error) {
+	state := &workerState{}
 	defer q.wg.Done()
+	defer q.supervise(state)
 	for {
+		probe, running := q.waitForBreakers()
+		if !running {
+			return
+		}
+		state.probe = probe
 		select {
 		case item, ok := <-q.orderQueue:
 			if !ok {
 				// Queue closed, exit worker.
 				return
 			}
-			start := time.Now()
-			processOrderFunc(item)
-			duration := time.Since(start)
-
-			// Log processing time as a metric
-			err := q.metricRepo.CreateMetric(&models.Metric{
-				OrderId:    item.Id,
-				Duration:   duration.Seconds(),
-				MetricName: string(constants.PROCESSING_TIME),
-			})
-			if err != nil {
-				log.Println("Error updating metrics in MetricsDB:", err)
+			state.items = q.claim([]Item{item})
+			if len(state.items) > 0 && !q.processItem(processOrderFunc, state.items[0]) {
+				return
 			}
+			state.items = nil
 		case <-q.stopChan:
 			// Received stop signal, exit gracefully.
 			return
 		}
+		if probe {
+			q.probing.Store(false)
+			state.probe = false
+		}
 	}
 }
 
-func (q *Queue) Enqueue(item Item) {
+// processItem returns false if the queue was stopped before item could run.
+// An item that fails is released for another delivery and gets no metrics.
+func (q *Queue) processItem(processOrderFunc func(item Item) error, item Item) bool {
+	// Wait for a token before touching downstream systems.
+	waited, err := q.limiter.Wait(q.ctx)
+	if err != nil {
+		log.Printf("Queue %s stopped while waiting on rate limiter, item %v not processed", q.name, item.Id)
+		q.release([]Item{item})
+		return false
+	}
+
+	start := time.Now()
+	err = processOrderFunc(item)
+	duration := time.Since(start)
+	if err != nil {
+		log.Printf("Queue %s failed to process item %v err %v", q.name, item.Id, err)
+		q.release([]Item{item})
+		return true
+	}
+
+	// Log processing time as a metric
+	q.record(q.itemMetrics(item, duration, waited))
+	return true
+}
+
+func (q *Queue) batchWorker(processBatchFunc func(items []Item) []error) {
+	state := &workerState{}
+	defer q.wg.Done()
+	defer q.supervise(state)
+	for {
+		probe, running := q.waitForBreakers()
+		if !running {
+			return
+		}
+		state.probe = probe
+		items, ok := q.nextBatch()
+		state.items = q.claim(items)
+		if len(state.items) > 0 {
+			q.processBatch(processBatchFunc, state.items)
+		}
+		state.items = nil
+		if !ok {
+			return
+		}
+		if probe {
+			q.probing.Store(false)
+			state.probe = false
+		}
+	}
+}
+
+// waitForBreakers pauses the worker while the breaker of a dependency is
+// open. Once a breaker goes half open a single worker is let through, its
+// calls are the probes that close the breaker again and resume the rest.
+// running is false if the queue was stopped while waiting.
+func (q *Queue) waitForBreakers() (probe bool, running bool) {
+	for {
+		state := q.breakerState()
+		if state == breaker.CLOSED {
+			if q.paused.CompareAndSwap(true, false) {
+				log.Printf("Queue %s resumed, circuit breakers closed", q.name)
+			}
+			return false, true
+		}
+		if state == breaker.HALF_OPEN && q.probing.CompareAndSwap(false, true) {
+			return true, true
+		}
+		if q.paused.CompareAndSwap(false, true) {
+			log.Printf("Queue %s paused, circuit breaker open", q.name)
+		}
+		select {
+		case <-time.After(breakerPollInterval):
+		case <-q.stopChan:
+			return false, false
+		}
+	}
+}
+
+// breakerState returns the worst state among the queue's breakers.
+func (q *Queue) breakerState() breaker.State {
+	state := breaker.CLOSED
+	for _, b := range q.breakers {
+		switch b.State() {
+		case breaker.OPEN:
+			return breaker.OPEN
+		case breaker.HALF_OPEN:
+			state = breaker.HALF_OPEN
+		}
+	}
+	return state
+}
+
+// Stats returns the panic supervision counters of the queue.
+func (q *Queue) Stats() QueueStats {
+	return QueueStats{
+		Name:        q.name,
+		Panics:      q.panics.Load(),
+		FailedItems: q.failed.Load(),
+		LostMetrics: q.lostMetrics.Load(),
+		Paused:      q.paused.Load(),
+	}
+}
+
+// Paused reports whether workers are held back by an open circuit breaker.
+func (q *Queue) Paused() bool {
+	return q.paused.Load()
+}
+
+// nextBatch blocks for the first item and then collects more until the batch
+// is full or the batch wait expires. ok is false once the queue is stopped.
+func (q *Queue) nextBatch() (items []Item, ok bool) {
+	select {
+	case item, open := <-q.orderQueue:
+		if !open {
+			return nil, false
+		}
+		items = append(items, item)
+	case <-q.stopChan:
+		return nil, false
+	}
+
+	timer := time.NewTimer(q.batch.Wait)
+	defer timer.Stop()
+	for len(items) < q.batch.Size {
+		select {
+		case item, open := <-q.orderQueue:
+			if !open {
+				return items, false
+			}
+			items = append(items, item)
+		case <-timer.C:
+			return items, true
+		case <-q.stopChan:
+			return items, false
+		}
+	}
+	return items, true
+}
+
+func (q *Queue) processBatch(processBatchFunc func(items []Item) []error, items []Item) {
+	waited := make([]time.Duration, len(items))
+	for i := range items {
+		w, err := q.limiter.Wait(q.ctx)
+		if err != nil {
+			log.Printf("Queue %s stopped while waiting on rate limiter, %v items not processed", q.name, len(items))
+			q.release(items)
+			return
+		}
+		waited[i] = w
+	}
+
+	start := time.Now()
+	errs := processBatchFunc(items)
+	duration := time.Since(start)
+
+	// Only items that went through get a processing metric.
+	var failed []Item
+	var metrics []*models.Metric
+	for i, item := range items {
+		if errs[i] != nil {
+			log.Printf("Queue %s failed to process item %v err %v", q.name, item.Id, errs[i])
+			failed = append(failed, item)
+			continue
+		}
+		metrics = append(metrics, q.itemMetrics(item, duration, waited[i])...)
+	}
+	q.release(failed)
+	q.record(metrics)
+}
+
+func (q *Queue) itemMetrics(item Item, duration, waited time.Duration) []*models.Metric {
+	metrics := []*models.Metric{{
+		OrderId:    item.Id,
+		Duration:   duration.Seconds(),
+		MetricName: string(constants.PROCESSING_TIME),
+	}}
+	if q.limiter.Enabled() {
+		metrics = append(metrics, &models.Metric{
+			OrderId:    item.Id,
+			Duration:   waited.Seconds(),
+			MetricName: string(constants.RATE_LIMIT_WAIT_TIME),
+		})
+	}
+	return metrics
+}
+
+// claim records items in the ledger before they run and returns those that
+// may run: of two deliveries of an item only the one that claims it runs the
+// stage. Items are processed at most once, one claimed by a worker that dies
+// before its side effects is lost unless an operator forces it. Forced items
+// and retries of claimed items always run, and so does everything when the
+// ledger fails, as without a ledger. Queue items outlive the request that
+// created them, so ledger and metric writes run without a deadline.
+func (q *Queue) claim(items []Item) []Item {
+	if q.ledger == nil || len(items) == 0 {
+		return items
+	}
+	var entries []*models.ProcessedItem
+	var claiming []int
+	for i, item := range items {
+		if !item.Claimed {
+			entries = append(entries, &models.ProcessedItem{ItemID: item.Id, Stage: q.name, Force: item.Force})
+			claiming = append(claiming, i)
+		}
+	}
+	skip := map[int]bool{}
+	for j, err := range q.ledger.MarkProcessed(context.Background(), entries) {
+		i := claiming[j]
+		switch {
+		case err == errors.ErrAlreadyProcessed:
+			log.Printf("Queue %s skipping already processed item %v", q.name, items[i].Id)
+			skip[i] = true
+		case err != nil:
+			log.Printf("Queue %s failed to claim item %v, processing anyway: %v", q.name, items[i].Id, err)
+		default:
+			items[i].Claimed = true
+		}
+	}
+	var pending []Item
+	for i, item := range items {
+		if !skip[i] {
+			pending = append(pending, item)
+		}
+	}
+	return pending
+}
+
+// release drops the claims of items that did not go through, so they can be
+// delivered again. A forced item keeps its entry, it went through before.
+func (q *Queue) release(items []Item) {
+	if q.ledger == nil {
+		return
+	}
+	var ids []string
+	for _, item := range items {
+		if item.Claimed && !item.Force {
+			ids = append(ids, item.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	if err := q.ledger.UnmarkProcessed(context.Background(), q.name, ids); err != nil {
+		log.Printf("Queue %s failed to release items %v: %v", q.name, ids, err)
+	}
+}
+
+// record stores the metrics of processed items, the claim already made sure
+// an item is only counted once per queue. The metrics DB is not the orders
+// DB, by now the items went through whatever happens to their metrics: rows
+// that fail are tried again and then counted as lost, the items keep their
+// claims.
+func (q *Queue) record(metrics []*models.Metric) {
+	for attempt := 0; len(metrics) > 0; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(metricRetryDelay):
+			case <-q.ctx.Done():
+			}
+		}
+		var failed []*models.Metric
+		for i, err := range q.metricRepo.CreateMetricBatch(context.Background(), metrics) {
+			if err != nil {
+				log.Printf("Error updating metrics in MetricsDB for order %v: %v", metrics[i].OrderId, err)
+				failed = append(failed, metrics[i])
+			}
+		}
+		if len(failed) > 0 && attempt == maxMetricRetries {
+			q.lostMetrics.Add(int64(len(failed)))
+			log.Printf("Queue %s lost %v metrics of processed items", q.name, len(failed))
+			return
+		}
+		metrics = failed
+	}
+}
+
+func (q *Queue) Enqueue(item Item) bool {
+	q.stopMu.RLock()
+	defer q.stopMu.RUnlock()
+	if q.stopped {
+		log.Println("Warning: Queue is stopped. Dropping item:", item.Id)
+		return false
+	}
+	return q.enqueue(item)
+}
+
+// enqueue needs stopMu held and the queue running. It returns false if the
+// queue was full and item was dropped.
+func (q *Queue) enqueue(item Item) bool {
 	select {
 	case q.orderQueue <- item:
 		// Successfully enqueued item
+		return true
 	default:
 		log.Println("Warning: Queue is full. Dropping item:", item.Id)
+		return false
 	}
 }
 
+func (q *Queue) Name() string {
+	return q.name
+}
+
+// SetRateLimit changes the token bucket of a running queue.
+func (q *Queue) SetRateLimit(ratePerSec float64, burst int) {
+	q.limiter.SetLimit(ratePerSec, burst)
+	log.Printf("Queue %s rate limit set to %v/s burst %v", q.name, ratePerSec, burst)
+}
+
+func (q *Queue) RateLimit() (float64, int) {
+	return q.limiter.Limit()
+}
+
 // StopOrderProcessor gracefully shuts down all workers.
 func (q *Queue) StopOrderProcessor() {
+	q.stopMu.Lock()
+	q.stopped = true
 	close(q.stopChan)   // Notify workers to stop
+	q.cancel()          // Release workers blocked on the rate limiter
 	close(q.orderQueue) // Close queue to prevent new items
+	q.stopMu.Unlock()
 
 	q.wg.Wait() // Wait for all workers to finish
 	log.Println("Order processing stopped.")
@@ -0,0 +1,537 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"ecom.com/breaker"
+	"ecom.com/constants"
+	ecomerrors "ecom.com/errors"
+	"ecom.com/models"
+)
+
+type fakeMetricRepo struct {
+	mu       sync.Mutex
+	metrics  []models.Metric
+	batches  int
+	failures int // batches to fail before storing any
+}
+
+func (f *fakeMetricRepo) CreateMetric(ctx context.Context, m *models.Metric) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.metrics = append(f.metrics, *m)
+	return nil
+}
+
+func (f *fakeMetricRepo) CreateMetricBatch(ctx context.Context, metrics []*models.Metric) []error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.batches++
+	if f.failures > 0 {
+		f.failures--
+		errs := make([]error, len(metrics))
+		for i := range errs {
+			errs[i] = errors.New("metrics DB down")
+		}
+		return errs
+	}
+	for _, m := range metrics {
+		f.metrics = append(f.metrics, *m)
+	}
+	return make([]error, len(metrics))
+}
+
+func (f *fakeMetricRepo) GetMetricByID(ctx context.Context, id int, name string) (*models.Metric, error) {
+	return nil, nil
+}
+
+func (f *fakeMetricRepo) GetMetricCount(ctx context.Context, metricName string) (*int, error) {
+	return nil, nil
+}
+
+func (f *fakeMetricRepo) GetAverageTime(ctx context.Context, metricname string) (*float64, error) {
+	return nil, nil
+}
+
+func (f *fakeMetricRepo) count(name constants.MetricName) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, m := range f.metrics {
+		if m.MetricName == string(name) {
+			n++
+		}
+	}
+	return n
+}
+
+type fakeLedger struct {
+	mu        sync.Mutex
+	processed map[string]bool
+}
+
+// claimed returns which of itemIds hold an entry for stage.
+func (f *fakeLedger) claimed(stage string, itemIds ...string) map[string]bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	claimed := map[string]bool{}
+	for _, id := range itemIds {
+		if f.processed[stage+"/"+id] {
+			claimed[id] = true
+		}
+	}
+	return claimed
+}
+
+func (f *fakeLedger) MarkProcessed(ctx context.Context, items []*models.ProcessedItem) []error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	errs := make([]error, len(items))
+	for i, item := range items {
+		key := item.Stage + "/" + item.ItemID
+		if f.processed[key] && !item.Force {
+			errs[i] = ecomerrors.ErrAlreadyProcessed
+			continue
+		}
+		f.processed[key] = true
+	}
+	return errs
+}
+
+func (f *fakeLedger) UnmarkProcessed(ctx context.Context, stage string, itemIds []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, id := range itemIds {
+		delete(f.processed, stage+"/"+id)
+	}
+	return nil
+}
+
+func TestQueue_RateLimit(t *testing.T) {
+	tests := []struct {
+		name         string
+		rate         float64
+		burst        int
+		items        int
+		minDuration  time.Duration
+		waitMetrics  int
+		rateAfterSet float64
+	}{
+		{
+			name:        "unlimited",
+			rate:        0,
+			items:       5,
+			waitMetrics: 0,
+		},
+		{
+			name:        "10 per second",
+			rate:        10,
+			burst:       1,
+			items:       5,
+			minDuration: 350 * time.Millisecond,
+			waitMetrics: 5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metricRepo := &fakeMetricRepo{}
+			done := make(chan struct{}, tt.items)
+			q := NewQueue("test", 4, tt.items, NewRateLimiter(tt.rate, tt.burst), func(item Item) error {
+				done <- struct{}{}
+				return nil
+			}, metricRepo, nil, nil, nil)
+			q.StartOrderProcessor()
+
+			start := time.Now()
+			for i := 0; i < tt.items; i++ {
+				q.Enqueue(Item{Id: string(rune('a' + i))})
+			}
+			for i := 0; i < tt.items; i++ {
+				<-done
+			}
+			if elapsed := time.Since(start); elapsed < tt.minDuration {
+				t.Errorf("processed %v items in %v, want at least %v", tt.items, elapsed, tt.minDuration)
+			}
+			q.StopOrderProcessor()
+
+			if got := metricRepo.count(constants.RATE_LIMIT_WAIT_TIME); got != tt.waitMetrics {
+				t.Errorf("rate limit wait metrics = %v, want %v", got, tt.waitMetrics)
+			}
+			if got := metricRepo.count(constants.PROCESSING_TIME); got != tt.items {
+				t.Errorf("processing time metrics = %v, want %v", got, tt.items)
+			}
+		})
+	}
+}
+
+func TestQueue_SetRateLimit(t *testing.T) {
+	q := NewQueue("test", 1, 1, nil, func(item Item) error { return nil }, &fakeMetricRepo{}, nil, nil, nil)
+	if r, _ := q.RateLimit(); r != 0 {
+		t.Errorf("RateLimit() = %v, want unlimited", r)
+	}
+	q.SetRateLimit(5, 2)
+	if r, b := q.RateLimit(); r != 5 || b != 2 {
+		t.Errorf("RateLimit() = %v, %v, want 5, 2", r, b)
+	}
+	q.SetRateLimit(0, 0)
+	if r, _ := q.RateLimit(); r != 0 {
+		t.Errorf("RateLimit() = %v, want unlimited", r)
+	}
+}
+
+func TestQueue_Batch(t *testing.T) {
+	metricRepo := &fakeMetricRepo{}
+	var mu sync.Mutex
+	var sizes []int
+	done := make(chan struct{}, 5)
+	q := NewBatchQueue("test", 1, 5, nil, BatchConfig{Size: 3, Wait: 100 * time.Millisecond}, func(items []Item) []error {
+		mu.Lock()
+		sizes = append(sizes, len(items))
+		mu.Unlock()
+		errs := make([]error, len(items))
+		for i, item := range items {
+			if item.Id == "bad" {
+				errs[i] = errors.New("failed")
+			}
+			done <- struct{}{}
+		}
+		return errs
+	}, metricRepo, nil, nil, nil)
+
+	for _, id := range []string{"a", "bad", "c", "d", "e"} {
+		q.Enqueue(Item{Id: id})
+	}
+	q.StartOrderProcessor()
+	for i := 0; i < 5; i++ {
+		<-done
+	}
+	q.StopOrderProcessor()
+
+	if len(sizes) != 2 || sizes[0] != 3 || sizes[1] != 2 {
+		t.Errorf("batch sizes = %v, want [3 2]", sizes)
+	}
+	if got := metricRepo.count(constants.PROCESSING_TIME); got != 4 {
+		t.Errorf("processing time metrics = %v, want 4", got)
+	}
+	if metricRepo.batches != 2 {
+		t.Errorf("metric batches = %v, want 2", metricRepo.batches)
+	}
+}
+
+func TestQueue_BatchMetricsFailure(t *testing.T) {
+	tests := []struct {
+		name        string
+		failures    int
+		wantMetrics int
+		wantLost    int64
+	}{
+		{name: "stored on retry", failures: 1, wantMetrics: 2, wantLost: 0},
+		{name: "lost", failures: 2, wantMetrics: 0, wantLost: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metricRepo := &fakeMetricRepo{failures: tt.failures}
+			ledger := &fakeLedger{processed: map[string]bool{}}
+			q := NewBatchQueue("test", 1, 2, nil, BatchConfig{Size: 2, Wait: 50 * time.Millisecond}, func(items []Item) []error {
+				return make([]error, len(items))
+			}, metricRepo, ledger, nil, nil)
+			q.Enqueue(Item{Id: "a"})
+			q.Enqueue(Item{Id: "b"})
+			q.StartOrderProcessor()
+			time.Sleep(200 * time.Millisecond)
+			q.StopOrderProcessor()
+
+			if got := metricRepo.count(constants.PROCESSING_TIME); got != tt.wantMetrics {
+				t.Errorf("processing time metrics = %v, want %v", got, tt.wantMetrics)
+			}
+			if got := q.Stats().LostMetrics; got != tt.wantLost {
+				t.Errorf("LostMetrics = %v, want %v", got, tt.wantLost)
+			}
+			// The statuses went through, the items must not run again.
+			processed := ledger.claimed("test", "a", "b")
+			if !processed["a"] || !processed["b"] {
+				t.Errorf("ledger has %v, want both items", processed)
+			}
+		})
+	}
+}
+
+func TestQueue_Dedupe(t *testing.T) {
+	tests := []struct {
+		name      string
+		workers   int
+		items     []Item
+		wantCalls int
+	}{
+		{
+			name:      "redelivered item is skipped",
+			workers:   1,
+			items:     []Item{{Id: "a"}, {Id: "a"}, {Id: "b"}},
+			wantCalls: 2,
+		},
+		{
+			name:      "forced item is processed again",
+			workers:   1,
+			items:     []Item{{Id: "a"}, {Id: "a", Force: true}},
+			wantCalls: 2,
+		},
+		{
+			// The second delivery arrives while the first one still runs.
+			name:      "concurrent deliveries run once",
+			workers:   3,
+			items:     []Item{{Id: "a"}, {Id: "a"}, {Id: "a"}},
+			wantCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ledger := &fakeLedger{processed: map[string]bool{}}
+			metricRepo := &fakeMetricRepo{}
+			var mu sync.Mutex
+			calls := 0
+			q := NewQueue("test", tt.workers, len(tt.items), nil, func(item Item) error {
+				mu.Lock()
+				calls++
+				mu.Unlock()
+				time.Sleep(20 * time.Millisecond)
+				return nil
+			}, metricRepo, ledger, nil, nil)
+			for _, item := range tt.items {
+				q.Enqueue(item)
+			}
+			q.StartOrderProcessor()
+			time.Sleep(100 * time.Millisecond)
+			q.StopOrderProcessor()
+
+			if calls != tt.wantCalls {
+				t.Errorf("processed %v times, want %v", calls, tt.wantCalls)
+			}
+			if got := metricRepo.count(constants.PROCESSING_TIME); got != tt.wantCalls {
+				t.Errorf("recorded %v metrics, want %v", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestQueue_DedupeReleasesFailed(t *testing.T) {
+	ledger := &fakeLedger{processed: map[string]bool{}}
+	done := make(chan struct{}, 2)
+	q := NewBatchQueue("test", 1, 2, nil, BatchConfig{Size: 2, Wait: 50 * time.Millisecond}, func(items []Item) []error {
+		errs := make([]error, len(items))
+		for i, item := range items {
+			if item.Id == "bad" {
+				errs[i] = errors.New("failed")
+			}
+		}
+		done <- struct{}{}
+		return errs
+	}, &fakeMetricRepo{}, ledger, nil, nil)
+	q.Enqueue(Item{Id: "ok"})
+	q.Enqueue(Item{Id: "bad"})
+	q.StartOrderProcessor()
+	<-done
+	q.StopOrderProcessor()
+
+	processed := ledger.claimed("test", "ok", "bad")
+	if !processed["ok"] || processed["bad"] {
+		t.Errorf("ledger has %v, want only the item that went through", processed)
+	}
+}
+
+func TestQueue_DedupeReleasesFailedItem(t *testing.T) {
+	ledger := &fakeLedger{processed: map[string]bool{}}
+	metricRepo := &fakeMetricRepo{}
+	done := make(chan struct{}, 2)
+	q := NewQueue("test", 1, 2, nil, func(item Item) error {
+		defer func() { done <- struct{}{} }()
+		if item.Id == "bad" {
+			return errors.New("failed")
+		}
+		return nil
+	}, metricRepo, ledger, nil, nil)
+	q.Enqueue(Item{Id: "ok"})
+	q.Enqueue(Item{Id: "bad"})
+	q.StartOrderProcessor()
+	<-done
+	<-done
+	q.StopOrderProcessor()
+
+	processed := ledger.claimed("test", "ok", "bad")
+	if !processed["ok"] || processed["bad"] {
+		t.Errorf("ledger has %v, want only the item that went through", processed)
+	}
+	if got := metricRepo.count(constants.PROCESSING_TIME); got != 1 {
+		t.Errorf("processing time metrics = %v, want 1", got)
+	}
+}
+
+func TestQueue_PausesOnOpenBreaker(t *testing.T) {
+	b := breaker.New("test", 1, 200*time.Millisecond, 1, func(err error) bool { return err != nil })
+	b.Execute(func() error { return errors.New("down") })
+
+	done := make(chan struct{}, 1)
+	q := NewQueue("test", 2, 1, nil, func(item Item) error {
+		b.Execute(func() error { return nil })
+		done <- struct{}{}
+		return nil
+	}, &fakeMetricRepo{}, nil, nil, nil, b)
+	q.StartOrderProcessor()
+	defer q.StopOrderProcessor()
+	q.Enqueue(Item{Id: "a"})
+
+	select {
+	case <-done:
+		t.Fatal("item processed while breaker open")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if !q.Paused() {
+		t.Error("Paused() = false while breaker open")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("item not processed after breaker went half open")
+	}
+	if got := b.State(); got != breaker.CLOSED {
+		t.Errorf("breaker state = %v, want %v", got, breaker.CLOSED)
+	}
+}
+
+func TestQueue_PanicSupervision(t *testing.T) {
+	tests := []struct {
+		name        string
+		panicTimes  int
+		wantPanics  int64
+		wantFailed  int64
+		wantRecover bool
+	}{
+		{
+			name:        "retried until it works",
+			panicTimes:  1,
+			wantPanics:  1,
+			wantRecover: true,
+		},
+		{
+			name:       "failed after retries",
+			panicTimes: 100,
+			wantPanics: maxPanicRetries + 1,
+			wantFailed: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			panicked := 0
+			processed := make(chan string, 10)
+			q := NewQueue("test", 1, 10, nil, func(item Item) error {
+				mu.Lock()
+				if item.Id == "boom" && panicked < tt.panicTimes {
+					panicked++
+					mu.Unlock()
+					var order *models.Order
+					_ = order.Status // nil dereference
+				}
+				mu.Unlock()
+				processed <- item.Id
+				return nil
+			}, &fakeMetricRepo{}, nil, nil, nil)
+			q.StartOrderProcessor()
+			q.Enqueue(Item{Id: "boom"})
+			q.Enqueue(Item{Id: "ok"})
+
+			// The single worker must have been replaced to get to "ok".
+			got := map[string]bool{}
+			timeout := time.After(time.Second)
+			for !got["ok"] || (tt.wantRecover && !got["boom"]) {
+				select {
+				case id := <-processed:
+					got[id] = true
+				case <-timeout:
+					t.Fatalf("processed %v before timeout", got)
+				}
+			}
+			// Retries run after "ok", give them a moment.
+			deadline := time.Now().Add(time.Second)
+			for q.Stats().Panics < tt.wantPanics && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			q.StopOrderProcessor()
+
+			stats := q.Stats()
+			if stats.Panics != tt.wantPanics {
+				t.Errorf("Stats().Panics = %v, want %v", stats.Panics, tt.wantPanics)
+			}
+			if stats.FailedItems != tt.wantFailed {
+				t.Errorf("Stats().FailedItems = %v, want %v", stats.FailedItems, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestQueue_PanicRetryOnFullQueue(t *testing.T) {
+	ledger := &fakeLedger{processed: map[string]bool{}}
+	started := make(chan struct{})
+	panicNow := make(chan struct{})
+	processed := make(chan string, 1)
+	q := NewQueue("test", 1, 1, nil, func(item Item) error {
+		if item.Id == "boom" {
+			close(started)
+			<-panicNow
+			var order *models.Order
+			_ = order.Status // nil dereference
+		}
+		processed <- item.Id
+		return nil
+	}, &fakeMetricRepo{}, ledger, nil, nil)
+	q.StartOrderProcessor()
+	q.Enqueue(Item{Id: "boom"})
+	<-started
+	// Takes the only slot, the retry of "boom" finds the queue full.
+	q.Enqueue(Item{Id: "filler"})
+	close(panicNow)
+
+	select {
+	case <-processed:
+	case <-time.After(time.Second):
+		t.Fatal("worker not replaced after the panic")
+	}
+	q.StopOrderProcessor()
+
+	if got := q.Stats().FailedItems; got != 1 {
+		t.Errorf("Stats().FailedItems = %v, want 1", got)
+	}
+	if claimed := ledger.claimed("test", "boom", "filler"); claimed["boom"] || !claimed["filler"] {
+		t.Errorf("ledger has %v, want the dropped retry released", claimed)
+	}
+}
+
+func TestQueue_PanicWhileStopping(t *testing.T) {
+	started, panicNow := make(chan struct{}), make(chan struct{})
+	q := NewQueue("test", 1, 10, nil, func(item Item) error {
+		close(started)
+		<-panicNow
+		var order *models.Order
+		_ = order.Status // nil dereference
+		return nil
+	}, &fakeMetricRepo{}, nil, nil, nil)
+	q.StartOrderProcessor()
+	q.Enqueue(Item{Id: "boom"})
+	<-started
+
+	// The worker panics once StopOrderProcessor closed the queue.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(panicNow)
+	}()
+	q.StopOrderProcessor()
+	q.Enqueue(Item{Id: "late"})
+
+	if stats := q.Stats(); stats.Panics != 1 || stats.FailedItems != 1 {
+		t.Errorf("Stats() = %+v, want 1 panic and the item failed", stats)
+	}
+}
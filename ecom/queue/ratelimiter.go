@@ -0,0 +1,55 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter is a token bucket shared by all workers of a queue.
+// A rate of zero or less means unlimited.
+type RateLimiter struct {
+	limiter *rate.Limiter
+}
+
+func NewRateLimiter(ratePerSec float64, burst int) *RateLimiter {
+	limit, burst := toLimit(ratePerSec, burst)
+	return &RateLimiter{limiter: rate.NewLimiter(limit, burst)}
+}
+
+// Wait blocks until a token is available and returns how long it waited.
+func (l *RateLimiter) Wait(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	err := l.limiter.Wait(ctx)
+	return time.Since(start), err
+}
+
+// SetLimit changes rate and burst, workers pick it up on their next Wait.
+func (l *RateLimiter) SetLimit(ratePerSec float64, burst int) {
+	limit, burst := toLimit(ratePerSec, burst)
+	l.limiter.SetLimit(limit)
+	l.limiter.SetBurst(burst)
+}
+
+func (l *RateLimiter) Limit() (float64, int) {
+	limit := l.limiter.Limit()
+	if limit == rate.Inf {
+		return 0, l.limiter.Burst()
+	}
+	return float64(limit), l.limiter.Burst()
+}
+
+func (l *RateLimiter) Enabled() bool {
+	return l.limiter.Limit() != rate.Inf
+}
+
+func toLimit(ratePerSec float64, burst int) (rate.Limit, int) {
+	if ratePerSec <= 0 {
+		return rate.Inf, burst
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.Limit(ratePerSec), burst
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"ecom.com/models"
+)
+
+// ArchiveRepositoryI moves finished orders with their items out of the
+// orders and items tables and back.
+type ArchiveRepositoryI interface {
+	// ArchiveOrders selects up to limit Completed orders created before
+	// before, oldest first, moves them and returns the ids it moved and how
+	// many it selected. Orders that changed since they were selected are
+	// skipped. A restored order is only moved again once it was also
+	// restored before before.
+	ArchiveOrders(ctx context.Context, before time.Time, limit int) ([]string, int, error)
+	// GetArchivedOrder returns sql.ErrNoRows if the order is not archived.
+	GetArchivedOrder(ctx context.Context, id string) (*models.Order, []models.Item, error)
+	// RestoreOrder moves an archived order back and marks it restored,
+	// sql.ErrNoRows if it is not archived.
+	RestoreOrder(ctx context.Context, id string) error
+}
+
+// moveQueries move one order, by id, between the live and the archive
+// tables. copyOrder must insert nothing if the order is not there, or no
+// longer qualifies, e.g. it was reprocessed since it was selected. It takes
+// the copyArgs of moveOrders after the id.
+type moveQueries struct {
+	copyOrder   string
+	copyItems   string
+	deleteItems string
+	deleteOrder string
+}
+
+// moveOrders moves every order in its own savepoint, an order that is gone
+// by then fails with sql.ErrNoRows.
+func moveOrders(ctx context.Context, db DBTX, ids []string, q moveQueries, copyArgs ...any) []error {
+	return runBatch(ctx, db, len(ids), func(tx *sql.Tx, i int) error {
+		res, err := tx.ExecContext(ctx, q.copyOrder, append([]any{ids[i]}, copyArgs...)...)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return sql.ErrNoRows
+		}
+		for _, query := range []string{q.copyItems, q.deleteItems, q.deleteOrder} {
+			if _, err := tx.ExecContext(ctx, query, ids[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// archiveMoved returns the ids that were moved and the first error other
+// than an order that was gone.
+func archiveMoved(ids []string, errs []error) ([]string, error) {
+	moved := []string{}
+	var firstErr error
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			moved = append(moved, ids[i])
+		case err != sql.ErrNoRows && firstErr == nil:
+			firstErr = err
+		}
+	}
+	return moved, firstErr
+}
+
+// scanArchivedOrder reads an archived order and its items with the given
+// queries, both take the order id as their only argument.
+func scanArchivedOrder(ctx context.Context, db DBTX, orderQuery string, itemsQuery string, id string) (*models.Order, []models.Item, error) {
+	var order models.Order
+	err := db.QueryRowContext(ctx, orderQuery, id).Scan(&order.OrderID, &order.UserID, &order.TotalAmount, &order.Status)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := db.QueryContext(ctx, itemsQuery, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var items []models.Item
+	for rows.Next() {
+		var item models.Item
+		if err := rows.Scan(&item.ItemID, &item.OrderID, &item.Amount); err != nil {
+			return nil, nil, err
+		}
+		items = append(items, item)
+	}
+	return &order, items, rows.Err()
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"ecom.com/constants"
+	"ecom.com/errors"
+	"ecom.com/models"
+)
+
+type MemoryArchiveRepository struct {
+	store *MemoryStore
+}
+
+func NewMemoryArchiveRepository(store *MemoryStore) ArchiveRepositoryI {
+	return &MemoryArchiveRepository{store: store}
+}
+
+func (r *MemoryArchiveRepository) ArchiveOrders(ctx context.Context, before time.Time, limit int) ([]string, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	orders := []models.Order{}
+	for _, order := range r.store.orders {
+		restoredAt, restored := r.store.restored[order.OrderID]
+		if order.Status == string(constants.COMPELETED) && order.CreatedAt.Before(before) && (!restored || restoredAt.Before(before)) {
+			orders = append(orders, order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if !orders[i].CreatedAt.Equal(orders[j].CreatedAt) {
+			return orders[i].CreatedAt.Before(orders[j].CreatedAt)
+		}
+		return orders[i].OrderID < orders[j].OrderID
+	})
+	if len(orders) > limit {
+		orders = orders[:limit]
+	}
+	ids := []string{}
+	for _, order := range orders {
+		r.store.archivedOrders[order.OrderID] = order
+		if items, ok := r.store.items[order.OrderID]; ok {
+			r.store.archivedItems[order.OrderID] = items
+		}
+		delete(r.store.orders, order.OrderID)
+		delete(r.store.items, order.OrderID)
+		ids = append(ids, order.OrderID)
+	}
+	return ids, len(ids), nil
+}
+
+// GetArchivedOrder leaves CreatedAt empty like the SQL repositories.
+func (r *MemoryArchiveRepository) GetArchivedOrder(ctx context.Context, id string) (*models.Order, []models.Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+	order, ok := r.store.archivedOrders[id]
+	if !ok {
+		return nil, nil, sql.ErrNoRows
+	}
+	order.CreatedAt = time.Time{}
+	var items []models.Item
+	return &order, append(items, r.store.archivedItems[id]...), nil
+}
+
+func (r *MemoryArchiveRepository) RestoreOrder(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	order, ok := r.store.archivedOrders[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if _, ok := r.store.orders[id]; ok {
+		return fmt.Errorf("order %v: %w", id, errors.ErrDuplicateKey)
+	}
+	r.store.orders[id] = order
+	if items, ok := r.store.archivedItems[id]; ok {
+		r.store.items[id] = items
+	}
+	delete(r.store.archivedOrders, id)
+	delete(r.store.archivedItems, id)
+	r.store.restored[id] = time.Now()
+	return nil
+}
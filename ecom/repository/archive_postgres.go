@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"ecom.com/constants"
+	"ecom.com/models"
+)
+
+type PostgreSqlArchiveRepository struct {
+	DB DBTX
+}
+
+func NewPostgreSqlArchiveRepository(db DBTX) ArchiveRepositoryI {
+	return &PostgreSqlArchiveRepository{DB: db}
+}
+
+var postgresArchiveQueries = moveQueries{
+	copyOrder: `INSERT INTO orders_archive (order_id, user_id, total_amount, status, created_at, archived_at)
+		SELECT order_id, user_id, total_amount, status, created_at, now() FROM orders
+		WHERE order_id = $1 AND status = 'Completed' AND (restored_at IS NULL OR restored_at < $2)`,
+	copyItems:   `INSERT INTO items_archive (item_id, amount, order_id) SELECT item_id, amount, order_id FROM items WHERE order_id = $1`,
+	deleteItems: `DELETE FROM items WHERE order_id = $1`,
+	deleteOrder: `DELETE FROM orders WHERE order_id = $1`,
+}
+
+var postgresRestoreQueries = moveQueries{
+	copyOrder: `INSERT INTO orders (order_id, user_id, total_amount, status, created_at, restored_at)
+		SELECT order_id, user_id, total_amount, status, created_at, now() FROM orders_archive WHERE order_id = $1`,
+	copyItems:   `INSERT INTO items (item_id, amount, order_id) SELECT item_id, amount, order_id FROM items_archive WHERE order_id = $1`,
+	deleteItems: `DELETE FROM items_archive WHERE order_id = $1`,
+	deleteOrder: `DELETE FROM orders_archive WHERE order_id = $1`,
+}
+
+func (r *PostgreSqlArchiveRepository) ArchiveOrders(ctx context.Context, before time.Time, limit int) ([]string, int, error) {
+	query := `SELECT order_id FROM orders WHERE status = $1 AND created_at IS NOT NULL AND created_at < $2
+		AND (restored_at IS NULL OR restored_at < $2) ORDER BY created_at, order_id LIMIT $3`
+	rows, err := r.DB.QueryContext(ctx, query, string(constants.COMPELETED), before, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	moved, err := archiveMoved(ids, moveOrders(ctx, r.DB, ids, postgresArchiveQueries, before))
+	return moved, len(ids), err
+}
+
+func (r *PostgreSqlArchiveRepository) GetArchivedOrder(ctx context.Context, id string) (*models.Order, []models.Item, error) {
+	return scanArchivedOrder(ctx, r.DB,
+		`SELECT order_id, user_id, total_amount, status FROM orders_archive WHERE order_id = $1`,
+		`SELECT item_id, order_id, amount FROM items_archive WHERE order_id = $1`, id)
+}
+
+func (r *PostgreSqlArchiveRepository) RestoreOrder(ctx context.Context, id string) error {
+	return moveOrders(ctx, r.DB, []string{id}, postgresRestoreQueries)[0]
+}
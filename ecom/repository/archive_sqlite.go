@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"ecom.com/constants"
+	"ecom.com/models"
+)
+
+type SQLiteArchiveRepository struct {
+	DB DBTX
+}
+
+func NewSQLiteArchiveRepository(db DBTX) ArchiveRepositoryI {
+	return &SQLiteArchiveRepository{DB: db}
+}
+
+var sqliteArchiveQueries = moveQueries{
+	copyOrder: `INSERT INTO orders_archive (order_id, user_id, total_amount, status, created_at, archived_at)
+		SELECT order_id, user_id, total_amount, status, created_at, CURRENT_TIMESTAMP FROM orders
+		WHERE order_id = ? AND status = 'Completed' AND (restored_at IS NULL OR restored_at < ?)`,
+	copyItems:   `INSERT INTO items_archive (item_id, amount, order_id) SELECT item_id, amount, order_id FROM items WHERE order_id = ?`,
+	deleteItems: `DELETE FROM items WHERE order_id = ?`,
+	deleteOrder: `DELETE FROM orders WHERE order_id = ?`,
+}
+
+var sqliteRestoreQueries = moveQueries{
+	copyOrder: `INSERT INTO orders (order_id, user_id, total_amount, status, created_at, restored_at)
+		SELECT order_id, user_id, total_amount, status, created_at, CURRENT_TIMESTAMP FROM orders_archive WHERE order_id = ?`,
+	copyItems:   `INSERT INTO items (item_id, amount, order_id) SELECT item_id, amount, order_id FROM items_archive WHERE order_id = ?`,
+	deleteItems: `DELETE FROM items_archive WHERE order_id = ?`,
+	deleteOrder: `DELETE FROM orders_archive WHERE order_id = ?`,
+}
+
+func (r *SQLiteArchiveRepository) ArchiveOrders(ctx context.Context, before time.Time, limit int) ([]string, int, error) {
+	query := `SELECT order_id FROM orders WHERE status = ? AND created_at IS NOT NULL AND created_at < ?
+		AND (restored_at IS NULL OR restored_at < ?) ORDER BY created_at, order_id LIMIT ?`
+	cutoff := before.UTC().Format(sqliteTimeFormat)
+	rows, err := r.DB.QueryContext(ctx, query, string(constants.COMPELETED), cutoff, cutoff, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	moved, err := archiveMoved(ids, moveOrders(ctx, r.DB, ids, sqliteArchiveQueries, cutoff))
+	return moved, len(ids), err
+}
+
+func (r *SQLiteArchiveRepository) GetArchivedOrder(ctx context.Context, id string) (*models.Order, []models.Item, error) {
+	return scanArchivedOrder(ctx, r.DB,
+		`SELECT order_id, user_id, total_amount, status FROM orders_archive WHERE order_id = ?`,
+		`SELECT item_id, order_id, amount FROM items_archive WHERE order_id = ?`, id)
+}
+
+func (r *SQLiteArchiveRepository) RestoreOrder(ctx context.Context, id string) error {
+	return moveOrders(ctx, r.DB, []string{id}, sqliteRestoreQueries)[0]
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// execBatch runs query once per args row inside a single transaction. If
+// requireRow is set a statement that touches no rows is reported as
+// sql.ErrNoRows.
+func execBatch(ctx context.Context, db DBTX, query string, args [][]any, requireRow bool) []error {
+	var stmt *sql.Stmt
+	return runBatch(ctx, db, len(args), func(tx *sql.Tx, i int) error {
+		if stmt == nil {
+			var err error
+			if stmt, err = tx.PrepareContext(ctx, query); err != nil {
+				return err
+			}
+		}
+		res, err := stmt.ExecContext(ctx, args[i]...)
+		if err == nil && requireRow {
+			if n, rowsErr := res.RowsAffected(); rowsErr == nil && n == 0 {
+				err = sql.ErrNoRows
+			}
+		}
+		return err
+	})
+}
+
+// runBatch calls fn for n rows inside a single transaction. Every row gets
+// its own savepoint so one failing row does not abort the others, the
+// returned slice holds the result for each row. On a *sql.Tx the rows join
+// that transaction and committing is left to its owner.
+func runBatch(ctx context.Context, db DBTX, n int, fn func(tx *sql.Tx, i int) error) []error {
+	errs := make([]error, n)
+	if n == 0 {
+		return errs
+	}
+	tx, inTx := db.(*sql.Tx)
+	if !inTx {
+		beginner, ok := db.(txBeginner)
+		if !ok {
+			return fillErrors(errs, fmt.Errorf("cannot begin a transaction on %T", db))
+		}
+		var err error
+		if tx, err = beginner.BeginTx(ctx, nil); err != nil {
+			return fillErrors(errs, err)
+		}
+	}
+	rollback := func() {
+		if !inTx {
+			tx.Rollback()
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		savepoint := fmt.Sprintf("batch_%d", i)
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT "+savepoint); err != nil {
+			rollback()
+			return fillErrors(errs, err)
+		}
+		if err := fn(tx, i); err != nil {
+			errs[i] = err
+			if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+savepoint); err != nil {
+				rollback()
+				return fillErrors(errs, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+savepoint); err != nil {
+			rollback()
+			return fillErrors(errs, err)
+		}
+	}
+
+	if !inTx {
+		if err := tx.Commit(); err != nil {
+			return fillErrors(errs, err)
+		}
+	}
+	return errs
+}
+
+// fillErrors marks every row that has not failed on its own with err.
+func fillErrors(errs []error, err error) []error {
+	for i := range errs {
+		if errs[i] == nil {
+			errs[i] = err
+		}
+	}
+	return errs
+}
@@ -0,0 +1,233 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	stderrors "errors"
+	"time"
+
+	"ecom.com/breaker"
+	"ecom.com/errors"
+	"ecom.com/models"
+)
+
+// IsFailure reports whether err means the database is in trouble, as opposed
+// to an expected result like a missing row or a caller that gave up.
+func IsFailure(err error) bool {
+	return err != nil && err != sql.ErrNoRows && err != errors.ErrAlreadyProcessed && !stderrors.Is(err, context.Canceled)
+}
+
+type BreakerOrderRepository struct {
+	repo    OrderRepositoryI
+	breaker *breaker.CircuitBreaker
+}
+
+func NewBreakerOrderRepository(repo OrderRepositoryI, b *breaker.CircuitBreaker) OrderRepositoryI {
+	return &BreakerOrderRepository{repo: repo, breaker: b}
+}
+
+func (r *BreakerOrderRepository) CreateOrder(ctx context.Context, order *models.Order) error {
+	return r.breaker.Execute(func() error {
+		return r.repo.CreateOrder(ctx, order)
+	})
+}
+
+func (r *BreakerOrderRepository) UpdateOrderStatus(ctx context.Context, orderId string, status string) error {
+	return r.breaker.Execute(func() error {
+		return r.repo.UpdateOrderStatus(ctx, orderId, status)
+	})
+}
+
+func (r *BreakerOrderRepository) UpdateOrderStatusBatch(ctx context.Context, orderIds []string, status string) []error {
+	errs := make([]error, len(orderIds))
+	err := r.breaker.Execute(func() error {
+		errs = r.repo.UpdateOrderStatusBatch(ctx, orderIds, status)
+		return breaker.BatchError(errs)
+	})
+	if err == errors.ErrCircuitOpen {
+		return fillErrors(errs, err)
+	}
+	return errs
+}
+
+func (r *BreakerOrderRepository) GetOrderByID(ctx context.Context, id string) (*models.Order, error) {
+	var order *models.Order
+	err := r.breaker.Execute(func() error {
+		var err error
+		order, err = r.repo.GetOrderByID(ctx, id)
+		return err
+	})
+	return order, err
+}
+
+func (r *BreakerOrderRepository) ListActiveOrders(ctx context.Context, since time.Time, after *models.Order, limit int) ([]*models.Order, error) {
+	var orders []*models.Order
+	err := r.breaker.Execute(func() error {
+		var err error
+		orders, err = r.repo.ListActiveOrders(ctx, since, after, limit)
+		return err
+	})
+	return orders, err
+}
+
+type BreakerItemRepository struct {
+	repo    ItemRepositoryI
+	breaker *breaker.CircuitBreaker
+}
+
+func NewBreakerItemRepository(repo ItemRepositoryI, b *breaker.CircuitBreaker) ItemRepositoryI {
+	return &BreakerItemRepository{repo: repo, breaker: b}
+}
+
+func (r *BreakerItemRepository) CreateItem(ctx context.Context, item *models.Item) error {
+	return r.breaker.Execute(func() error {
+		return r.repo.CreateItem(ctx, item)
+	})
+}
+
+func (r *BreakerItemRepository) GetItem(ctx context.Context, id string) (*models.Item, error) {
+	var item *models.Item
+	err := r.breaker.Execute(func() error {
+		var err error
+		item, err = r.repo.GetItem(ctx, id)
+		return err
+	})
+	return item, err
+}
+
+func (r *BreakerItemRepository) GetItemsByOrderId(ctx context.Context, id string) ([]models.Item, error) {
+	var items []models.Item
+	err := r.breaker.Execute(func() error {
+		var err error
+		items, err = r.repo.GetItemsByOrderId(ctx, id)
+		return err
+	})
+	return items, err
+}
+
+func (r *BreakerItemRepository) RemoveItem(ctx context.Context, itemId string, orderId string) error {
+	return r.breaker.Execute(func() error {
+		return r.repo.RemoveItem(ctx, itemId, orderId)
+	})
+}
+
+type BreakerMetricRepository struct {
+	repo    MetricRepositoryI
+	breaker *breaker.CircuitBreaker
+}
+
+func NewBreakerMetricRepository(repo MetricRepositoryI, b *breaker.CircuitBreaker) MetricRepositoryI {
+	return &BreakerMetricRepository{repo: repo, breaker: b}
+}
+
+func (r *BreakerMetricRepository) CreateMetric(ctx context.Context, metric *models.Metric) error {
+	return r.breaker.Execute(func() error {
+		return r.repo.CreateMetric(ctx, metric)
+	})
+}
+
+func (r *BreakerMetricRepository) CreateMetricBatch(ctx context.Context, metrics []*models.Metric) []error {
+	errs := make([]error, len(metrics))
+	err := r.breaker.Execute(func() error {
+		errs = r.repo.CreateMetricBatch(ctx, metrics)
+		return breaker.BatchError(errs)
+	})
+	if err == errors.ErrCircuitOpen {
+		return fillErrors(errs, err)
+	}
+	return errs
+}
+
+func (r *BreakerMetricRepository) GetMetricByID(ctx context.Context, id int, name string) (*models.Metric, error) {
+	var metric *models.Metric
+	err := r.breaker.Execute(func() error {
+		var err error
+		metric, err = r.repo.GetMetricByID(ctx, id, name)
+		return err
+	})
+	return metric, err
+}
+
+func (r *BreakerMetricRepository) GetMetricCount(ctx context.Context, metricName string) (*int, error) {
+	var count *int
+	err := r.breaker.Execute(func() error {
+		var err error
+		count, err = r.repo.GetMetricCount(ctx, metricName)
+		return err
+	})
+	return count, err
+}
+
+func (r *BreakerMetricRepository) GetAverageTime(ctx context.Context, metricName string) (*float64, error) {
+	var avg *float64
+	err := r.breaker.Execute(func() error {
+		var err error
+		avg, err = r.repo.GetAverageTime(ctx, metricName)
+		return err
+	})
+	return avg, err
+}
+
+type BreakerLedgerRepository struct {
+	repo    LedgerRepositoryI
+	breaker *breaker.CircuitBreaker
+}
+
+func NewBreakerLedgerRepository(repo LedgerRepositoryI, b *breaker.CircuitBreaker) LedgerRepositoryI {
+	return &BreakerLedgerRepository{repo: repo, breaker: b}
+}
+
+func (r *BreakerLedgerRepository) MarkProcessed(ctx context.Context, items []*models.ProcessedItem) []error {
+	errs := make([]error, len(items))
+	err := r.breaker.Execute(func() error {
+		errs = r.repo.MarkProcessed(ctx, items)
+		return breaker.BatchError(errs)
+	})
+	if err == errors.ErrCircuitOpen {
+		return fillErrors(errs, err)
+	}
+	return errs
+}
+
+func (r *BreakerLedgerRepository) UnmarkProcessed(ctx context.Context, stage string, itemIds []string) error {
+	return r.breaker.Execute(func() error {
+		return r.repo.UnmarkProcessed(ctx, stage, itemIds)
+	})
+}
+
+type BreakerArchiveRepository struct {
+	repo    ArchiveRepositoryI
+	breaker *breaker.CircuitBreaker
+}
+
+func NewBreakerArchiveRepository(repo ArchiveRepositoryI, b *breaker.CircuitBreaker) ArchiveRepositoryI {
+	return &BreakerArchiveRepository{repo: repo, breaker: b}
+}
+
+func (r *BreakerArchiveRepository) ArchiveOrders(ctx context.Context, before time.Time, limit int) ([]string, int, error) {
+	var ids []string
+	var selected int
+	err := r.breaker.Execute(func() error {
+		var err error
+		ids, selected, err = r.repo.ArchiveOrders(ctx, before, limit)
+		return err
+	})
+	return ids, selected, err
+}
+
+func (r *BreakerArchiveRepository) GetArchivedOrder(ctx context.Context, id string) (*models.Order, []models.Item, error) {
+	var order *models.Order
+	var items []models.Item
+	err := r.breaker.Execute(func() error {
+		var err error
+		order, items, err = r.repo.GetArchivedOrder(ctx, id)
+		return err
+	})
+	return order, items, err
+}
+
+func (r *BreakerArchiveRepository) RestoreOrder(ctx context.Context, id string) error {
+	return r.breaker.Execute(func() error {
+		return r.repo.RestoreOrder(ctx, id)
+	})
+}
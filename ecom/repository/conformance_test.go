@@ -0,0 +1,713 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	stderrors "errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"ecom.com/constants"
+	"ecom.com/database"
+	"ecom.com/errors"
+	"ecom.com/models"
+	"github.com/google/uuid"
+)
+
+// conformanceRepos are the repositories of one backend, all on the same
+// storage.
+type conformanceRepos struct {
+	orders     OrderRepositoryI
+	items      ItemRepositoryI
+	metrics    MetricRepositoryI
+	ledger     LedgerRepositoryI
+	archive    ArchiveRepositoryI
+	unitOfWork UnitOfWorkI
+}
+
+// TestRepositoryConformance runs the same checks against every backend so
+// they behave the same. A new backend only needs an entry here.
+func TestRepositoryConformance(t *testing.T) {
+	backends := []struct {
+		name string
+		new  func(t *testing.T) conformanceRepos
+	}{
+		{
+			name: "sqlite",
+			new: func(t *testing.T) conformanceRepos {
+				dir := t.TempDir()
+				db := database.ConnectDB(string(constants.SQLITE_DRIVER), filepath.Join(dir, "testDb.db"))
+				metricDb := database.ConnectMetricsDB(string(constants.SQLITE_DRIVER), filepath.Join(dir, "testMetricsDb.db"))
+				t.Cleanup(func() {
+					db.Close()
+					metricDb.Close()
+				})
+				return newSQLConformanceRepos(t, string(constants.SQLITE_DRIVER), db, metricDb)
+			},
+		},
+		{
+			name: "sqlite with a single writer",
+			new: func(t *testing.T) conformanceRepos {
+				driver, dir := string(constants.SQLITE_DRIVER), t.TempDir()
+				opts := database.SQLiteOptions{WAL: true, BusyTimeout: 5 * time.Second, SingleWriter: true, ReadConns: 4}
+				db := database.ConnectPool(driver, filepath.Join(dir, "testDb.db"), constants.ORDERS_SCHEMA, true, opts)
+				metricDb := database.ConnectPool(driver, filepath.Join(dir, "testMetricsDb.db"), constants.METRICS_SCHEMA, true, opts)
+				t.Cleanup(func() {
+					db.Close()
+					metricDb.Close()
+				})
+				return newSQLConformanceRepos(t, driver, db, metricDb)
+			},
+		},
+		{
+			name: "postgres",
+			new: func(t *testing.T) conformanceRepos {
+				db := newTestPostgres(t)
+				return newSQLConformanceRepos(t, string(constants.POSTGRES_DRIVER), db, db)
+			},
+		},
+		{
+			name: "sqlite shards",
+			new: func(t *testing.T) conformanceRepos {
+				driver, dir := string(constants.SQLITE_DRIVER), t.TempDir()
+				names, dbs := []string{"a", "b", "c"}, []DBTX{}
+				for _, name := range names {
+					db := database.ConnectDB(driver, filepath.Join(dir, name+".db"))
+					t.Cleanup(func() { db.Close() })
+					dbs = append(dbs, db)
+				}
+				metricDb := database.ConnectMetricsDB(driver, filepath.Join(dir, "testMetricsDb.db"))
+				t.Cleanup(func() { metricDb.Close() })
+				return newShardedConformanceRepos(t, driver, names, dbs, metricDb)
+			},
+		},
+		{
+			name: "memory",
+			new: func(t *testing.T) conformanceRepos {
+				store := NewMemoryStore()
+				return conformanceRepos{
+					orders:     NewMemoryOrderRepository(store),
+					items:      NewMemoryItemRepository(store),
+					metrics:    NewMemoryMetricRepository(store),
+					ledger:     NewMemoryLedgerRepository(store),
+					archive:    NewMemoryArchiveRepository(store),
+					unitOfWork: NewMemoryUnitOfWork(store),
+				}
+			},
+		},
+	}
+	checks := []struct {
+		name string
+		run  func(t *testing.T, repos conformanceRepos)
+	}{
+		{name: "orders", run: testOrdersConformance},
+		{name: "status transitions", run: testStatusTransitionsConformance},
+		{name: "active orders", run: testActiveOrdersConformance},
+		{name: "items", run: testItemsConformance},
+		{name: "metrics", run: testMetricsConformance},
+		{name: "ledger", run: testLedgerConformance},
+		{name: "archive", run: testArchiveConformance},
+		{name: "unit of work", run: func(t *testing.T, repos conformanceRepos) {
+			testUnitOfWork(t, repos.unitOfWork, repos.orders, repos.items)
+		}},
+		{name: "concurrent writes", run: testConcurrentWritesConformance},
+		{name: "canceled context", run: testCanceledConformance},
+	}
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			for _, check := range checks {
+				t.Run(check.name, func(t *testing.T) {
+					check.run(t, backend.new(t))
+				})
+			}
+		})
+	}
+}
+
+func newSQLConformanceRepos(t *testing.T, driver string, db DBTX, metricDb DBTX) conformanceRepos {
+	t.Helper()
+	orders, err := NewOrderRepository(driver, db)
+	if err != nil {
+		t.Fatalf("NewOrderRepository() error = %v", err)
+	}
+	items, err := NewItemRepository(driver, db)
+	if err != nil {
+		t.Fatalf("NewItemRepository() error = %v", err)
+	}
+	metrics, err := NewMetricRepository(driver, metricDb)
+	if err != nil {
+		t.Fatalf("NewMetricRepository() error = %v", err)
+	}
+	ledger, err := NewLedgerRepository(driver, metricDb)
+	if err != nil {
+		t.Fatalf("NewLedgerRepository() error = %v", err)
+	}
+	archive, err := NewArchiveRepository(driver, db)
+	if err != nil {
+		t.Fatalf("NewArchiveRepository() error = %v", err)
+	}
+	uow, err := NewUnitOfWork(driver, db)
+	if err != nil {
+		t.Fatalf("NewUnitOfWork() error = %v", err)
+	}
+	return conformanceRepos{orders: orders, items: items, metrics: metrics, ledger: ledger, archive: archive, unitOfWork: uow}
+}
+
+func newShardedConformanceRepos(t *testing.T, driver string, names []string, dbs []DBTX, metricDb DBTX) conformanceRepos {
+	t.Helper()
+	repos := newSQLConformanceRepos(t, driver, dbs[0], metricDb)
+	router, err := NewShardRouter(driver, names, dbs)
+	if err != nil {
+		t.Fatalf("NewShardRouter() error = %v", err)
+	}
+	if repos.archive, err = NewShardedArchiveRepository(router); err != nil {
+		t.Fatalf("NewShardedArchiveRepository() error = %v", err)
+	}
+	if repos.unitOfWork, err = NewShardedUnitOfWork(router); err != nil {
+		t.Fatalf("NewShardedUnitOfWork() error = %v", err)
+	}
+	repos.orders, repos.items = router, router
+	return repos
+}
+
+func newTestOrder(status constants.OrderStates) *models.Order {
+	return &models.Order{OrderID: uuid.NewString(), UserID: "testUser", TotalAmount: 76.5, Status: string(status)}
+}
+
+func testOrdersConformance(t *testing.T, repos conformanceRepos) {
+	ctx := context.Background()
+	order := newTestOrder(constants.PENDING)
+	if err := repos.orders.CreateOrder(ctx, order); err != nil {
+		t.Fatalf("CreateOrder() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		run     func() error
+		wantErr bool
+		want    error // checked when set
+	}{
+		{
+			name: "duplicate order",
+			run: func() error {
+				return repos.orders.CreateOrder(ctx, &models.Order{OrderID: order.OrderID, UserID: "other", Status: "Pending"})
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown status",
+			run: func() error {
+				return repos.orders.CreateOrder(ctx, &models.Order{OrderID: uuid.NewString(), UserID: "testUser", Status: "Shipped"})
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing order",
+			run: func() error {
+				_, err := repos.orders.GetOrderByID(ctx, uuid.NewString())
+				return err
+			},
+			wantErr: true,
+			want:    sql.ErrNoRows,
+		},
+		{
+			name: "update of a missing order is not an error",
+			run: func() error {
+				return repos.orders.UpdateOrderStatus(ctx, uuid.NewString(), string(constants.COMPELETED))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.run()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want != nil && err != tt.want {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// Read back as written, CreatedAt is only filled by ListActiveOrders.
+	got, err := repos.orders.GetOrderByID(ctx, order.OrderID)
+	if err != nil || !reflect.DeepEqual(got, order) {
+		t.Errorf("GetOrderByID() = %v, %v, want %v", got, err, order)
+	}
+}
+
+func testStatusTransitionsConformance(t *testing.T, repos conformanceRepos) {
+	ctx := context.Background()
+	order, other := newTestOrder(constants.PENDING), newTestOrder(constants.PENDING)
+	for _, o := range []*models.Order{order, other} {
+		if err := repos.orders.CreateOrder(ctx, o); err != nil {
+			t.Fatalf("CreateOrder() error = %v", err)
+		}
+	}
+	missing := uuid.NewString()
+
+	tests := []struct {
+		name       string
+		update     func() []error
+		wantErrs   []error // nil entries succeed, errInvalidStatus is any error
+		wantStatus constants.OrderStates
+	}{
+		{
+			name: "pending to processing",
+			update: func() []error {
+				return []error{repos.orders.UpdateOrderStatus(ctx, order.OrderID, string(constants.PROCESSING))}
+			},
+			wantErrs:   []error{nil},
+			wantStatus: constants.PROCESSING,
+		},
+		{
+			name: "unknown status keeps the old one",
+			update: func() []error {
+				return []error{repos.orders.UpdateOrderStatus(ctx, order.OrderID, "Shipped")}
+			},
+			wantErrs:   []error{errInvalidStatus},
+			wantStatus: constants.PROCESSING,
+		},
+		{
+			name: "batch completes existing orders and reports missing ones",
+			update: func() []error {
+				return repos.orders.UpdateOrderStatusBatch(ctx, []string{order.OrderID, missing, other.OrderID}, string(constants.COMPELETED))
+			},
+			wantErrs:   []error{nil, sql.ErrNoRows, nil},
+			wantStatus: constants.COMPELETED,
+		},
+		{
+			name: "batch with an unknown status changes nothing",
+			update: func() []error {
+				return repos.orders.UpdateOrderStatusBatch(ctx, []string{order.OrderID}, "Shipped")
+			},
+			wantErrs:   []error{errInvalidStatus},
+			wantStatus: constants.COMPELETED,
+		},
+		{
+			name: "empty batch",
+			update: func() []error {
+				return repos.orders.UpdateOrderStatusBatch(ctx, nil, string(constants.PENDING))
+			},
+			wantErrs:   []error{},
+			wantStatus: constants.COMPELETED,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := tt.update()
+			if len(errs) != len(tt.wantErrs) {
+				t.Fatalf("update errors = %v, want %v", errs, tt.wantErrs)
+			}
+			for i, err := range errs {
+				if want := tt.wantErrs[i]; (want == errInvalidStatus && err == nil) || (want != errInvalidStatus && err != want) {
+					t.Errorf("update errors[%v] = %v, want %v", i, err, want)
+				}
+			}
+			if got, err := repos.orders.GetOrderByID(ctx, order.OrderID); err != nil || got.Status != string(tt.wantStatus) {
+				t.Errorf("GetOrderByID() = %v, %v, want status %v", got, err, tt.wantStatus)
+			}
+		})
+	}
+	if got, err := repos.orders.GetOrderByID(ctx, other.OrderID); err != nil || got.Status != string(constants.COMPELETED) {
+		t.Errorf("GetOrderByID(other) = %v, %v, want status %v", got, err, constants.COMPELETED)
+	}
+}
+
+// errInvalidStatus stands for whatever error a backend returns for a status
+// the orders table does not allow.
+var errInvalidStatus = stderrors.New("invalid status")
+
+// testActiveOrdersConformance only uses orders created just now, backends
+// store created_at with different precision.
+func testActiveOrdersConformance(t *testing.T, repos conformanceRepos) {
+	ctx := context.Background()
+	var pending, completed []string
+	for i := 0; i < 3; i++ {
+		for _, status := range []constants.OrderStates{constants.PENDING, constants.COMPELETED} {
+			order := newTestOrder(status)
+			if err := repos.orders.CreateOrder(ctx, order); err != nil {
+				t.Fatalf("CreateOrder() error = %v", err)
+			}
+			if status == constants.PENDING {
+				pending = append(pending, order.OrderID)
+			} else {
+				completed = append(completed, order.OrderID)
+			}
+		}
+	}
+
+	tests := []struct {
+		name  string
+		since time.Time
+		limit int
+		want  []string
+	}{
+		{name: "recent", since: time.Now().Add(-time.Hour), limit: 10, want: append(append([]string{}, pending...), completed...)},
+		{name: "recent, paged", since: time.Now().Add(-time.Hour), limit: 1, want: append(append([]string{}, pending...), completed...)},
+		{name: "in flight only", since: time.Now().Add(time.Hour), limit: 2, want: pending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			var after *models.Order
+			for {
+				page, err := repos.orders.ListActiveOrders(ctx, tt.since, after, tt.limit)
+				if err != nil {
+					t.Fatalf("ListActiveOrders() error = %v", err)
+				}
+				previous := after
+				for _, order := range page {
+					if order.CreatedAt.IsZero() {
+						t.Errorf("ListActiveOrders() order %v has no CreatedAt", order.OrderID)
+					}
+					if previous != nil && order.CreatedAt.After(previous.CreatedAt) {
+						t.Errorf("ListActiveOrders() %v listed after the older %v", order.OrderID, previous.OrderID)
+					}
+					previous = order
+					got = append(got, order.OrderID)
+				}
+				if len(page) < tt.limit {
+					break
+				}
+				after = page[len(page)-1]
+			}
+			want := append([]string{}, tt.want...)
+			sort.Strings(got)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ListActiveOrders() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func testItemsConformance(t *testing.T, repos conformanceRepos) {
+	ctx := context.Background()
+	order := newTestOrder(constants.PENDING)
+	if err := repos.orders.CreateOrder(ctx, order); err != nil {
+		t.Fatalf("CreateOrder() error = %v", err)
+	}
+	items := []models.Item{
+		{ItemID: uuid.NewString(), OrderID: order.OrderID, Amount: 1.5},
+		{ItemID: uuid.NewString(), OrderID: order.OrderID, Amount: 2.5},
+	}
+	for i := range items {
+		if err := repos.items.CreateItem(ctx, &items[i]); err != nil {
+			t.Fatalf("CreateItem() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		run     func() error
+		wantErr bool
+		want    error // checked when set
+	}{
+		{
+			name:    "duplicate item",
+			run:     func() error { return repos.items.CreateItem(ctx, &items[0]) },
+			wantErr: true,
+		},
+		{
+			name: "item of a missing order",
+			run: func() error {
+				return repos.items.CreateItem(ctx, &models.Item{ItemID: uuid.NewString(), OrderID: uuid.NewString(), Amount: 1})
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing item",
+			run: func() error {
+				_, err := repos.items.GetItem(ctx, uuid.NewString())
+				return err
+			},
+			wantErr: true,
+			want:    sql.ErrNoRows,
+		},
+		{
+			name: "items of an order without any",
+			run: func() error {
+				got, err := repos.items.GetItemsByOrderId(ctx, uuid.NewString())
+				if len(got) != 0 {
+					t.Errorf("GetItemsByOrderId() = %v, want none", got)
+				}
+				return err
+			},
+		},
+		{
+			name: "removing a missing item is not an error",
+			run:  func() error { return repos.items.RemoveItem(ctx, uuid.NewString(), order.OrderID) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.run()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want != nil && err != tt.want {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if got, err := repos.items.GetItem(ctx, items[0].ItemID); err != nil || !reflect.DeepEqual(*got, items[0]) {
+		t.Errorf("GetItem() = %v, %v, want %v", got, err, items[0])
+	}
+	if err := repos.items.RemoveItem(ctx, items[0].ItemID, order.OrderID); err != nil {
+		t.Fatalf("RemoveItem() error = %v", err)
+	}
+	if left, err := repos.items.GetItemsByOrderId(ctx, order.OrderID); err != nil || !reflect.DeepEqual(left, items[1:]) {
+		t.Errorf("GetItemsByOrderId() = %v, %v, want %v", left, err, items[1:])
+	}
+}
+
+func testMetricsConformance(t *testing.T, repos conformanceRepos) {
+	ctx := context.Background()
+	name := string(constants.PROCESSING_TIME)
+	if count, err := repos.metrics.GetMetricCount(ctx, name); err != nil || *count != 0 {
+		t.Errorf("GetMetricCount() of no metrics = %v, %v, want 0", count, err)
+	}
+	if average, err := repos.metrics.GetAverageTime(ctx, name); err != nil || *average != 0 {
+		t.Errorf("GetAverageTime() of no metrics = %v, %v, want 0", average, err)
+	}
+
+	if err := repos.metrics.CreateMetric(ctx, &models.Metric{OrderId: "1", Duration: 1, MetricName: name}); err != nil {
+		t.Fatalf("CreateMetric() error = %v", err)
+	}
+	errs := repos.metrics.CreateMetricBatch(ctx, []*models.Metric{
+		{OrderId: "2", Duration: 3, MetricName: name},
+		{OrderId: "2", Duration: 8, MetricName: string(constants.CREATION_TIME)},
+	})
+	if !reflect.DeepEqual(errs, []error{nil, nil}) {
+		t.Fatalf("CreateMetricBatch() = %v, want no errors", errs)
+	}
+
+	if count, err := repos.metrics.GetMetricCount(ctx, name); err != nil || *count != 2 {
+		t.Errorf("GetMetricCount() = %v, %v, want 2", count, err)
+	}
+	if average, err := repos.metrics.GetAverageTime(ctx, name); err != nil || *average != 2 {
+		t.Errorf("GetAverageTime() = %v, %v, want 2", average, err)
+	}
+	want := &models.Metric{OrderId: "2", Duration: 3}
+	if got, err := repos.metrics.GetMetricByID(ctx, 2, name); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("GetMetricByID() = %v, %v, want %v", got, err, want)
+	}
+	if got, err := repos.metrics.GetMetricByID(ctx, 3, name); err != sql.ErrNoRows {
+		t.Errorf("GetMetricByID() of a missing metric = %v, %v, want %v", got, err, sql.ErrNoRows)
+	}
+}
+
+func testLedgerConformance(t *testing.T, repos conformanceRepos) {
+	ctx := context.Background()
+	stage := string(constants.ORDER_PROCESSING_QUEUE)
+	newItem := func(id string, force bool) *models.ProcessedItem {
+		return &models.ProcessedItem{ItemID: id, Stage: stage, Force: force}
+	}
+	tests := []struct {
+		name     string
+		items    []*models.ProcessedItem
+		wantErrs []error
+	}{
+		{name: "first delivery", items: []*models.ProcessedItem{newItem("a", false)}, wantErrs: []error{nil}},
+		{name: "redelivery", items: []*models.ProcessedItem{newItem("a", false)}, wantErrs: []error{errors.ErrAlreadyProcessed}},
+		{name: "forced", items: []*models.ProcessedItem{newItem("a", true)}, wantErrs: []error{nil}},
+		{
+			name:     "mixed batch",
+			items:    []*models.ProcessedItem{newItem("b", false), newItem("a", false), newItem("c", false)},
+			wantErrs: []error{nil, errors.ErrAlreadyProcessed, nil},
+		},
+		{
+			name:     "other stage",
+			items:    []*models.ProcessedItem{{ItemID: "a", Stage: "other_stage"}},
+			wantErrs: []error{nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if errs := repos.ledger.MarkProcessed(ctx, tt.items); !reflect.DeepEqual(errs, tt.wantErrs) {
+				t.Errorf("MarkProcessed() = %v, want %v", errs, tt.wantErrs)
+			}
+		})
+	}
+
+	if err := repos.ledger.UnmarkProcessed(ctx, stage, []string{"b", "d"}); err != nil {
+		t.Fatalf("UnmarkProcessed() error = %v", err)
+	}
+	if err := repos.ledger.UnmarkProcessed(ctx, stage, nil); err != nil {
+		t.Errorf("UnmarkProcessed() of no ids error = %v", err)
+	}
+	// Only b was released.
+	errs := repos.ledger.MarkProcessed(ctx, []*models.ProcessedItem{newItem("a", false), newItem("b", false), newItem("c", false)})
+	if want := []error{errors.ErrAlreadyProcessed, nil, errors.ErrAlreadyProcessed}; !reflect.DeepEqual(errs, want) {
+		t.Errorf("MarkProcessed() after UnmarkProcessed() = %v, want %v", errs, want)
+	}
+}
+
+// testConcurrentWritesConformance has the queue workers' write pattern:
+// orders created in units of work while others are updated and metrics
+// recorded.
+func testArchiveConformance(t *testing.T, repos conformanceRepos) {
+	ctx := context.Background()
+	var completed []*models.Order
+	for _, status := range []constants.OrderStates{constants.COMPELETED, constants.PENDING, constants.COMPELETED, constants.COMPELETED} {
+		order := newTestOrder(status)
+		if err := repos.orders.CreateOrder(ctx, order); err != nil {
+			t.Fatalf("CreateOrder() error = %v", err)
+		}
+		if err := repos.items.CreateItem(ctx, &models.Item{ItemID: "item1", OrderID: order.OrderID, Amount: 76.5}); err != nil {
+			t.Fatalf("CreateItem() error = %v", err)
+		}
+		if status == constants.COMPELETED {
+			completed = append(completed, order)
+		}
+	}
+
+	if ids, selected, err := repos.archive.ArchiveOrders(ctx, time.Now().Add(-time.Hour), 10); err != nil || len(ids) != 0 || selected != 0 {
+		t.Errorf("ArchiveOrders() of recent orders = %v, %v, %v, want none", ids, selected, err)
+	}
+	first, selected, err := repos.archive.ArchiveOrders(ctx, time.Now().Add(time.Hour), 2)
+	if err != nil || len(first) != 2 || selected != 2 {
+		t.Fatalf("ArchiveOrders() = %v, %v, %v, want 2 ids", first, selected, err)
+	}
+	rest, selected, err := repos.archive.ArchiveOrders(ctx, time.Now().Add(time.Hour), 2)
+	if err != nil || len(rest) != 1 || selected != 1 {
+		t.Fatalf("ArchiveOrders() = %v, %v, %v, want the last id", rest, selected, err)
+	}
+	got := append(first, rest...)
+	want := []string{}
+	for _, order := range completed {
+		want = append(want, order.OrderID)
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ArchiveOrders() = %v, want the Completed orders %v", got, want)
+	}
+
+	order := completed[0]
+	if _, err := repos.orders.GetOrderByID(ctx, order.OrderID); err != sql.ErrNoRows {
+		t.Errorf("GetOrderByID() of an archived order error = %v, want %v", err, sql.ErrNoRows)
+	}
+	if items, err := repos.items.GetItemsByOrderId(ctx, order.OrderID); err != nil || len(items) != 0 {
+		t.Errorf("GetItemsByOrderId() of an archived order = %v, %v, want none", items, err)
+	}
+	archived, items, err := repos.archive.GetArchivedOrder(ctx, order.OrderID)
+	if err != nil || !reflect.DeepEqual(archived, order) || len(items) != 1 || items[0].Amount != 76.5 {
+		t.Errorf("GetArchivedOrder() = %v, %v, %v, want %v with its item", archived, items, err, order)
+	}
+	if _, _, err := repos.archive.GetArchivedOrder(ctx, uuid.NewString()); err != sql.ErrNoRows {
+		t.Errorf("GetArchivedOrder() of a live or unknown order error = %v, want %v", err, sql.ErrNoRows)
+	}
+
+	// SQLite timestamps have whole seconds, the restore must come after a
+	// cutoff that is past created_at.
+	time.Sleep(1100 * time.Millisecond)
+	cutoff := time.Now()
+	if err := repos.archive.RestoreOrder(ctx, order.OrderID); err != nil {
+		t.Fatalf("RestoreOrder() error = %v", err)
+	}
+	if restored, err := repos.orders.GetOrderByID(ctx, order.OrderID); err != nil || !reflect.DeepEqual(restored, order) {
+		t.Errorf("GetOrderByID() after restore = %v, %v, want %v", restored, err, order)
+	}
+	if items, err := repos.items.GetItemsByOrderId(ctx, order.OrderID); err != nil || len(items) != 1 {
+		t.Errorf("GetItemsByOrderId() after restore = %v, %v, want the item", items, err)
+	}
+	if _, _, err := repos.archive.GetArchivedOrder(ctx, order.OrderID); err != sql.ErrNoRows {
+		t.Errorf("GetArchivedOrder() after restore error = %v, want %v", err, sql.ErrNoRows)
+	}
+	if err := repos.archive.RestoreOrder(ctx, order.OrderID); err != sql.ErrNoRows {
+		t.Errorf("RestoreOrder() twice error = %v, want %v", err, sql.ErrNoRows)
+	}
+	if ids, _, err := repos.archive.ArchiveOrders(ctx, cutoff, 10); err != nil || len(ids) != 0 {
+		t.Errorf("ArchiveOrders() before the restore = %v, %v, want the restored order left alone", ids, err)
+	}
+	if ids, _, err := repos.archive.ArchiveOrders(ctx, time.Now().Add(time.Hour), 10); err != nil || !reflect.DeepEqual(ids, []string{order.OrderID}) {
+		t.Errorf("ArchiveOrders() after the restore = %v, %v, want %v archived again", ids, err, order.OrderID)
+	}
+}
+
+func testConcurrentWritesConformance(t *testing.T, repos conformanceRepos) {
+	ctx := context.Background()
+	const n = 20
+	ids := make([]string, n)
+	var wg sync.WaitGroup
+	for i := range ids {
+		ids[i] = uuid.NewString()
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			err := repos.unitOfWork.Do(ctx, func(r Repositories) error {
+				if err := r.Orders.CreateOrder(ctx, &models.Order{OrderID: id, UserID: "testUser", TotalAmount: 1, Status: "Pending"}); err != nil {
+					return err
+				}
+				return r.Items.CreateItem(ctx, &models.Item{ItemID: "item", OrderID: id, Amount: 1})
+			})
+			if err != nil {
+				t.Errorf("Do(%v) error = %v", id, err)
+				return
+			}
+			if err := repos.orders.UpdateOrderStatus(ctx, id, string(constants.PROCESSING)); err != nil {
+				t.Errorf("UpdateOrderStatus(%v) error = %v", id, err)
+			}
+			if errs := repos.orders.UpdateOrderStatusBatch(ctx, []string{id}, string(constants.COMPELETED)); errs[0] != nil {
+				t.Errorf("UpdateOrderStatusBatch(%v) error = %v", id, errs[0])
+			}
+			if errs := repos.ledger.MarkProcessed(ctx, []*models.ProcessedItem{{ItemID: id, Stage: "concurrent"}}); errs[0] != nil {
+				t.Errorf("MarkProcessed(%v) error = %v", id, errs[0])
+			}
+			if errs := repos.metrics.CreateMetricBatch(ctx, []*models.Metric{{OrderId: id, Duration: 1, MetricName: "concurrent"}}); errs[0] != nil {
+				t.Errorf("CreateMetricBatch(%v) error = %v", id, errs[0])
+			}
+		}(ids[i])
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		if order, err := repos.orders.GetOrderByID(ctx, id); err != nil || order.Status != string(constants.COMPELETED) {
+			t.Errorf("GetOrderByID(%v) = %v, %v, want status %v", id, order, err, constants.COMPELETED)
+		}
+		if items, err := repos.items.GetItemsByOrderId(ctx, id); err != nil || len(items) != 1 {
+			t.Errorf("GetItemsByOrderId(%v) = %v, %v, want 1 item", id, items, err)
+		}
+	}
+	if count, err := repos.metrics.GetMetricCount(ctx, "concurrent"); err != nil || *count != n {
+		t.Errorf("GetMetricCount() = %v, %v, want %v", count, err, n)
+	}
+}
+
+func testCanceledConformance(t *testing.T, repos conformanceRepos) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	order := newTestOrder(constants.PENDING)
+	if err := repos.orders.CreateOrder(ctx, order); !stderrors.Is(err, context.Canceled) {
+		t.Errorf("CreateOrder() error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := repos.orders.GetOrderByID(ctx, order.OrderID); !stderrors.Is(err, context.Canceled) {
+		t.Errorf("GetOrderByID() error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := repos.metrics.GetMetricCount(ctx, "concurrent"); !stderrors.Is(err, context.Canceled) {
+		t.Errorf("GetMetricCount() error = %v, want %v", err, context.Canceled)
+	}
+
+	// Canceled while the unit of work runs, nothing is kept.
+	ctx, cancel = context.WithCancel(context.Background())
+	err := repos.unitOfWork.Do(ctx, func(r Repositories) error {
+		if err := r.Orders.CreateOrder(ctx, order); err != nil {
+			return err
+		}
+		cancel()
+		return nil
+	})
+	if err == nil {
+		t.Errorf("Do() error = nil after cancel, want an error")
+	}
+	if got, err := repos.orders.GetOrderByID(context.Background(), order.OrderID); err != sql.ErrNoRows {
+		t.Errorf("GetOrderByID() after cancel = %v, %v, want %v", got, err, sql.ErrNoRows)
+	}
+}
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"fmt"
+
+	"ecom.com/constants"
+)
+
+// The constructors below pick the implementation for the database/sql
+// driver a DB was opened with.
+
+func NewOrderRepository(driver string, db DBTX) (OrderRepositoryI, error) {
+	switch constants.DriverName(driver) {
+	case constants.SQLITE_DRIVER:
+		return NewSQLiteOrderRepository(db), nil
+	case constants.POSTGRES_DRIVER:
+		return NewPostgreSqlOrderRepository(db), nil
+	}
+	return nil, fmt.Errorf("no order repository for driver %q", driver)
+}
+
+func NewItemRepository(driver string, db DBTX) (ItemRepositoryI, error) {
+	switch constants.DriverName(driver) {
+	case constants.SQLITE_DRIVER:
+		return NewSQLiteItemRepository(db), nil
+	case constants.POSTGRES_DRIVER:
+		return NewPostgreSqlItemRepository(db), nil
+	}
+	return nil, fmt.Errorf("no item repository for driver %q", driver)
+}
+
+func NewMetricRepository(driver string, db DBTX) (MetricRepositoryI, error) {
+	switch constants.DriverName(driver) {
+	case constants.SQLITE_DRIVER:
+		return NewSQLiteMetricRepository(db), nil
+	case constants.POSTGRES_DRIVER:
+		return NewPostgeSqlMetricRepository(db), nil
+	}
+	return nil, fmt.Errorf("no metric repository for driver %q", driver)
+}
+
+func NewLedgerRepository(driver string, db DBTX) (LedgerRepositoryI, error) {
+	switch constants.DriverName(driver) {
+	case constants.SQLITE_DRIVER:
+		return NewSQLiteLedgerRepository(db), nil
+	case constants.POSTGRES_DRIVER:
+		return NewPostgreSqlLedgerRepository(db), nil
+	}
+	return nil, fmt.Errorf("no ledger repository for driver %q", driver)
+}
+
+func NewArchiveRepository(driver string, db DBTX) (ArchiveRepositoryI, error) {
+	switch constants.DriverName(driver) {
+	case constants.SQLITE_DRIVER:
+		return NewSQLiteArchiveRepository(db), nil
+	case constants.POSTGRES_DRIVER:
+		return NewPostgreSqlArchiveRepository(db), nil
+	}
+	return nil, fmt.Errorf("no archive repository for driver %q", driver)
+}
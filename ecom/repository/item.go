@@ -1,12 +1,13 @@
 package repository
 
 import (
+	"context"
 	"ecom.com/models"
 )
 
 type ItemRepositoryI interface {
-	CreateItem(item *models.Item) error
-	GetItem(id string) (*models.Item, error)
-	GetItemsByOrderId(id string) ([]models.Item, error)
-	RemoveItem(itemId string, orderId string) error
+	CreateItem(ctx context.Context, item *models.Item) error
+	GetItem(ctx context.Context, id string) (*models.Item, error)
+	GetItemsByOrderId(ctx context.Context, id string) ([]models.Item, error)
+	RemoveItem(ctx context.Context, itemId string, orderId string) error
 }
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"ecom.com/errors"
+	"ecom.com/models"
+)
+
+type MemoryItemRepository struct {
+	store *MemoryStore
+	tx    *memoryTx
+}
+
+func NewMemoryItemRepository(store *MemoryStore) ItemRepositoryI {
+	return &MemoryItemRepository{store: store}
+}
+
+// CreateItem needs the order to exist, like the foreign key on items.
+func (r *MemoryItemRepository) CreateItem(ctx context.Context, item *models.Item) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	if _, ok := r.store.orders[item.OrderID]; !ok {
+		return fmt.Errorf("item %v: order %v does not exist", item.ItemID, item.OrderID)
+	}
+	items := r.store.items[item.OrderID]
+	for _, stored := range items {
+		if stored.ItemID == item.ItemID {
+			return fmt.Errorf("item %v of order %v: %w", item.ItemID, item.OrderID, errors.ErrDuplicateKey)
+		}
+	}
+	r.store.items[item.OrderID] = append(items, *item)
+	r.tx.onRollback(func() { r.removeItem(item.ItemID, item.OrderID) })
+	return nil
+}
+
+// GetItem returns the first item with that id, in any order.
+func (r *MemoryItemRepository) GetItem(ctx context.Context, id string) (*models.Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+	for _, items := range r.store.items {
+		for _, item := range items {
+			if item.ItemID == id {
+				return &item, nil
+			}
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *MemoryItemRepository) GetItemsByOrderId(ctx context.Context, id string) ([]models.Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+	var items []models.Item
+	return append(items, r.store.items[id]...), nil
+}
+
+func (r *MemoryItemRepository) RemoveItem(ctx context.Context, itemId string, orderId string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	if removed, ok := r.removeItem(itemId, orderId); ok {
+		r.tx.onRollback(func() {
+			r.store.items[orderId] = append(r.store.items[orderId], removed)
+		})
+	}
+	return nil
+}
+
+// removeItem reports whether the item was there. Callers hold the store lock.
+func (r *MemoryItemRepository) removeItem(itemId string, orderId string) (models.Item, bool) {
+	items := r.store.items[orderId]
+	for i, item := range items {
+		if item.ItemID == itemId {
+			r.store.items[orderId] = append(items[:i:i], items[i+1:]...)
+			if len(r.store.items[orderId]) == 0 {
+				delete(r.store.items, orderId)
+			}
+			return item, true
+		}
+	}
+	return models.Item{}, false
+}
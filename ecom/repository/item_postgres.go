@@ -1,32 +1,32 @@
 package repository
 
 import (
-	"database/sql"
+	"context"
 	"log"
 
 	"ecom.com/models"
 )
 
 type PostgreSqlItemRepository struct {
-	DB *sql.DB
+	DB DBTX
 }
 
-func NewPostgreSqlItemRepository(db *sql.DB) ItemRepositoryI {
+func NewPostgreSqlItemRepository(db DBTX) ItemRepositoryI {
 	return &PostgreSqlItemRepository{DB: db}
 }
 
-func (r *PostgreSqlItemRepository) CreateItem(item *models.Item) error {
+func (r *PostgreSqlItemRepository) CreateItem(ctx context.Context, item *models.Item) error {
 	itemQuery := `INSERT INTO items (item_id, order_id, amount) VALUES ($1, $2, $3)`
-	_, err := r.DB.Exec(itemQuery, item.ItemID, item.OrderID, item.Amount)
+	_, err := r.DB.ExecContext(ctx, itemQuery, item.ItemID, item.OrderID, item.Amount)
 	if err != nil {
 		log.Printf("Failed to add item err %v", err)
 	}
 	return err
 }
 
-func (r *PostgreSqlItemRepository) GetItem(id string) (*models.Item, error) {
+func (r *PostgreSqlItemRepository) GetItem(ctx context.Context, id string) (*models.Item, error) {
 	query := `SELECT item_id, order_id, amount FROM items WHERE item_id = $1`
-	row := r.DB.QueryRow(query, id)
+	row := r.DB.QueryRowContext(ctx, query, id)
 
 	var item models.Item
 	err := row.Scan(&item.ItemID, &item.OrderID, &item.Amount)
@@ -36,9 +36,9 @@ func (r *PostgreSqlItemRepository) GetItem(id string) (*models.Item, error) {
 	return &item, nil
 }
 
-func (r *PostgreSqlItemRepository) GetItemsByOrderId(id string) ([]models.Item, error) {
+func (r *PostgreSqlItemRepository) GetItemsByOrderId(ctx context.Context, id string) ([]models.Item, error) {
 	query := `SELECT item_id, order_id, amount FROM items WHERE order_id = $1`
-	rows, err := r.DB.Query(query, id)
+	rows, err := r.DB.QueryContext(ctx, query, id)
 	if err != nil {
 		log.Println("Error executing query:", err)
 		return nil, err
@@ -57,9 +57,9 @@ func (r *PostgreSqlItemRepository) GetItemsByOrderId(id string) ([]models.Item,
 	return items, nil
 }
 
-func (r *PostgreSqlItemRepository) RemoveItem(itemId string, orderId string) error {
+func (r *PostgreSqlItemRepository) RemoveItem(ctx context.Context, itemId string, orderId string) error {
 	itemQuery := `DELETE FROM items WHERE item_id = $1 AND order_id = $2`
-	_, err := r.DB.Exec(itemQuery, itemId, orderId)
+	_, err := r.DB.ExecContext(ctx, itemQuery, itemId, orderId)
 	if err != nil {
 		log.Printf("Failed to add item err %v", err)
 	}
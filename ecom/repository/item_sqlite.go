@@ -1,32 +1,32 @@
 package repository
 
 import (
-	"database/sql"
+	"context"
 	"log"
 
 	"ecom.com/models"
 )
 
 type SQLiteItemRepository struct {
-	DB *sql.DB
+	DB DBTX
 }
 
-func NewSQLiteItemRepository(db *sql.DB) ItemRepositoryI {
+func NewSQLiteItemRepository(db DBTX) ItemRepositoryI {
 	return &SQLiteItemRepository{DB: db}
 }
 
-func (r *SQLiteItemRepository) CreateItem(item *models.Item) error {
+func (r *SQLiteItemRepository) CreateItem(ctx context.Context, item *models.Item) error {
 	itemQuery := `INSERT INTO items (item_id, order_id, amount) VALUES (?, ?, ?)`
-	_, err := r.DB.Exec(itemQuery, item.ItemID, item.OrderID, item.Amount)
+	_, err := r.DB.ExecContext(ctx, itemQuery, item.ItemID, item.OrderID, item.Amount)
 	if err != nil {
 		log.Printf("Failed to add item err %v", err)
 	}
 	return err
 }
 
-func (r *SQLiteItemRepository) GetItem(id string) (*models.Item, error) {
+func (r *SQLiteItemRepository) GetItem(ctx context.Context, id string) (*models.Item, error) {
 	query := `SELECT item_id, order_id, amount FROM items WHERE item_id = ?`
-	row := r.DB.QueryRow(query, id)
+	row := r.DB.QueryRowContext(ctx, query, id)
 
 	var item models.Item
 	err := row.Scan(&item.ItemID, &item.OrderID, &item.Amount)
@@ -36,9 +36,9 @@ func (r *SQLiteItemRepository) GetItem(id string) (*models.Item, error) {
 	return &item, nil
 }
 
-func (r *SQLiteItemRepository) GetItemsByOrderId(id string) ([]models.Item, error) {
+func (r *SQLiteItemRepository) GetItemsByOrderId(ctx context.Context, id string) ([]models.Item, error) {
 	query := `SELECT item_id, order_id, amount FROM items WHERE order_id = ?`
-	rows, err := r.DB.Query(query, id)
+	rows, err := r.DB.QueryContext(ctx, query, id)
 	if err != nil {
 		log.Println("Error executing query:", err)
 		return nil, err
@@ -57,9 +57,9 @@ func (r *SQLiteItemRepository) GetItemsByOrderId(id string) ([]models.Item, erro
 	return items, nil
 }
 
-func (r *SQLiteItemRepository) RemoveItem(itemId string, orderId string) error {
+func (r *SQLiteItemRepository) RemoveItem(ctx context.Context, itemId string, orderId string) error {
 	itemQuery := `DELETE FROM items WHERE item_id = ? AND order_id = ?`
-	_, err := r.DB.Exec(itemQuery, itemId, orderId)
+	_, err := r.DB.ExecContext(ctx, itemQuery, itemId, orderId)
 	if err != nil {
 		log.Printf("Failed to add item err %v", err)
 	}
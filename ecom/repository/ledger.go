@@ -0,0 +1,20 @@
+package repository
+
+import (
+	"context"
+	"ecom.com/models"
+)
+
+// LedgerRepositoryI records which queue items went through which stage so a
+// redelivered item does not repeat its side effects. Queues claim an item with
+// MarkProcessed before running the stage, of two deliveries only the one that
+// claimed it runs.
+type LedgerRepositoryI interface {
+	// MarkProcessed claims each item for its stage. An item claimed before
+	// gets errors.ErrAlreadyProcessed, unless it is forced, which refreshes
+	// its entry.
+	MarkProcessed(ctx context.Context, items []*models.ProcessedItem) []error
+	// UnmarkProcessed drops the entries of itemIds for stage, so items that
+	// were claimed but failed can be delivered again.
+	UnmarkProcessed(ctx context.Context, stage string, itemIds []string) error
+}
@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"context"
+
+	"ecom.com/errors"
+	"ecom.com/models"
+)
+
+type MemoryLedgerRepository struct {
+	store *MemoryStore
+}
+
+func NewMemoryLedgerRepository(store *MemoryStore) LedgerRepositoryI {
+	return &MemoryLedgerRepository{store: store}
+}
+
+func (r *MemoryLedgerRepository) MarkProcessed(ctx context.Context, items []*models.ProcessedItem) []error {
+	errs := make([]error, len(items))
+	if err := ctx.Err(); err != nil {
+		return fillErrors(errs, err)
+	}
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for i, item := range items {
+		key := item.Stage + "/" + item.ItemID
+		if r.store.processed[key] && !item.Force {
+			errs[i] = errors.ErrAlreadyProcessed
+			continue
+		}
+		r.store.processed[key] = true
+	}
+	return errs
+}
+
+func (r *MemoryLedgerRepository) UnmarkProcessed(ctx context.Context, stage string, itemIds []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for _, id := range itemIds {
+		delete(r.store.processed, stage+"/"+id)
+	}
+	return nil
+}
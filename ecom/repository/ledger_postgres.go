@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"ecom.com/database"
+	"ecom.com/errors"
+	"ecom.com/models"
+)
+
+type PostgreSqlLedgerRepository struct {
+	DB DBTX
+}
+
+func NewPostgreSqlLedgerRepository(db DBTX) LedgerRepositoryI {
+	return &PostgreSqlLedgerRepository{DB: db}
+}
+
+func (r *PostgreSqlLedgerRepository) MarkProcessed(ctx context.Context, items []*models.ProcessedItem) []error {
+	return runBatch(ctx, r.DB, len(items), func(tx *sql.Tx, i int) error {
+		item := items[i]
+		query := `INSERT INTO processed_items (item_id, stage) VALUES ($1, $2) ON CONFLICT (item_id, stage) DO NOTHING`
+		if item.Force {
+			query = `INSERT INTO processed_items (item_id, stage) VALUES ($1, $2) ON CONFLICT (item_id, stage) DO UPDATE SET processed_at = CURRENT_TIMESTAMP`
+		}
+		res, err := tx.ExecContext(ctx, query, item.ItemID, item.Stage)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return errors.ErrAlreadyProcessed
+		}
+		return nil
+	})
+}
+
+func (r *PostgreSqlLedgerRepository) UnmarkProcessed(ctx context.Context, stage string, itemIds []string) error {
+	if len(itemIds) == 0 {
+		return nil
+	}
+	placeholders := make([]string, len(itemIds))
+	args := []any{stage}
+	for i, id := range itemIds {
+		placeholders[i] = "$" + strconv.Itoa(i+2)
+		args = append(args, id)
+	}
+	query := `DELETE FROM processed_items WHERE stage = $1 AND item_id IN (` + strings.Join(placeholders, ", ") + `)`
+	// The query has one placeholder per item, its statement is not cached.
+	_, err := r.DB.ExecContext(database.Unprepared(ctx), query, args...)
+	return err
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"ecom.com/constants"
+	"ecom.com/database"
+	"ecom.com/errors"
+	"ecom.com/models"
+	"github.com/google/uuid"
+)
+
+func TestSQLiteLedgerRepository_MarkProcessed(t *testing.T) {
+	testDb := database.ConnectMetricsDB("sqlite3", filepath.Join(t.TempDir(), "testMetricsDb.db"))
+	defer database.CloseDB(testDb)
+	newItem := func(id string, force bool) *models.ProcessedItem {
+		return &models.ProcessedItem{ItemID: id, Stage: string(constants.ORDER_PROCESSING_QUEUE), Force: force}
+	}
+	itemId := uuid.NewString()
+	tests := []struct {
+		name    string
+		item    *models.ProcessedItem
+		wantErr error
+	}{
+		{
+			name: "first delivery",
+			item: newItem(itemId, false),
+		},
+		{
+			name:    "redelivery",
+			item:    newItem(itemId, false),
+			wantErr: errors.ErrAlreadyProcessed,
+		},
+		{
+			name: "forced",
+			item: newItem(itemId, true),
+		},
+		{
+			name: "another item",
+			item: newItem(uuid.NewString(), false),
+		},
+	}
+	r := &SQLiteLedgerRepository{DB: testDb}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := r.MarkProcessed(context.Background(), []*models.ProcessedItem{tt.item})
+			if errs[0] != tt.wantErr {
+				t.Errorf("SQLiteLedgerRepository.MarkProcessed(context.Background()) error = %v, wantErr %v", errs[0], tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"ecom.com/models"
+)
+
+// MemoryStore keeps everything the SQL databases would in process memory,
+// for tests and demos. It is safe for concurrent use and starts empty.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	orders    map[string]models.Order
+	items     map[string][]models.Item // by order id, in insertion order
+	metrics   []models.Metric
+	processed map[string]bool // ledger, stage + "/" + item id
+	// Archived orders and their items, out of sight of the maps above.
+	archivedOrders map[string]models.Order
+	archivedItems  map[string][]models.Item
+	restored       map[string]time.Time // when orders were moved back from the archive, by id
+	// Serializes units of work, like a single writer DB.
+	txMu sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		orders:    map[string]models.Order{},
+		items:     map[string][]models.Item{},
+		processed: map[string]bool{},
+
+		archivedOrders: map[string]models.Order{},
+		archivedItems:  map[string][]models.Item{},
+		restored:       map[string]time.Time{},
+	}
+}
+
+// memoryTx records how to undo the writes of a unit of work. Writes are
+// visible to other readers before the commit, there is no isolation.
+type memoryTx struct {
+	undo []func()
+}
+
+// onRollback registers how to undo a write. Callers hold the store lock,
+// a nil tx means the write is not part of a unit of work.
+func (tx *memoryTx) onRollback(undo func()) {
+	if tx != nil {
+		tx.undo = append(tx.undo, undo)
+	}
+}
+
+func (tx *memoryTx) rollback(store *MemoryStore) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+}
+
+type MemoryUnitOfWork struct {
+	store *MemoryStore
+}
+
+func NewMemoryUnitOfWork(store *MemoryStore) UnitOfWorkI {
+	return &MemoryUnitOfWork{store: store}
+}
+
+// Do rolls back when fn fails or ctx ends before it returns, like a
+// canceled SQL transaction.
+func (u *MemoryUnitOfWork) Do(ctx context.Context, fn func(repos Repositories) error) error {
+	u.store.txMu.Lock()
+	defer u.store.txMu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tx := &memoryTx{}
+	err := fn(Repositories{
+		Orders: &MemoryOrderRepository{store: u.store, tx: tx},
+		Items:  &MemoryItemRepository{store: u.store, tx: tx},
+	})
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		tx.rollback(u.store)
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"ecom.com/models"
+)
+
+func TestMemoryOrderRepository_ListActiveOrders(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	for _, order := range []models.Order{
+		{OrderID: "old-completed", Status: "Completed", CreatedAt: now.Add(-2 * time.Hour)},
+		{OrderID: "old-pending", Status: "Pending", CreatedAt: now.Add(-2 * time.Hour)},
+		{OrderID: "recent-completed", Status: "Completed", CreatedAt: now.Add(-10 * time.Minute)},
+		{OrderID: "a-processing", Status: "Processing", CreatedAt: now},
+		{OrderID: "b-pending", Status: "Pending", CreatedAt: now},
+	} {
+		store.orders[order.OrderID] = order
+	}
+
+	tests := []struct {
+		name  string
+		since time.Time
+		limit int
+		want  []string
+	}{
+		{
+			name:  "in flight and recent, one page",
+			since: now.Add(-time.Hour),
+			limit: 10,
+			want:  []string{"b-pending", "a-processing", "recent-completed", "old-pending"},
+		},
+		{
+			name:  "paged",
+			since: now.Add(-time.Hour),
+			limit: 1,
+			want:  []string{"b-pending", "a-processing", "recent-completed", "old-pending"},
+		},
+		{
+			name:  "in flight only",
+			since: now.Add(time.Hour),
+			limit: 2,
+			want:  []string{"b-pending", "a-processing", "old-pending"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewMemoryOrderRepository(store)
+			got := []string{}
+			var after *models.Order
+			for {
+				page, err := r.ListActiveOrders(context.Background(), tt.since, after, tt.limit)
+				if err != nil {
+					t.Fatalf("MemoryOrderRepository.ListActiveOrders() error = %v", err)
+				}
+				for _, order := range page {
+					got = append(got, order.OrderID)
+				}
+				if len(page) < tt.limit {
+					break
+				}
+				after = page[len(page)-1]
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MemoryOrderRepository.ListActiveOrders() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
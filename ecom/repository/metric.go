@@ -1,12 +1,16 @@
 package repository
 
 import (
+	"context"
 	"ecom.com/models"
 )
 
 type MetricRepositoryI interface {
-	CreateMetric(metric *models.Metric) error
-	GetMetricByID(id int, name string) (*models.Metric, error)
-	GetMetricCount() (*int, error)
-	GetAverageTime(metricname string) (*float64, error)
+	CreateMetric(ctx context.Context, metric *models.Metric) error
+	// CreateMetricBatch inserts all metrics in one transaction and returns
+	// the result for each metric.
+	CreateMetricBatch(ctx context.Context, metrics []*models.Metric) []error
+	GetMetricByID(ctx context.Context, id int, name string) (*models.Metric, error)
+	GetMetricCount(ctx context.Context, metricName string) (*int, error)
+	GetAverageTime(ctx context.Context, metricname string) (*float64, error)
 }
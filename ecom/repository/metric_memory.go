@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	"ecom.com/models"
+)
+
+type MemoryMetricRepository struct {
+	store *MemoryStore
+}
+
+func NewMemoryMetricRepository(store *MemoryStore) MetricRepositoryI {
+	return &MemoryMetricRepository{store: store}
+}
+
+func (r *MemoryMetricRepository) CreateMetric(ctx context.Context, m *models.Metric) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	r.store.metrics = append(r.store.metrics, *m)
+	return nil
+}
+
+func (r *MemoryMetricRepository) CreateMetricBatch(ctx context.Context, metrics []*models.Metric) []error {
+	errs := make([]error, len(metrics))
+	if err := ctx.Err(); err != nil {
+		return fillErrors(errs, err)
+	}
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for _, m := range metrics {
+		r.store.metrics = append(r.store.metrics, *m)
+	}
+	return errs
+}
+
+func (r *MemoryMetricRepository) GetMetricByID(ctx context.Context, id int, name string) (*models.Metric, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+	orderId := strconv.Itoa(id)
+	for _, m := range r.store.metrics {
+		if m.OrderId == orderId && m.MetricName == name {
+			return &models.Metric{OrderId: m.OrderId, Duration: m.Duration}, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *MemoryMetricRepository) GetMetricCount(ctx context.Context, metricName string) (*int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+	count := 0
+	for _, m := range r.store.metrics {
+		if m.MetricName == metricName {
+			count++
+		}
+	}
+	return &count, nil
+}
+
+func (r *MemoryMetricRepository) GetAverageTime(ctx context.Context, metricName string) (*float64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+	var total, average float64
+	count := 0
+	for _, m := range r.store.metrics {
+		if m.MetricName == metricName {
+			total += m.Duration
+			count++
+		}
+	}
+	if count > 0 {
+		average = total / float64(count)
+	}
+	return &average, nil
+}
@@ -1,28 +1,38 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 
 	"ecom.com/models"
 )
 
 type PostgeSqlMetricRepository struct {
-	DB *sql.DB
+	DB DBTX
 }
 
-func NewPostgeSqlMetricRepository(db *sql.DB) MetricRepositoryI {
+func NewPostgeSqlMetricRepository(db DBTX) MetricRepositoryI {
 	return &PostgeSqlMetricRepository{DB: db}
 }
 
-func (r *PostgeSqlMetricRepository) CreateMetric(m *models.Metric) error {
+func (r *PostgeSqlMetricRepository) CreateMetric(ctx context.Context, m *models.Metric) error {
 	query := `INSERT INTO metrics (order_id, duration, metric_name) VALUES ($1, $2, $3)`
-	_, err := r.DB.Exec(query, m.OrderId, m.Duration, m.MetricName)
+	_, err := r.DB.ExecContext(ctx, query, m.OrderId, m.Duration, m.MetricName)
 	return err
 }
 
-func (r *PostgeSqlMetricRepository) GetMetricByID(id int, name string) (*models.Metric, error) {
+func (r *PostgeSqlMetricRepository) CreateMetricBatch(ctx context.Context, metrics []*models.Metric) []error {
+	query := `INSERT INTO metrics (order_id, duration, metric_name) VALUES ($1, $2, $3)`
+	args := make([][]any, len(metrics))
+	for i, m := range metrics {
+		args[i] = []any{m.OrderId, m.Duration, m.MetricName}
+	}
+	return execBatch(ctx, r.DB, query, args, false)
+}
+
+func (r *PostgeSqlMetricRepository) GetMetricByID(ctx context.Context, id int, name string) (*models.Metric, error) {
 	query := `SELECT order_id, duration FROM metrics WHERE order_id = $1 AND metric_name = $2`
-	row := r.DB.QueryRow(query, id, name)
+	row := r.DB.QueryRowContext(ctx, query, id, name)
 
 	var metric models.Metric
 	err := row.Scan(&metric.OrderId, &metric.Duration)
@@ -32,29 +42,20 @@ func (r *PostgeSqlMetricRepository) GetMetricByID(id int, name string) (*models.
 	return &metric, nil
 }
 
-func (r *PostgeSqlMetricRepository) GetMetricCount() (*int, error) {
+func (r *PostgeSqlMetricRepository) GetMetricCount(ctx context.Context, metricName string) (*int, error) {
 	var TotalOrdersReceived int
-	err := r.DB.QueryRow("SELECT COUNT(*) FROM metrics").Scan(&TotalOrdersReceived)
+	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM metrics WHERE metric_name = $1", metricName).Scan(&TotalOrdersReceived)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
 	return &TotalOrdersReceived, nil
 }
 
-func (r *PostgeSqlMetricRepository) GetAverageTime(metricName string) (*float64, error) {
+func (r *PostgeSqlMetricRepository) GetAverageTime(ctx context.Context, metricName string) (*float64, error) {
 	var AverageDuration float64
-	err := r.DB.QueryRow("SELECT COALESCE(AVG(duration), 0) FROM metrics WHERE metric_name = $1", metricName).Scan(&AverageDuration)
+	err := r.DB.QueryRowContext(ctx, "SELECT COALESCE(AVG(duration), 0) FROM metrics WHERE metric_name = $1", metricName).Scan(&AverageDuration)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
 	return &AverageDuration, nil
 }
-
-func (r *PostgeSqlMetricRepository) GetCountByStatus(status string) (*int, error) {
-	var count int
-	err := r.DB.QueryRow("SELECT COUNT(*) FROM orders WHERE status = $1", string(status)).Scan(&count)
-	if err != nil && err != sql.ErrNoRows {
-		return nil, err
-	}
-	return &count, nil
-}
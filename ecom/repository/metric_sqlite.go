@@ -1,28 +1,38 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 
 	"ecom.com/models"
 )
 
 type SQLiteMetricRepository struct {
-	DB *sql.DB
+	DB DBTX
 }
 
-func NewSQLiteMetricRepository(db *sql.DB) MetricRepositoryI {
+func NewSQLiteMetricRepository(db DBTX) MetricRepositoryI {
 	return &SQLiteMetricRepository{DB: db}
 }
 
-func (r *SQLiteMetricRepository) CreateMetric(m *models.Metric) error {
+func (r *SQLiteMetricRepository) CreateMetric(ctx context.Context, m *models.Metric) error {
 	query := `INSERT INTO metrics (order_id, duration, metric_name) VALUES (?, ?, ?)`
-	_, err := r.DB.Exec(query, m.OrderId, m.Duration, m.MetricName)
+	_, err := r.DB.ExecContext(ctx, query, m.OrderId, m.Duration, m.MetricName)
 	return err
 }
 
-func (r *SQLiteMetricRepository) GetMetricByID(id int, name string) (*models.Metric, error) {
+func (r *SQLiteMetricRepository) CreateMetricBatch(ctx context.Context, metrics []*models.Metric) []error {
+	query := `INSERT INTO metrics (order_id, duration, metric_name) VALUES (?, ?, ?)`
+	args := make([][]any, len(metrics))
+	for i, m := range metrics {
+		args[i] = []any{m.OrderId, m.Duration, m.MetricName}
+	}
+	return execBatch(ctx, r.DB, query, args, false)
+}
+
+func (r *SQLiteMetricRepository) GetMetricByID(ctx context.Context, id int, name string) (*models.Metric, error) {
 	query := `SELECT order_id, duration FROM metrics WHERE order_id = ? AND metric_name = ?`
-	row := r.DB.QueryRow(query, id, name)
+	row := r.DB.QueryRowContext(ctx, query, id, name)
 
 	var metric models.Metric
 	err := row.Scan(&metric.OrderId, &metric.Duration)
@@ -32,18 +42,18 @@ func (r *SQLiteMetricRepository) GetMetricByID(id int, name string) (*models.Met
 	return &metric, nil
 }
 
-func (r *SQLiteMetricRepository) GetMetricCount() (*int, error) {
+func (r *SQLiteMetricRepository) GetMetricCount(ctx context.Context, metricName string) (*int, error) {
 	var TotalOrdersReceived int
-	err := r.DB.QueryRow("SELECT COUNT(*) FROM metrics").Scan(&TotalOrdersReceived)
+	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM metrics WHERE metric_name = ?", metricName).Scan(&TotalOrdersReceived)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
 	return &TotalOrdersReceived, nil
 }
 
-func (r *SQLiteMetricRepository) GetAverageTime(metricName string) (*float64, error) {
+func (r *SQLiteMetricRepository) GetAverageTime(ctx context.Context, metricName string) (*float64, error) {
 	var AverageDuration float64
-	err := r.DB.QueryRow("SELECT COALESCE(AVG(duration), 0) FROM metrics WHERE metric_name = ?", metricName).Scan(&AverageDuration)
+	err := r.DB.QueryRowContext(ctx, "SELECT COALESCE(AVG(duration), 0) FROM metrics WHERE metric_name = ?", metricName).Scan(&AverageDuration)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
@@ -1,11 +1,21 @@
 package repository
 
 import (
+	"context"
+	"time"
+
 	"ecom.com/models"
 )
 
 type OrderRepositoryI interface {
-	CreateOrder(order *models.Order) error
-	UpdateOrderStatus(orderId string, status string) error
-	GetOrderByID(id string) (*models.Order, error)
+	CreateOrder(ctx context.Context, order *models.Order) error
+	UpdateOrderStatus(ctx context.Context, orderId string, status string) error
+	// UpdateOrderStatusBatch updates all orders in one transaction and
+	// returns the result for each order id.
+	UpdateOrderStatusBatch(ctx context.Context, orderIds []string, status string) []error
+	GetOrderByID(ctx context.Context, id string) (*models.Order, error)
+	// ListActiveOrders pages through the orders that are still in flight or
+	// were created at or after since, newest first. after is the last order
+	// of the previous page, nil for the first one.
+	ListActiveOrders(ctx context.Context, since time.Time, after *models.Order, limit int) ([]*models.Order, error)
 }
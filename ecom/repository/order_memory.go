@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"ecom.com/constants"
+	"ecom.com/errors"
+	"ecom.com/models"
+)
+
+type MemoryOrderRepository struct {
+	store *MemoryStore
+	tx    *memoryTx
+}
+
+func NewMemoryOrderRepository(store *MemoryStore) OrderRepositoryI {
+	return &MemoryOrderRepository{store: store}
+}
+
+// validStatus mirrors the CHECK constraint on orders.status.
+func validStatus(status string) error {
+	switch constants.OrderStates(status) {
+	case constants.PENDING, constants.PROCESSING, constants.COMPELETED:
+		return nil
+	}
+	return fmt.Errorf("invalid order status %q", status)
+}
+
+func (r *MemoryOrderRepository) CreateOrder(ctx context.Context, order *models.Order) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := validStatus(order.Status); err != nil {
+		return err
+	}
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	if _, ok := r.store.orders[order.OrderID]; ok {
+		return fmt.Errorf("order %v: %w", order.OrderID, errors.ErrDuplicateKey)
+	}
+	stored := *order
+	stored.CreatedAt = time.Now()
+	r.store.orders[order.OrderID] = stored
+	r.tx.onRollback(func() { delete(r.store.orders, order.OrderID) })
+	return nil
+}
+
+// UpdateOrderStatus does nothing for an unknown order, like the UPDATE.
+func (r *MemoryOrderRepository) UpdateOrderStatus(ctx context.Context, orderId string, status string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := validStatus(status); err != nil {
+		return err
+	}
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	r.setStatus(orderId, status)
+	return nil
+}
+
+func (r *MemoryOrderRepository) UpdateOrderStatusBatch(ctx context.Context, orderIds []string, status string) []error {
+	errs := make([]error, len(orderIds))
+	if err := ctx.Err(); err != nil {
+		return fillErrors(errs, err)
+	}
+	if err := validStatus(status); err != nil {
+		return fillErrors(errs, err)
+	}
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for i, orderId := range orderIds {
+		if !r.setStatus(orderId, status) {
+			errs[i] = sql.ErrNoRows
+		}
+	}
+	return errs
+}
+
+// setStatus reports whether the order exists. Callers hold the store lock.
+func (r *MemoryOrderRepository) setStatus(orderId string, status string) bool {
+	order, ok := r.store.orders[orderId]
+	if !ok {
+		return false
+	}
+	previous := order.Status
+	order.Status = status
+	r.store.orders[orderId] = order
+	r.tx.onRollback(func() {
+		order.Status = previous
+		r.store.orders[orderId] = order
+	})
+	return true
+}
+
+// GetOrderByID leaves CreatedAt empty like the SQL repositories.
+func (r *MemoryOrderRepository) GetOrderByID(ctx context.Context, id string) (*models.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+	order, ok := r.store.orders[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	order.CreatedAt = time.Time{}
+	return &order, nil
+}
+
+func (r *MemoryOrderRepository) ListActiveOrders(ctx context.Context, since time.Time, after *models.Order, limit int) ([]*models.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.store.mu.RLock()
+	orders := []*models.Order{}
+	for _, order := range r.store.orders {
+		if order.Status == string(constants.COMPELETED) && order.CreatedAt.Before(since) {
+			continue
+		}
+		if after != nil && !orderedBefore(after, &order) {
+			continue
+		}
+		order := order
+		orders = append(orders, &order)
+	}
+	r.store.mu.RUnlock()
+
+	sort.Slice(orders, func(i, j int) bool { return orderedBefore(orders[i], orders[j]) })
+	if len(orders) > limit {
+		orders = orders[:limit]
+	}
+	return orders, nil
+}
+
+// orderedBefore reports whether a comes before b, newest first and by id
+// for the same time.
+func orderedBefore(a, b *models.Order) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return a.OrderID > b.OrderID
+}
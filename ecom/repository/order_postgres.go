@@ -1,34 +1,45 @@
 package repository
 
 import (
-	"database/sql"
+	"context"
+	"time"
 
+	"ecom.com/constants"
 	"ecom.com/models"
 )
 
 type PostgreSqlOrderRepository struct {
-	DB *sql.DB
+	DB DBTX
 }
 
-func NewPostgreSqlOrderRepository(db *sql.DB) OrderRepositoryI {
+func NewPostgreSqlOrderRepository(db DBTX) OrderRepositoryI {
 	return &PostgreSqlOrderRepository{DB: db}
 }
 
-func (r *PostgreSqlOrderRepository) CreateOrder(order *models.Order) error {
+func (r *PostgreSqlOrderRepository) CreateOrder(ctx context.Context, order *models.Order) error {
 	query := `INSERT INTO orders (order_id, user_id, total_amount, status) VALUES ($1, $2, $3, $4)`
-	_, err := r.DB.Exec(query, order.OrderID, order.UserID, order.TotalAmount, order.Status)
+	_, err := r.DB.ExecContext(ctx, query, order.OrderID, order.UserID, order.TotalAmount, order.Status)
 	return err
 }
 
-func (r *PostgreSqlOrderRepository) UpdateOrderStatus(orderId string, status string) error {
+func (r *PostgreSqlOrderRepository) UpdateOrderStatus(ctx context.Context, orderId string, status string) error {
 	query := `UPDATE orders SET status = $1 WHERE order_id = $2;`
-	_, err := r.DB.Exec(query, status, orderId)
+	_, err := r.DB.ExecContext(ctx, query, status, orderId)
 	return err
 }
 
-func (r *PostgreSqlOrderRepository) GetOrderByID(id string) (*models.Order, error) {
+func (r *PostgreSqlOrderRepository) UpdateOrderStatusBatch(ctx context.Context, orderIds []string, status string) []error {
+	query := `UPDATE orders SET status = $1 WHERE order_id = $2;`
+	args := make([][]any, len(orderIds))
+	for i, orderId := range orderIds {
+		args[i] = []any{status, orderId}
+	}
+	return execBatch(ctx, r.DB, query, args, true)
+}
+
+func (r *PostgreSqlOrderRepository) GetOrderByID(ctx context.Context, id string) (*models.Order, error) {
 	query := `SELECT order_id, user_id, total_amount, status FROM orders WHERE order_id = $1`
-	row := r.DB.QueryRow(query, id)
+	row := r.DB.QueryRowContext(ctx, query, id)
 
 	var order models.Order
 	err := row.Scan(&order.OrderID, &order.UserID, &order.TotalAmount, &order.Status)
@@ -37,3 +48,28 @@ func (r *PostgreSqlOrderRepository) GetOrderByID(id string) (*models.Order, erro
 	}
 	return &order, nil
 }
+
+func (r *PostgreSqlOrderRepository) ListActiveOrders(ctx context.Context, since time.Time, after *models.Order, limit int) ([]*models.Order, error) {
+	query := `SELECT order_id, user_id, total_amount, status, created_at FROM orders
+		WHERE created_at IS NOT NULL AND (status != $1 OR created_at >= $2)
+		AND ($3 OR (created_at, order_id) < ($4, $5))
+		ORDER BY created_at DESC, order_id DESC LIMIT $6`
+	first, afterCreatedAt, afterID := after == nil, time.Time{}, ""
+	if after != nil {
+		afterCreatedAt, afterID = after.CreatedAt, after.OrderID
+	}
+	rows, err := r.DB.QueryContext(ctx, query, string(constants.COMPELETED), since, first, afterCreatedAt, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	orders := []*models.Order{}
+	for rows.Next() {
+		var order models.Order
+		if err := rows.Scan(&order.OrderID, &order.UserID, &order.TotalAmount, &order.Status, &order.CreatedAt); err != nil {
+			return nil, err
+		}
+		orders = append(orders, &order)
+	}
+	return orders, rows.Err()
+}
@@ -1,34 +1,45 @@
 package repository
 
 import (
-	"database/sql"
+	"context"
+	"time"
 
+	"ecom.com/constants"
 	"ecom.com/models"
 )
 
 type SQLiteOrderRepository struct {
-	DB *sql.DB
+	DB DBTX
 }
 
-func NewSQLiteOrderRepository(db *sql.DB) OrderRepositoryI {
+func NewSQLiteOrderRepository(db DBTX) OrderRepositoryI {
 	return &SQLiteOrderRepository{DB: db}
 }
 
-func (r *SQLiteOrderRepository) CreateOrder(order *models.Order) error {
-	query := `INSERT INTO orders (order_id, user_id, total_amount, status) VALUES (?, ?, ?, ?)`
-	_, err := r.DB.Exec(query, order.OrderID, order.UserID, order.TotalAmount, order.Status)
+func (r *SQLiteOrderRepository) CreateOrder(ctx context.Context, order *models.Order) error {
+	query := `INSERT INTO orders (order_id, user_id, total_amount, status, created_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)`
+	_, err := r.DB.ExecContext(ctx, query, order.OrderID, order.UserID, order.TotalAmount, order.Status)
 	return err
 }
 
-func (r *SQLiteOrderRepository) UpdateOrderStatus(orderId string, status string) error {
+func (r *SQLiteOrderRepository) UpdateOrderStatus(ctx context.Context, orderId string, status string) error {
 	query := `UPDATE orders SET status = ? WHERE order_id = ?;`
-	_, err := r.DB.Exec(query, status, orderId)
+	_, err := r.DB.ExecContext(ctx, query, status, orderId)
 	return err
 }
 
-func (r *SQLiteOrderRepository) GetOrderByID(id string) (*models.Order, error) {
+func (r *SQLiteOrderRepository) UpdateOrderStatusBatch(ctx context.Context, orderIds []string, status string) []error {
+	query := `UPDATE orders SET status = ? WHERE order_id = ?;`
+	args := make([][]any, len(orderIds))
+	for i, orderId := range orderIds {
+		args[i] = []any{status, orderId}
+	}
+	return execBatch(ctx, r.DB, query, args, true)
+}
+
+func (r *SQLiteOrderRepository) GetOrderByID(ctx context.Context, id string) (*models.Order, error) {
 	query := `SELECT order_id, user_id, total_amount, status FROM orders WHERE order_id = ?`
-	row := r.DB.QueryRow(query, id)
+	row := r.DB.QueryRowContext(ctx, query, id)
 
 	var order models.Order
 	err := row.Scan(&order.OrderID, &order.UserID, &order.TotalAmount, &order.Status)
@@ -37,3 +48,33 @@ func (r *SQLiteOrderRepository) GetOrderByID(id string) (*models.Order, error) {
 	}
 	return &order, nil
 }
+
+// sqliteTimeFormat matches CURRENT_TIMESTAMP, so times compare as text.
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+// ListActiveOrders skips orders without created_at, they predate the column.
+func (r *SQLiteOrderRepository) ListActiveOrders(ctx context.Context, since time.Time, after *models.Order, limit int) ([]*models.Order, error) {
+	query := `SELECT order_id, user_id, total_amount, status, created_at FROM orders
+		WHERE created_at IS NOT NULL AND (status != ? OR created_at >= ?)
+		AND (? OR created_at < ? OR (created_at = ? AND order_id < ?))
+		ORDER BY created_at DESC, order_id DESC LIMIT ?`
+	first, afterCreatedAt, afterID := after == nil, "", ""
+	if after != nil {
+		afterCreatedAt, afterID = after.CreatedAt.UTC().Format(sqliteTimeFormat), after.OrderID
+	}
+	rows, err := r.DB.QueryContext(ctx, query, string(constants.COMPELETED), since.UTC().Format(sqliteTimeFormat),
+		first, afterCreatedAt, afterCreatedAt, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	orders := []*models.Order{}
+	for rows.Next() {
+		var order models.Order
+		if err := rows.Scan(&order.OrderID, &order.UserID, &order.TotalAmount, &order.Status, &order.CreatedAt); err != nil {
+			return nil, err
+		}
+		orders = append(orders, &order)
+	}
+	return orders, rows.Err()
+}
@@ -1,9 +1,12 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"ecom.com/constants"
 	"ecom.com/database"
@@ -13,12 +16,12 @@ import (
 
 func TestSQLiteOrderRepository_CreateOrder(t *testing.T) {
 	type fields struct {
-		DB *sql.DB
+		DB DBTX
 	}
 	type args struct {
 		order *models.Order
 	}
-	testDb := database.ConnectDB("sqlite3", "testDb.db")
+	testDb := database.ConnectDB("sqlite3", filepath.Join(t.TempDir(), "testDb.db"))
 	tests := []struct {
 		name    string
 		fields  fields
@@ -45,8 +48,8 @@ func TestSQLiteOrderRepository_CreateOrder(t *testing.T) {
 			r := &SQLiteOrderRepository{
 				DB: tt.fields.DB,
 			}
-			if err := r.CreateOrder(tt.args.order); (err != nil) != tt.wantErr {
-				t.Errorf("SQLiteOrderRepository.CreateOrder() error = %v, wantErr %v", err, tt.wantErr)
+			if err := r.CreateOrder(context.Background(), tt.args.order); (err != nil) != tt.wantErr {
+				t.Errorf("SQLiteOrderRepository.CreateOrder(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
@@ -54,13 +57,13 @@ func TestSQLiteOrderRepository_CreateOrder(t *testing.T) {
 
 func TestSQLiteOrderRepository_GetOrderByID(t *testing.T) {
 	type fields struct {
-		DB *sql.DB
+		DB DBTX
 	}
 	type args struct {
 		id    string
 		order *models.Order
 	}
-	testDb := database.ConnectDB("sqlite3", "testDb.db")
+	testDb := database.ConnectDB("sqlite3", filepath.Join(t.TempDir(), "testDb.db"))
 	tests := []struct {
 		name    string
 		fields  fields
@@ -95,16 +98,16 @@ func TestSQLiteOrderRepository_GetOrderByID(t *testing.T) {
 			r := &SQLiteOrderRepository{
 				DB: tt.fields.DB,
 			}
-			if err := r.CreateOrder(tt.args.order); (err != nil) != tt.wantErr {
-				t.Errorf("SQLiteOrderRepository.CreateOrder() error = %v, wantErr %v", err, tt.wantErr)
+			if err := r.CreateOrder(context.Background(), tt.args.order); (err != nil) != tt.wantErr {
+				t.Errorf("SQLiteOrderRepository.CreateOrder(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
 			}
-			got, err := r.GetOrderByID(tt.args.id)
+			got, err := r.GetOrderByID(context.Background(), tt.args.id)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("SQLiteOrderRepository.GetOrderByID() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("SQLiteOrderRepository.GetOrderByID(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("SQLiteOrderRepository.GetOrderByID() = %v, want %v", got, tt.want)
+				t.Errorf("SQLiteOrderRepository.GetOrderByID(context.Background()) = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -112,7 +115,7 @@ func TestSQLiteOrderRepository_GetOrderByID(t *testing.T) {
 
 func TestSQLiteOrderRepository_UpdateOrderStatus(t *testing.T) {
 	type fields struct {
-		DB *sql.DB
+		DB DBTX
 	}
 	type args struct {
 		orderId string
@@ -120,7 +123,7 @@ func TestSQLiteOrderRepository_UpdateOrderStatus(t *testing.T) {
 		order   *models.Order
 	}
 	newOrderId := uuid.NewString()
-	testDb := database.ConnectDB("sqlite3", "testDb.db")
+	testDb := database.ConnectDB("sqlite3", filepath.Join(t.TempDir(), "testDb.db"))
 	tests := []struct {
 		name    string
 		fields  fields
@@ -157,30 +160,165 @@ func TestSQLiteOrderRepository_UpdateOrderStatus(t *testing.T) {
 			r := &SQLiteOrderRepository{
 				DB: tt.fields.DB,
 			}
-			if err := r.CreateOrder(tt.args.order); (err != nil) != tt.wantErr {
-				t.Errorf("SQLiteOrderRepository.CreateOrder() error = %v, wantErr %v", err, tt.wantErr)
+			if err := r.CreateOrder(context.Background(), tt.args.order); (err != nil) != tt.wantErr {
+				t.Errorf("SQLiteOrderRepository.CreateOrder(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
 			}
-			got, err := r.GetOrderByID(tt.args.orderId)
+			got, err := r.GetOrderByID(context.Background(), tt.args.orderId)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("SQLiteOrderRepository.GetOrderByID() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("SQLiteOrderRepository.GetOrderByID(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("1 SQLiteOrderRepository.GetOrderByID() = %v, want %v", got, tt.want)
+				t.Errorf("1 SQLiteOrderRepository.GetOrderByID(context.Background()) = %v, want %v", got, tt.want)
 			}
-			if err := r.UpdateOrderStatus(tt.args.orderId, tt.args.status); (err != nil) != tt.wantErr {
-				t.Errorf("2 SQLiteOrderRepository.UpdateOrderStatus() error = %v, wantErr %v", err, tt.wantErr)
+			if err := r.UpdateOrderStatus(context.Background(), tt.args.orderId, tt.args.status); (err != nil) != tt.wantErr {
+				t.Errorf("2 SQLiteOrderRepository.UpdateOrderStatus(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
 			}
 
-			gotUpdated, err := r.GetOrderByID(tt.args.orderId)
+			gotUpdated, err := r.GetOrderByID(context.Background(), tt.args.orderId)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("3 SQLiteOrderRepository.GetOrderByID() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("3 SQLiteOrderRepository.GetOrderByID(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if !reflect.DeepEqual(gotUpdated.Status, tt.args.status) {
-				t.Errorf("4 SQLiteOrderRepository.GetOrderByID() = %v, want %v", gotUpdated.Status, tt.args.status)
+				t.Errorf("4 SQLiteOrderRepository.GetOrderByID(context.Background()) = %v, want %v", gotUpdated.Status, tt.args.status)
 			}
 
 		})
 	}
 }
+
+func TestSQLiteOrderRepository_UpdateOrderStatusBatch(t *testing.T) {
+	type args struct {
+		orders  []*models.Order
+		missing string
+		status  string
+	}
+	testDb := database.ConnectDB("sqlite3", filepath.Join(t.TempDir(), "testDb.db"))
+	tests := []struct {
+		name string
+		args args
+	}{
+		{
+			name: "one missing order",
+			args: args{
+				orders: []*models.Order{
+					{OrderID: uuid.NewString(), UserID: "testUser", TotalAmount: 10.0, Status: "Pending"},
+					{OrderID: uuid.NewString(), UserID: "testUser", TotalAmount: 20.0, Status: "Pending"},
+				},
+				missing: uuid.NewString(),
+				status:  string(constants.COMPELETED),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &SQLiteOrderRepository{
+				DB: testDb,
+			}
+			for _, order := range tt.args.orders {
+				if err := r.CreateOrder(context.Background(), order); err != nil {
+					t.Fatalf("SQLiteOrderRepository.CreateOrder(context.Background()) error = %v", err)
+				}
+			}
+			orderIds := []string{tt.args.orders[0].OrderID, tt.args.missing, tt.args.orders[1].OrderID}
+			errs := r.UpdateOrderStatusBatch(context.Background(), orderIds, tt.args.status)
+			if len(errs) != len(orderIds) {
+				t.Fatalf("SQLiteOrderRepository.UpdateOrderStatusBatch(context.Background()) returned %v results, want %v", len(errs), len(orderIds))
+			}
+			if errs[0] != nil || errs[2] != nil {
+				t.Errorf("SQLiteOrderRepository.UpdateOrderStatusBatch(context.Background()) errs = %v, want nil for existing orders", errs)
+			}
+			if errs[1] != sql.ErrNoRows {
+				t.Errorf("SQLiteOrderRepository.UpdateOrderStatusBatch(context.Background()) err = %v, want %v for missing order", errs[1], sql.ErrNoRows)
+			}
+			for _, order := range tt.args.orders {
+				got, err := r.GetOrderByID(context.Background(), order.OrderID)
+				if err != nil {
+					t.Fatalf("SQLiteOrderRepository.GetOrderByID(context.Background()) error = %v", err)
+				}
+				if got.Status != tt.args.status {
+					t.Errorf("SQLiteOrderRepository.GetOrderByID(context.Background()) status = %v, want %v", got.Status, tt.args.status)
+				}
+			}
+		})
+	}
+}
+
+func TestSQLiteOrderRepository_ListActiveOrders(t *testing.T) {
+	// Own database, the listing covers the whole table.
+	testDb := database.ConnectDB("sqlite3", filepath.Join(t.TempDir(), "testDb.db"))
+	defer testDb.Close()
+	now := time.Now().UTC().Truncate(time.Second)
+	rows := []struct {
+		id      string
+		status  string
+		created time.Time
+	}{
+		{id: "old-completed", status: "Completed", created: now.Add(-2 * time.Hour)},
+		{id: "old-pending", status: "Pending", created: now.Add(-2 * time.Hour)},
+		{id: "recent-completed", status: "Completed", created: now.Add(-10 * time.Minute)},
+		{id: "a-processing", status: "Processing", created: now},
+		{id: "b-pending", status: "Pending", created: now},
+	}
+	for _, row := range rows {
+		_, err := testDb.Exec(`INSERT INTO orders (order_id, user_id, total_amount, status, created_at) VALUES (?, ?, ?, ?, ?)`,
+			row.id, "testUser", 1.0, row.status, row.created.Format(sqliteTimeFormat))
+		if err != nil {
+			t.Fatalf("insert %v error = %v", row.id, err)
+		}
+	}
+	// Predates created_at, never listed.
+	if _, err := testDb.Exec(`INSERT INTO orders (order_id, user_id, total_amount, status, created_at) VALUES ('legacy', 'testUser', 1.0, 'Pending', NULL)`); err != nil {
+		t.Fatalf("insert legacy error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		since time.Time
+		limit int
+		want  []string
+	}{
+		{
+			name:  "in flight and recent, one page",
+			since: now.Add(-time.Hour),
+			limit: 10,
+			want:  []string{"b-pending", "a-processing", "recent-completed", "old-pending"},
+		},
+		{
+			name:  "paged",
+			since: now.Add(-time.Hour),
+			limit: 1,
+			want:  []string{"b-pending", "a-processing", "recent-completed", "old-pending"},
+		},
+		{
+			name:  "in flight only",
+			since: now.Add(time.Hour),
+			limit: 2,
+			want:  []string{"b-pending", "a-processing", "old-pending"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &SQLiteOrderRepository{DB: testDb}
+			got := []string{}
+			var after *models.Order
+			for {
+				page, err := r.ListActiveOrders(context.Background(), tt.since, after, tt.limit)
+				if err != nil {
+					t.Fatalf("SQLiteOrderRepository.ListActiveOrders(context.Background()) error = %v", err)
+				}
+				for _, order := range page {
+					got = append(got, order.OrderID)
+				}
+				if len(page) < tt.limit {
+					break
+				}
+				after = page[len(page)-1]
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SQLiteOrderRepository.ListActiveOrders(context.Background()) = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
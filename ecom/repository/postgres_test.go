@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+
+	"ecom.com/constants"
+	"ecom.com/database"
+	"github.com/google/uuid"
+)
+
+// defaultTestPostgresDSN matches the postgres service of docker-compose.yml.
+// TEST_POSTGRES_DSN overrides it, in key=value form.
+const defaultTestPostgresDSN = "host=localhost port=5432 user=myuser password=mypassword dbname=mydb sslmode=disable connect_timeout=2"
+
+// newTestPostgres migrates both schemas into a fresh Postgres schema that is
+// dropped after the test. The test is skipped when no server is reachable.
+func newTestPostgres(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		dsn = defaultTestPostgresDSN
+	}
+	admin, err := sql.Open(string(constants.POSTGRES_DRIVER), dsn)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	if err := admin.Ping(); err != nil {
+		t.Skipf("postgres not available: %v", err)
+	}
+
+	schema := "test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("CREATE SCHEMA error = %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("DROP SCHEMA error = %v", err)
+		}
+	})
+
+	db, err := sql.Open(string(constants.POSTGRES_DRIVER), dsn+" search_path="+schema)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for _, name := range []constants.SchemaName{constants.ORDERS_SCHEMA, constants.METRICS_SCHEMA} {
+		m, err := database.NewMigrator(db, string(constants.POSTGRES_DRIVER), name)
+		if err != nil {
+			t.Fatalf("NewMigrator(%v) error = %v", name, err)
+		}
+		if _, err := m.Up(); err != nil {
+			t.Fatalf("Migrator.Up(%v) error = %v", name, err)
+		}
+	}
+	return db
+}
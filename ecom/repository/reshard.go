@@ -0,0 +1,226 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"ecom.com/constants"
+)
+
+// ReshardOptions tune Reshard.
+type ReshardOptions struct {
+	Drain     []string // shards to empty, they are left out of the ring
+	DryRun    bool     // count the orders that would move, move nothing
+	BatchSize int      // orders read per query
+}
+
+// ReshardStats count orders, live and archived. A moved order is checked
+// again on its new shard when that is scanned later.
+type ReshardStats struct {
+	Checked int
+	Moved   int
+}
+
+// reshardTables are the order tables Reshard moves, each with its items.
+var reshardTables = []struct{ orders, items string }{
+	{orders: "orders", items: "items"},
+	{orders: "orders_archive", items: "items_archive"},
+}
+
+// Reshard moves every order with its items to the shard the ring of names,
+// without the drained ones, places it on. Run it while no server writes to
+// the shards. Every order is copied and then deleted in two transactions,
+// an order a failed run left on both shards is deleted from the source by
+// the next run.
+func Reshard(ctx context.Context, driver string, names []string, dbs []DBTX, opts ReshardOptions) (ReshardStats, error) {
+	stats := ReshardStats{}
+	if len(names) != len(dbs) {
+		return stats, fmt.Errorf("%v shard names for %v DBs", len(names), len(dbs))
+	}
+	drain := map[string]bool{}
+	for _, name := range opts.Drain {
+		drain[name] = true
+	}
+	active, activeNames := []int{}, []string{}
+	for i, name := range names {
+		if !drain[name] {
+			active = append(active, i)
+			activeNames = append(activeNames, name)
+		}
+	}
+	if len(active) == 0 {
+		return stats, fmt.Errorf("no shard left after draining %v", opts.Drain)
+	}
+	if len(active)+len(drain) != len(names) {
+		return stats, fmt.Errorf("drained shards %v are not all configured", opts.Drain)
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	ring := newRing(activeNames)
+
+	for source, db := range dbs {
+		for _, tables := range reshardTables {
+			after := ""
+			for {
+				page, err := pageOrderOwners(ctx, driver, db, tables.orders, after, opts.BatchSize)
+				if err != nil {
+					return stats, fmt.Errorf("%v of shard %v: %w", tables.orders, names[source], err)
+				}
+				for _, owner := range page {
+					stats.Checked++
+					target := active[ring.locate(placementKey(owner.orderID, owner.userID))]
+					if target == source {
+						continue
+					}
+					stats.Moved++
+					if opts.DryRun {
+						continue
+					}
+					if err := moveOrderRows(ctx, driver, db, dbs[target], tables.orders, tables.items, owner.orderID); err != nil {
+						return stats, fmt.Errorf("moving order %v from shard %v to %v: %w", owner.orderID, names[source], names[target], err)
+					}
+				}
+				if len(page) < opts.BatchSize {
+					break
+				}
+				after = page[len(page)-1].orderID
+			}
+		}
+	}
+	return stats, nil
+}
+
+type orderOwner struct {
+	orderID string
+	userID  string
+}
+
+func pageOrderOwners(ctx context.Context, driver string, db DBTX, table string, after string, limit int) ([]orderOwner, error) {
+	query := fmt.Sprintf(`SELECT order_id, user_id FROM %v WHERE order_id > %v ORDER BY order_id LIMIT %v`,
+		table, placeholder(driver, 1), placeholder(driver, 2))
+	rows, err := db.QueryContext(ctx, query, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	page := []orderOwner{}
+	for rows.Next() {
+		var owner orderOwner
+		if err := rows.Scan(&owner.orderID, &owner.userID); err != nil {
+			return nil, err
+		}
+		page = append(page, owner)
+	}
+	return page, rows.Err()
+}
+
+// moveOrderRows copies the order and its items to dst unless dst has it
+// already, then deletes them from src.
+func moveOrderRows(ctx context.Context, driver string, src DBTX, dst DBTX, ordersTable string, itemsTable string, orderID string) error {
+	where := "WHERE order_id = " + placeholder(driver, 1)
+	err := inTx(ctx, dst, func(tx DBTX) error {
+		var n int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+ordersTable+" "+where, orderID).Scan(&n); err != nil || n > 0 {
+			return err
+		}
+		for _, table := range []string{ordersTable, itemsTable} {
+			if err := copyRows(ctx, driver, src, tx, table, where, orderID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return inTx(ctx, src, func(tx DBTX) error {
+		for _, table := range []string{itemsTable, ordersTable} {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" "+where, orderID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// copyRows copies the rows of table that match where, all columns as they
+// are. TIMESTAMP columns of SQLite are written back in the format of
+// CURRENT_TIMESTAMP so they still compare as text.
+func copyRows(ctx context.Context, driver string, src DBTX, dst DBTX, table string, where string, args ...any) error {
+	rows, err := src.QueryContext(ctx, "SELECT * FROM "+table+" "+where, args...)
+	if err != nil {
+		return err
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return err
+	}
+	var copied [][]any
+	for rows.Next() {
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			rows.Close()
+			return err
+		}
+		for i, value := range values {
+			switch v := value.(type) {
+			case []byte:
+				values[i] = string(v)
+			case time.Time:
+				if constants.DriverName(driver) == constants.SQLITE_DRIVER {
+					values[i] = v.UTC().Format(sqliteTimeFormat)
+				}
+			}
+		}
+		copied = append(copied, values)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	placeholders := make([]string, len(columns))
+	for i := range placeholders {
+		placeholders[i] = placeholder(driver, i+1)
+	}
+	insert := fmt.Sprintf("INSERT INTO %v (%v) VALUES (%v)", table, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+	for _, values := range copied {
+		if _, err := dst.ExecContext(ctx, insert, values...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inTx runs fn in a transaction on db and commits if it returns nil.
+func inTx(ctx context.Context, db DBTX, fn func(tx DBTX) error) error {
+	beginner, ok := db.(txBeginner)
+	if !ok {
+		return fmt.Errorf("cannot begin a transaction on %T", db)
+	}
+	tx, err := beginner.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// placeholder is the nth query parameter of driver, counting from 1.
+func placeholder(driver string, n int) string {
+	if constants.DriverName(driver) == constants.POSTGRES_DRIVER {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
@@ -0,0 +1,435 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"ecom.com/errors"
+	"ecom.com/models"
+	"github.com/google/uuid"
+)
+
+// NewOrderID returns a new order id that starts with the shard key of
+// userID, so a sharded DB finds the order without asking every shard.
+func NewOrderID(userID string) string {
+	return fmt.Sprintf("%08x_%v", shardKey(userID), uuid.NewString())
+}
+
+// shardKey places a user on the ring, all orders of a user share a shard.
+// Shard names go through it too, FNV spreads similar short strings badly.
+func shardKey(userID string) uint32 {
+	sum := sha256.Sum256([]byte(userID))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// orderShardKey returns the shard key in orderID, ok is false for ids from
+// before NewOrderID.
+func orderShardKey(orderID string) (uint32, bool) {
+	if len(orderID) < 9 || orderID[8] != '_' {
+		return 0, false
+	}
+	key, err := strconv.ParseUint(orderID[:8], 16, 32)
+	return uint32(key), err == nil
+}
+
+// placementKey is the shard key of an order, the one in its id or, for ids
+// from before NewOrderID, the one of its user.
+func placementKey(orderID string, userID string) uint32 {
+	if key, ok := orderShardKey(orderID); ok {
+		return key
+	}
+	return shardKey(userID)
+}
+
+// ringReplicas is the number of points per shard, more spread the keys more
+// evenly.
+const ringReplicas = 100
+
+type ringPoint struct {
+	hash  uint32
+	shard int
+}
+
+// ring is a consistent hash of shard names. Adding a shard only moves the
+// keys it takes over, about 1/n of them.
+type ring struct {
+	points []ringPoint
+}
+
+func newRing(names []string) *ring {
+	r := &ring{}
+	for shard, name := range names {
+		for i := 0; i < ringReplicas; i++ {
+			r.points = append(r.points, ringPoint{hash: shardKey(name + "#" + strconv.Itoa(i)), shard: shard})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i].hash < r.points[j].hash })
+	return r
+}
+
+// locate returns the index of the shard that owns key.
+func (r *ring) locate(key uint32) int {
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= key })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].shard
+}
+
+// ShardRouter spreads orders and their items over several DBs of the same
+// driver by a consistent hash of the user id. Orders with ids from before
+// NewOrderID are looked up on every shard.
+type ShardRouter struct {
+	ring   *ring
+	driver string
+	dbs    []DBTX
+	shards []Repositories
+	// Set inside a unit of work, all calls then go to one transaction.
+	tx *shardTx
+}
+
+// NewShardRouter places shards by name, renaming one moves its orders.
+func NewShardRouter(driver string, names []string, dbs []DBTX) (*ShardRouter, error) {
+	if len(names) == 0 || len(names) != len(dbs) {
+		return nil, fmt.Errorf("%v shard names for %v DBs", len(names), len(dbs))
+	}
+	seen := map[string]bool{}
+	r := &ShardRouter{ring: newRing(names), driver: driver, dbs: dbs}
+	for i, name := range names {
+		if name == "" || seen[name] {
+			return nil, fmt.Errorf("shard names must be unique and not empty, got %q", name)
+		}
+		seen[name] = true
+		repos, err := newRepositories(driver, dbs[i])
+		if err != nil {
+			return nil, err
+		}
+		r.shards = append(r.shards, repos)
+	}
+	return r, nil
+}
+
+// shard returns the repositories of shard i, bound to the transaction
+// inside a unit of work.
+func (r *ShardRouter) shard(i int) (Repositories, error) {
+	if r.tx != nil {
+		return r.tx.bind(i)
+	}
+	return r.shards[i], nil
+}
+
+// find returns the shard that holds orderID, sql.ErrNoRows if none does.
+// Ids with a shard key are not looked up.
+func (r *ShardRouter) find(ctx context.Context, orderID string) (int, error) {
+	if key, ok := orderShardKey(orderID); ok {
+		return r.ring.locate(key), nil
+	}
+	if r.tx != nil && r.tx.tx != nil {
+		// A unit of work never leaves its shard.
+		return r.tx.shard, nil
+	}
+	return r.probe(ctx, orderID)
+}
+
+// probe asks every shard for orderID, outside of any unit of work.
+func (r *ShardRouter) probe(ctx context.Context, orderID string) (int, error) {
+	for i, repos := range r.shards {
+		_, err := repos.Orders.GetOrderByID(ctx, orderID)
+		if err == nil {
+			return i, nil
+		}
+		if err != sql.ErrNoRows {
+			return 0, err
+		}
+	}
+	return 0, sql.ErrNoRows
+}
+
+// CreateOrder checks every shard for ids from before NewOrderID, their
+// users may place a duplicate on another shard than the original.
+func (r *ShardRouter) CreateOrder(ctx context.Context, order *models.Order) error {
+	if _, ok := orderShardKey(order.OrderID); !ok {
+		_, err := r.probe(ctx, order.OrderID)
+		if err == nil {
+			return fmt.Errorf("order %v: %w", order.OrderID, errors.ErrDuplicateKey)
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+	}
+	repos, err := r.shard(r.ring.locate(placementKey(order.OrderID, order.UserID)))
+	if err != nil {
+		return err
+	}
+	return repos.Orders.CreateOrder(ctx, order)
+}
+
+// UpdateOrderStatus does nothing for an unknown order, like the UPDATE.
+func (r *ShardRouter) UpdateOrderStatus(ctx context.Context, orderId string, status string) error {
+	shard, err := r.find(ctx, orderId)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	repos, err := r.shard(shard)
+	if err != nil {
+		return err
+	}
+	return repos.Orders.UpdateOrderStatus(ctx, orderId, status)
+}
+
+// UpdateOrderStatusBatch runs one batch per shard.
+func (r *ShardRouter) UpdateOrderStatusBatch(ctx context.Context, orderIds []string, status string) []error {
+	errs := make([]error, len(orderIds))
+	byShard := map[int][]int{}
+	for i, orderId := range orderIds {
+		shard, err := r.find(ctx, orderId)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		byShard[shard] = append(byShard[shard], i)
+	}
+	for shard, indexes := range byShard {
+		ids := make([]string, len(indexes))
+		for j, i := range indexes {
+			ids[j] = orderIds[i]
+		}
+		repos, err := r.shard(shard)
+		if err != nil {
+			for _, i := range indexes {
+				errs[i] = err
+			}
+			continue
+		}
+		for j, err := range repos.Orders.UpdateOrderStatusBatch(ctx, ids, status) {
+			errs[indexes[j]] = err
+		}
+	}
+	return errs
+}
+
+func (r *ShardRouter) GetOrderByID(ctx context.Context, id string) (*models.Order, error) {
+	shard, err := r.find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	repos, err := r.shard(shard)
+	if err != nil {
+		return nil, err
+	}
+	return repos.Orders.GetOrderByID(ctx, id)
+}
+
+// ListActiveOrders merges the pages of all shards.
+func (r *ShardRouter) ListActiveOrders(ctx context.Context, since time.Time, after *models.Order, limit int) ([]*models.Order, error) {
+	orders := []*models.Order{}
+	for i := range r.shards {
+		repos, err := r.shard(i)
+		if err != nil {
+			return nil, err
+		}
+		page, err := repos.Orders.ListActiveOrders(ctx, since, after, limit)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, page...)
+	}
+	sort.Slice(orders, func(i, j int) bool { return orderedBefore(orders[i], orders[j]) })
+	if len(orders) > limit {
+		orders = orders[:limit]
+	}
+	return orders, nil
+}
+
+// CreateItem needs the order to exist, like the foreign key on items.
+func (r *ShardRouter) CreateItem(ctx context.Context, item *models.Item) error {
+	shard, err := r.find(ctx, item.OrderID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("item %v: order %v does not exist", item.ItemID, item.OrderID)
+	}
+	if err != nil {
+		return err
+	}
+	repos, err := r.shard(shard)
+	if err != nil {
+		return err
+	}
+	return repos.Items.CreateItem(ctx, item)
+}
+
+// GetItem asks every shard, item ids say nothing about their order.
+func (r *ShardRouter) GetItem(ctx context.Context, id string) (*models.Item, error) {
+	for i := range r.shards {
+		repos, err := r.shard(i)
+		if err != nil {
+			return nil, err
+		}
+		item, err := repos.Items.GetItem(ctx, id)
+		if err != sql.ErrNoRows {
+			return item, err
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *ShardRouter) GetItemsByOrderId(ctx context.Context, id string) ([]models.Item, error) {
+	shard, err := r.find(ctx, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	repos, err := r.shard(shard)
+	if err != nil {
+		return nil, err
+	}
+	return repos.Items.GetItemsByOrderId(ctx, id)
+}
+
+func (r *ShardRouter) RemoveItem(ctx context.Context, itemId string, orderId string) error {
+	shard, err := r.find(ctx, orderId)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	repos, err := r.shard(shard)
+	if err != nil {
+		return err
+	}
+	return repos.Items.RemoveItem(ctx, itemId, orderId)
+}
+
+// shardTx is the transaction of a sharded unit of work. It begins on the
+// shard of the first call, an order and its items always share one.
+type shardTx struct {
+	ctx    context.Context
+	router *ShardRouter
+	shard  int
+	tx     *sql.Tx
+	repos  Repositories
+}
+
+func (t *shardTx) bind(shard int) (Repositories, error) {
+	if t.tx != nil {
+		if shard != t.shard {
+			return Repositories{}, fmt.Errorf("unit of work on shard %v cannot reach shard %v", t.shard, shard)
+		}
+		return t.repos, nil
+	}
+	tx, err := t.router.dbs[shard].(txBeginner).BeginTx(t.ctx, nil)
+	if err != nil {
+		return Repositories{}, err
+	}
+	repos, err := newRepositories(t.router.driver, tx)
+	if err != nil {
+		tx.Rollback()
+		return Repositories{}, err
+	}
+	t.shard, t.tx, t.repos = shard, tx, repos
+	return repos, nil
+}
+
+type ShardedUnitOfWork struct {
+	router *ShardRouter
+}
+
+// NewShardedUnitOfWork runs units of work on the shards of router, each
+// on a single shard.
+func NewShardedUnitOfWork(router *ShardRouter) (UnitOfWorkI, error) {
+	for _, db := range router.dbs {
+		if _, ok := db.(txBeginner); !ok {
+			return nil, fmt.Errorf("cannot begin a transaction on %T", db)
+		}
+	}
+	return &ShardedUnitOfWork{router: router}, nil
+}
+
+func (u *ShardedUnitOfWork) Do(ctx context.Context, fn func(repos Repositories) error) error {
+	tx := &shardTx{ctx: ctx, router: u.router}
+	router := &ShardRouter{ring: u.router.ring, driver: u.router.driver, dbs: u.router.dbs, shards: u.router.shards, tx: tx}
+	err := fn(Repositories{Orders: router, Items: router})
+	if tx.tx == nil {
+		return err
+	}
+	if err != nil {
+		tx.tx.Rollback()
+		return err
+	}
+	return tx.tx.Commit()
+}
+
+// ShardedArchiveRepository keeps archived orders on the shard of their user.
+type ShardedArchiveRepository struct {
+	router *ShardRouter
+	shards []ArchiveRepositoryI
+}
+
+func NewShardedArchiveRepository(router *ShardRouter) (ArchiveRepositoryI, error) {
+	r := &ShardedArchiveRepository{router: router}
+	for _, db := range router.dbs {
+		repo, err := NewArchiveRepository(router.driver, db)
+		if err != nil {
+			return nil, err
+		}
+		r.shards = append(r.shards, repo)
+	}
+	return r, nil
+}
+
+// ArchiveOrders archives shard by shard until limit orders are selected.
+func (r *ShardedArchiveRepository) ArchiveOrders(ctx context.Context, before time.Time, limit int) ([]string, int, error) {
+	archived := []string{}
+	selected := 0
+	for _, shard := range r.shards {
+		if selected >= limit {
+			break
+		}
+		ids, n, err := shard.ArchiveOrders(ctx, before, limit-selected)
+		archived = append(archived, ids...)
+		selected += n
+		if err != nil {
+			return archived, selected, err
+		}
+	}
+	return archived, selected, nil
+}
+
+func (r *ShardedArchiveRepository) GetArchivedOrder(ctx context.Context, id string) (*models.Order, []models.Item, error) {
+	for _, shard := range r.candidates(id) {
+		order, items, err := shard.GetArchivedOrder(ctx, id)
+		if err != sql.ErrNoRows {
+			return order, items, err
+		}
+	}
+	return nil, nil, sql.ErrNoRows
+}
+
+func (r *ShardedArchiveRepository) RestoreOrder(ctx context.Context, id string) error {
+	for _, shard := range r.candidates(id) {
+		if err := shard.RestoreOrder(ctx, id); err != sql.ErrNoRows {
+			return err
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// candidates are the shards that can hold orderID.
+func (r *ShardedArchiveRepository) candidates(orderID string) []ArchiveRepositoryI {
+	if key, ok := orderShardKey(orderID); ok {
+		shard := r.router.ring.locate(key)
+		return r.shards[shard : shard+1]
+	}
+	return r.shards
+}
@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"ecom.com/constants"
+	"ecom.com/database"
+	"ecom.com/models"
+	"github.com/google/uuid"
+)
+
+func TestNewOrderID(t *testing.T) {
+	tests := []struct {
+		name    string
+		orderID string
+		wantKey uint32
+		wantOk  bool
+	}{
+		{name: "new id", orderID: NewOrderID("user-1"), wantKey: shardKey("user-1"), wantOk: true},
+		{name: "uuid", orderID: uuid.NewString()},
+		{name: "not hex", orderID: "zzzzzzzz_order"},
+		{name: "short", orderID: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, ok := orderShardKey(tt.orderID)
+			if ok != tt.wantOk || (ok && key != tt.wantKey) {
+				t.Errorf("orderShardKey(%v) = %v, %v, want %v, %v", tt.orderID, key, ok, tt.wantKey, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestRing_Locate(t *testing.T) {
+	const keys = 10000
+	three := newRing([]string{"a", "b", "c"})
+	four := newRing([]string{"a", "b", "c", "d"})
+	counts := make([]int, 3)
+	moved := 0
+	for i := 0; i < keys; i++ {
+		key := shardKey("user-" + strconv.Itoa(i))
+		before, after := three.locate(key), four.locate(key)
+		counts[before]++
+		if before != after {
+			moved++
+			if after != 3 {
+				t.Fatalf("key %v moved from shard %v to %v, want only moves to the new shard", key, before, after)
+			}
+		}
+	}
+	for shard, count := range counts {
+		if count < keys/5 || count > keys/2 {
+			t.Errorf("shard %v owns %v of %v keys, want about a third", shard, count, keys)
+		}
+	}
+	if moved < keys/8 || moved > keys/2 {
+		t.Errorf("adding a fourth shard moved %v of %v keys, want about a quarter", moved, keys)
+	}
+}
+
+func TestReshard(t *testing.T) {
+	ctx := context.Background()
+	driver, dir := string(constants.SQLITE_DRIVER), t.TempDir()
+	names, dbs := []string{"a", "b", "c"}, []DBTX{}
+	for _, name := range names {
+		db := database.ConnectDB(driver, filepath.Join(dir, name+".db"))
+		t.Cleanup(func() { db.Close() })
+		dbs = append(dbs, db)
+	}
+	countOrders := func(db DBTX) int {
+		var n int
+		if err := db.QueryRowContext(ctx, "SELECT (SELECT COUNT(*) FROM orders) + (SELECT COUNT(*) FROM orders_archive)").Scan(&n); err != nil {
+			t.Fatalf("counting orders error = %v", err)
+		}
+		return n
+	}
+
+	// Orders are created on two shards, a third is added later.
+	router, err := NewShardRouter(driver, names[:2], dbs[:2])
+	if err != nil {
+		t.Fatalf("NewShardRouter() error = %v", err)
+	}
+	const orders = 60
+	ids := []string{}
+	for i := 0; i < orders; i++ {
+		user := fmt.Sprintf("user-%v", i)
+		order := &models.Order{OrderID: NewOrderID(user), UserID: user, TotalAmount: 10, Status: string(constants.COMPELETED)}
+		if i%3 == 0 {
+			// From before order ids had shard keys.
+			order.OrderID = uuid.NewString()
+		}
+		if err := router.CreateOrder(ctx, order); err != nil {
+			t.Fatalf("CreateOrder() error = %v", err)
+		}
+		if err := router.CreateItem(ctx, &models.Item{ItemID: "item1", OrderID: order.OrderID, Amount: 10}); err != nil {
+			t.Fatalf("CreateItem() error = %v", err)
+		}
+		ids = append(ids, order.OrderID)
+	}
+	archive, _ := NewShardedArchiveRepository(router)
+	if archived, _, err := archive.ArchiveOrders(ctx, time.Now().Add(time.Hour), orders/2); err != nil || len(archived) != orders/2 {
+		t.Fatalf("ArchiveOrders() = %v ids, %v, want %v", len(archived), err, orders/2)
+	}
+
+	tests := []struct {
+		name        string
+		opts        ReshardOptions
+		wantMoved   func(moved int) bool
+		wantOnShard []bool // shards that hold orders afterwards
+	}{
+		{
+			name:        "dry run",
+			opts:        ReshardOptions{DryRun: true},
+			wantMoved:   func(moved int) bool { return moved > 0 },
+			wantOnShard: []bool{true, true, false},
+		},
+		{
+			name:        "add a shard",
+			opts:        ReshardOptions{BatchSize: 7},
+			wantMoved:   func(moved int) bool { return moved > 0 },
+			wantOnShard: []bool{true, true, true},
+		},
+		{
+			name:        "nothing left to move",
+			wantMoved:   func(moved int) bool { return moved == 0 },
+			wantOnShard: []bool{true, true, true},
+		},
+		{
+			name:        "drain a shard",
+			opts:        ReshardOptions{Drain: []string{"a"}},
+			wantMoved:   func(moved int) bool { return moved > 0 },
+			wantOnShard: []bool{false, true, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, err := Reshard(ctx, driver, names, dbs, tt.opts)
+			if err != nil || !tt.wantMoved(stats.Moved) {
+				t.Fatalf("Reshard() = %+v, %v", stats, err)
+			}
+			total := 0
+			for i, db := range dbs {
+				n := countOrders(db)
+				total += n
+				if (n > 0) != tt.wantOnShard[i] {
+					t.Errorf("shard %v holds %v orders, want some = %v", names[i], n, tt.wantOnShard[i])
+				}
+			}
+			if total != orders {
+				t.Errorf("shards hold %v orders, want %v", total, orders)
+			}
+
+			ringNames, ringDbs := names, dbs
+			if len(tt.opts.Drain) > 0 {
+				ringNames, ringDbs = names[1:], dbs[1:]
+			} else if tt.opts.DryRun {
+				ringNames, ringDbs = names[:2], dbs[:2]
+			}
+			router, err := NewShardRouter(driver, ringNames, ringDbs)
+			if err != nil {
+				t.Fatalf("NewShardRouter() error = %v", err)
+			}
+			archive, _ := NewShardedArchiveRepository(router)
+			for _, id := range ids {
+				if _, err := router.GetOrderByID(ctx, id); err == nil {
+					if items, err := router.GetItemsByOrderId(ctx, id); err != nil || len(items) != 1 {
+						t.Errorf("GetItemsByOrderId(%v) = %v, %v, want its item", id, items, err)
+					}
+					continue
+				}
+				if _, items, err := archive.GetArchivedOrder(ctx, id); err != nil || len(items) != 1 {
+					t.Errorf("order %v is neither live nor archived with its item: %v", id, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ecom.com/constants"
+	"ecom.com/database"
+	"ecom.com/models"
+)
+
+// TestSQLiteLoad runs the write pattern of 1,000 concurrent orders, 100
+// workers at a time, against SQLite files tuned like config.yaml.
+func TestSQLiteLoad(t *testing.T) {
+	if testing.Short() {
+		t.Skip("load test")
+	}
+	const orders, workers = 1000, 100
+	driver := string(constants.SQLITE_DRIVER)
+	opts := database.SQLiteOptions{WAL: true, BusyTimeout: 5 * time.Second, SingleWriter: true, ReadConns: 8}
+	dir := t.TempDir()
+	db := database.ConnectPool(driver, filepath.Join(dir, "orders.db"), constants.ORDERS_SCHEMA, true, opts)
+	defer db.Close()
+	metricDb := database.ConnectPool(driver, filepath.Join(dir, "metrics.db"), constants.METRICS_SCHEMA, true, opts)
+	defer metricDb.Close()
+
+	ctx := context.Background()
+	orderRepo, _ := NewOrderRepository(driver, db)
+	metricRepo, _ := NewMetricRepository(driver, metricDb)
+	ledgerRepo, _ := NewLedgerRepository(driver, metricDb)
+	uow, err := NewUnitOfWork(driver, db)
+	if err != nil {
+		t.Fatalf("NewUnitOfWork() error = %v", err)
+	}
+
+	var failures int64
+	fail := func(step string, id string, err error) {
+		if atomic.AddInt64(&failures, 1) <= 5 {
+			t.Errorf("%v(%v) error = %v", step, id, err)
+		}
+	}
+	ids := make(chan string)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ids {
+				err := uow.Do(ctx, func(repos Repositories) error {
+					if err := repos.Orders.CreateOrder(ctx, &models.Order{OrderID: id, UserID: "load", TotalAmount: 10, Status: "Pending"}); err != nil {
+						return err
+					}
+					for _, item := range []string{"item1", "item2"} {
+						if err := repos.Items.CreateItem(ctx, &models.Item{ItemID: item, OrderID: id, Amount: 5}); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+				if err != nil {
+					fail("Do", id, err)
+					continue
+				}
+				if err := orderRepo.UpdateOrderStatus(ctx, id, string(constants.PROCESSING)); err != nil {
+					fail("UpdateOrderStatus", id, err)
+				}
+				if _, err := orderRepo.GetOrderByID(ctx, id); err != nil {
+					fail("GetOrderByID", id, err)
+				}
+				if errs := orderRepo.UpdateOrderStatusBatch(ctx, []string{id}, string(constants.COMPELETED)); errs[0] != nil {
+					fail("UpdateOrderStatusBatch", id, errs[0])
+				}
+				if errs := ledgerRepo.MarkProcessed(ctx, []*models.ProcessedItem{{ItemID: id, Stage: "load"}}); errs[0] != nil {
+					fail("MarkProcessed", id, errs[0])
+				}
+				if errs := metricRepo.CreateMetricBatch(ctx, []*models.Metric{{OrderId: id, Duration: 1, MetricName: "load"}}); errs[0] != nil {
+					fail("CreateMetricBatch", id, errs[0])
+				}
+			}
+		}()
+	}
+	for i := 0; i < orders; i++ {
+		ids <- strconv.Itoa(i)
+	}
+	close(ids)
+	wg.Wait()
+	t.Logf("%v orders by %v workers in %v", orders, workers, time.Since(start))
+
+	if failures > 0 {
+		t.Fatalf("%v failed calls", failures)
+	}
+	if count, err := metricRepo.GetMetricCount(ctx, "load"); err != nil || *count != orders {
+		t.Errorf("GetMetricCount() = %v, %v, want %v", count, err, orders)
+	}
+	active, err := orderRepo.ListActiveOrders(ctx, time.Now().Add(time.Hour), nil, orders)
+	if err != nil || len(active) != 0 {
+		t.Errorf("ListActiveOrders() = %v orders, %v, want none in flight", len(active), err)
+	}
+}
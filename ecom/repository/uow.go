@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"ecom.com/breaker"
+)
+
+// DBTX is the part of *sql.DB and *sql.Tx the repositories use, so the same
+// repository works on its own or inside a transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// Repositories are the repositories of one DB bound to a transaction.
+type Repositories struct {
+	Orders OrderRepositoryI
+	Items  ItemRepositoryI
+}
+
+// UnitOfWorkI runs fn in a transaction. Everything fn writes through repos is
+// committed if it returns nil and rolled back otherwise, also when ctx is
+// done first.
+type UnitOfWorkI interface {
+	Do(ctx context.Context, fn func(repos Repositories) error) error
+}
+
+type SQLUnitOfWork struct {
+	DB     DBTX
+	Driver string
+}
+
+// NewUnitOfWork checks the driver up front, Do only fails on the DB. db has
+// to begin transactions, like a *sql.DB.
+func NewUnitOfWork(driver string, db DBTX) (UnitOfWorkI, error) {
+	if _, err := newRepositories(driver, db); err != nil {
+		return nil, err
+	}
+	if _, ok := db.(txBeginner); !ok {
+		return nil, fmt.Errorf("cannot begin a transaction on %T", db)
+	}
+	return &SQLUnitOfWork{DB: db, Driver: driver}, nil
+}
+
+func (u *SQLUnitOfWork) Do(ctx context.Context, fn func(repos Repositories) error) error {
+	tx, err := u.DB.(txBeginner).BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	repos, err := newRepositories(u.Driver, tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := fn(repos); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func newRepositories(driver string, db DBTX) (Repositories, error) {
+	orders, err := NewOrderRepository(driver, db)
+	if err != nil {
+		return Repositories{}, err
+	}
+	items, err := NewItemRepository(driver, db)
+	if err != nil {
+		return Repositories{}, err
+	}
+	return Repositories{Orders: orders, Items: items}, nil
+}
+
+// BreakerUnitOfWork counts a whole unit of work as one call of the breaker
+// guarding its DB.
+type BreakerUnitOfWork struct {
+	uow     UnitOfWorkI
+	breaker *breaker.CircuitBreaker
+}
+
+func NewBreakerUnitOfWork(uow UnitOfWorkI, b *breaker.CircuitBreaker) UnitOfWorkI {
+	return &BreakerUnitOfWork{uow: uow, breaker: b}
+}
+
+func (u *BreakerUnitOfWork) Do(ctx context.Context, fn func(repos Repositories) error) error {
+	return u.breaker.Execute(func() error {
+		return u.uow.Do(ctx, fn)
+	})
+}
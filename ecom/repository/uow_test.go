@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	stderrors "errors"
+	"testing"
+
+	"ecom.com/models"
+	"github.com/google/uuid"
+)
+
+func TestNewUnitOfWork_UnknownDriver(t *testing.T) {
+	if _, err := NewUnitOfWork("mysql", nil); err == nil {
+		t.Errorf("NewUnitOfWork(mysql) error = nil, want an error")
+	}
+}
+
+// testUnitOfWork checks that an order and its items are stored together or
+// not at all. orders and items read outside of uow.
+func testUnitOfWork(t *testing.T, uow UnitOfWorkI, orders OrderRepositoryI, items ItemRepositoryI) {
+	ctx := context.Background()
+	errItem := stderrors.New("item rejected")
+
+	tests := []struct {
+		name      string
+		itemIDs   []string
+		fail      error // returned by fn after the writes
+		wantErr   bool
+		wantItems int
+	}{
+		{name: "commit", itemIDs: []string{"i1", "i2"}, wantItems: 2},
+		{name: "duplicate item rolls back the order", itemIDs: []string{"i1", "i1"}, wantErr: true},
+		{name: "error from fn rolls back everything", itemIDs: []string{"i1"}, fail: errItem, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orderID := uuid.NewString()
+			err := uow.Do(ctx, func(repos Repositories) error {
+				if err := repos.Orders.CreateOrder(ctx, &models.Order{OrderID: orderID, UserID: "testUser", TotalAmount: 1, Status: "Pending"}); err != nil {
+					return err
+				}
+				for _, itemID := range tt.itemIDs {
+					if err := repos.Items.CreateItem(ctx, &models.Item{ItemID: itemID, OrderID: orderID}); err != nil {
+						return err
+					}
+				}
+				// Batches join the transaction instead of committing on their own.
+				if errs := repos.Orders.UpdateOrderStatusBatch(ctx, []string{orderID}, "Processing"); errs[0] != nil {
+					return errs[0]
+				}
+				return tt.fail
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Do() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.fail != nil && err != tt.fail {
+				t.Errorf("Do() error = %v, want %v", err, tt.fail)
+			}
+
+			order, err := orders.GetOrderByID(ctx, orderID)
+			if tt.wantErr {
+				if err != sql.ErrNoRows {
+					t.Errorf("GetOrderByID() after rollback = %v, %v, want %v", order, err, sql.ErrNoRows)
+				}
+			} else if err != nil || order.Status != "Processing" {
+				t.Errorf("GetOrderByID() after commit = %v, %v, want status Processing", order, err)
+			}
+			stored, err := items.GetItemsByOrderId(ctx, orderID)
+			if err != nil || len(stored) != tt.wantItems {
+				t.Errorf("GetItemsByOrderId() = %v, %v, want %v items", stored, err, tt.wantItems)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"ecom.com/config"
+	"ecom.com/constants"
+	"ecom.com/database"
+	"ecom.com/repository"
+)
+
+const reshardUsage = `usage: go run main.go reshard [-drain name,...] [-dry-run] [-batch n]
+  moves every order to the shard database.shards places it on, run it with the servers stopped
+  -drain    empty these shards, remove them from database.shards afterwards
+  -dry-run  only count the orders that would move`
+
+// runReshard implements the reshard command against the shards in
+// appConfig.
+func runReshard(appConfig config.Config, args []string) error {
+	flags := flag.NewFlagSet("reshard", flag.ContinueOnError)
+	drain := flags.String("drain", "", "comma separated shards to empty")
+	dryRun := flags.Bool("dry-run", false, "count the orders that would move")
+	batch := flags.Int("batch", 500, "orders read per query")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(appConfig.Database.Shards) == 0 {
+		return errors.New("database.shards is empty, there is nothing to reshard\n" + reshardUsage)
+	}
+	names, dbs, err := openOrderDBs(appConfig)
+	if err != nil {
+		return err
+	}
+	defer closeAll(dbs)
+
+	opts := repository.ReshardOptions{DryRun: *dryRun, BatchSize: *batch}
+	if *drain != "" {
+		opts.Drain = strings.Split(*drain, ",")
+	}
+	stats, err := repository.Reshard(context.Background(), appConfig.Database.Driver, names, asDBTX(dbs), opts)
+	verb := "moved"
+	if *dryRun {
+		verb = "would move"
+	}
+	fmt.Printf("orders: checked %v, %v %v\n", stats.Checked, verb, stats.Moved)
+	return err
+}
+
+// openOrderDBs opens the orders DB, or every shard of it, and checks that
+// they are migrated. names is empty without shards.
+func openOrderDBs(appConfig config.Config) ([]string, []*sql.DB, error) {
+	type target struct{ name, dsn string }
+	targets := []target{{dsn: appConfig.Database.DSN}}
+	if shards := appConfig.Database.Shards; len(shards) > 0 {
+		targets = targets[:0]
+		for _, shard := range shards {
+			targets = append(targets, target{name: shard.Name, dsn: shard.DSN})
+		}
+	}
+	driver := appConfig.Database.Driver
+	var names []string
+	var dbs []*sql.DB
+	for _, target := range targets {
+		db, err := database.Open(driver, target.dsn)
+		if err == nil {
+			dbs = append(dbs, db)
+			err = checkSchema(db, driver)
+		}
+		if err != nil {
+			closeAll(dbs)
+			if target.name != "" {
+				return nil, nil, fmt.Errorf("shard %v: %w", target.name, err)
+			}
+			return nil, nil, err
+		}
+		if target.name != "" {
+			names = append(names, target.name)
+		}
+	}
+	return names, dbs, nil
+}
+
+func checkSchema(db *sql.DB, driver string) error {
+	migrator, err := database.NewMigrator(db, driver, constants.ORDERS_SCHEMA)
+	if err != nil {
+		return err
+	}
+	return migrator.Check()
+}
+
+func closeAll(dbs []*sql.DB) {
+	for _, db := range dbs {
+		db.Close()
+	}
+}
+
+func asDBTX(dbs []*sql.DB) []repository.DBTX {
+	converted := make([]repository.DBTX, len(dbs))
+	for i, db := range dbs {
+		converted[i] = db
+	}
+	return converted
+}
@@ -1,14 +1,23 @@
 package routes
 
 import (
+	"time"
+
+	"ecom.com/database"
 	"ecom.com/handlers"
 	"ecom.com/middleware"
 	"github.com/gin-gonic/gin"
 )
 
 type RouterConfig struct {
+	HealthHandler *handlers.HealthHandler
 	OrderHandler  *handlers.OrderHandler
 	MetricHandler *handlers.MetricHandler
+	QueueHandler  *handlers.QueueHandler
+	// Deadline of API requests, 0 means none.
+	RequestTimeout time.Duration
+	// Orders DB with replicas whose position API responses carry, nil for none.
+	ReplicatedDB *database.DB
 }
 
 func RegisterOrderRoutes(router *gin.RouterGroup, orderHandler *handlers.OrderHandler) {
@@ -17,16 +26,30 @@ func RegisterOrderRoutes(router *gin.RouterGroup, orderHandler *handlers.OrderHa
 		orderRoutes.POST("", middleware.LoggerMiddleware(), orderHandler.CreateOrderHandler)
 		orderRoutes.GET("/:id", orderHandler.GetOrderHandler)
 		orderRoutes.GET("/status/:id", orderHandler.GetOrderStatusHandler)
+		orderRoutes.POST("/:id/reprocess", orderHandler.ReprocessOrderHandler)
+	}
+}
+
+func RegisterQueueRoutes(router *gin.RouterGroup, queueHandler *handlers.QueueHandler) {
+	queueRoutes := router.Group("/queues")
+	{
+		queueRoutes.GET("/:name/rate-limit", queueHandler.GetRateLimitHandler)
+		queueRoutes.PUT("/:name/rate-limit", queueHandler.SetRateLimitHandler)
 	}
 }
 
 // RegisterRoutes initializes all API routes with middleware and versioning
 func RegisterRoutes(router *gin.Engine, cfg *RouterConfig) {
 	router.Use(middleware.LoggerMiddleware()) // Apply logging middleware globally
-	router.GET("health", handlers.HealthChecksHandler)
-	apiV1 := router.Group("/api/v1") // Version 1 API group
+	router.GET("health", cfg.HealthHandler.HealthChecksHandler)
+	router.GET("ready", cfg.HealthHandler.ReadinessHandler)
+	apiV1 := router.Group("/api/v1", middleware.TimeoutMiddleware(cfg.RequestTimeout)) // Version 1 API group
+	if cfg.ReplicatedDB != nil {
+		apiV1.Use(middleware.ConsistencyMiddleware(cfg.ReplicatedDB))
+	}
 	{
 		RegisterOrderRoutes(apiV1, cfg.OrderHandler)
+		RegisterQueueRoutes(apiV1, cfg.QueueHandler)
 		apiV1.GET("/metrics", cfg.MetricHandler.GetMetricsHandler)
 	}
 }
@@ -1,74 +1,306 @@
 package server
 
 import (
-	"database/sql"
+	"log"
+	"time"
 
+	"ecom.com/breaker"
 	"ecom.com/cache"
 	"ecom.com/config"
+	"ecom.com/constants"
 	"ecom.com/database"
 	"ecom.com/handlers"
 	"ecom.com/repository"
 	"ecom.com/routes"
 	"ecom.com/services"
+	"github.com/google/uuid"
 )
 
 // Container holds all dependencies
 type Container struct {
 	Cache    cache.CacheI
-	DB       *sql.DB
-	MetricDB *sql.DB
+	Breakers []*breaker.CircuitBreaker
+	DB       *database.DB
+	ShardDBs []*database.DB
+	MetricDB *database.DB
 
 	OrderRepo  repository.OrderRepositoryI
 	MetricRepo repository.MetricRepositoryI
 
 	OrderService  *services.Order
 	MetricService *services.Metric
+	Reconciler    *services.Reconciler
+	Archiver      *services.Archiver
+	WarmUp        *services.WarmUp
 
 	MetricHandler *handlers.MetricHandler
 	OrderHandler  *handlers.OrderHandler
+	QueueHandler  *handlers.QueueHandler
+	HealthHandler *handlers.HealthHandler
 
 	RoutesCfg *routes.RouterConfig
 }
 
 // NewContainer initializes dependencies
 func NewContainer(appConfig config.Config) *Container {
-	//setup cache
-	cache := cache.NewRedis(appConfig.Redis.Addr, appConfig.Redis.Password, 1)
+	// Circuit breakers for the external dependencies
+	cb := appConfig.CircuitBreaker
+	openTimeout := time.Duration(cb.OpenTimeoutMs) * time.Millisecond
+	ordersDBBreaker := breaker.New(string(constants.ORDERS_DB_BREAKER), cb.FailureThreshold, openTimeout, cb.HalfOpenSuccesses, repository.IsFailure)
+	metricsDBBreaker := breaker.New(string(constants.METRICS_DB_BREAKER), cb.FailureThreshold, openTimeout, cb.HalfOpenSuccesses, repository.IsFailure)
+	cacheBreaker := breaker.New(string(constants.CACHE_BREAKER), cb.FailureThreshold, openTimeout, cb.HalfOpenSuccesses, cache.IsFailure)
+	breakers := []*breaker.CircuitBreaker{ordersDBBreaker, metricsDBBreaker, cacheBreaker}
 
-	// Initialize database
-	db := database.ConnectDB(appConfig.Database.Driver, appConfig.Database.DSN)
-	metricDb := database.ConnectMetricsDB(appConfig.Metrics.Driver, appConfig.Metrics.DSN)
+	//setup cache
+	orderCache := cache.NewBreakerCache(newCache(appConfig), cacheBreaker)
 
 	// Initialize repository
-	orderRepo := repository.NewSQLiteOrderRepository(db)
-	itemRepo := repository.NewSQLiteItemRepository(db)
-	metricRepo := repository.NewSQLiteMetricRepository(metricDb)
+	store := newStorage(appConfig)
+	orderRepo := repository.NewBreakerOrderRepository(store.orders, ordersDBBreaker)
+	itemRepo := repository.NewBreakerItemRepository(store.items, ordersDBBreaker)
+	metricRepo := repository.NewBreakerMetricRepository(store.metrics, metricsDBBreaker)
+	ledgerRepo := repository.NewBreakerLedgerRepository(store.ledger, metricsDBBreaker)
+	archiveRepo := repository.NewBreakerArchiveRepository(store.archive, ordersDBBreaker)
+	unitOfWork := repository.NewBreakerUnitOfWork(store.unitOfWork, ordersDBBreaker)
 
 	// Initialize service
-	orderService := services.NewOrderService(appConfig, orderRepo, itemRepo, metricRepo, cache)
-	metricService := services.NewMetricService(metricRepo)
+	orderService := services.NewOrderService(appConfig, orderRepo, itemRepo, archiveRepo, unitOfWork, metricRepo, ledgerRepo, orderCache, breakers...)
+	reconcilerCfg := appConfig.Reconciler
+	reconciler := services.NewReconciler(orderRepo, archiveRepo, orderService.Creating, orderCache, time.Duration(reconcilerCfg.IntervalSeconds)*time.Second, reconcilerCfg.SampleSize)
+	archiveCfg := appConfig.Archive
+	archiver := services.NewArchiver(archiveRepo, orderCache, time.Duration(archiveCfg.IntervalSeconds)*time.Second,
+		time.Duration(archiveCfg.RetentionDays)*24*time.Hour, archiveCfg.BatchSize)
+	warmUpCfg := appConfig.WarmUp
+	warmUp := services.NewWarmUp(orderRepo, orderCache, time.Duration(warmUpCfg.WindowMinutes)*time.Minute, warmUpCfg.BatchSize,
+		time.Duration(warmUpCfg.BudgetMs)*time.Millisecond)
+	metricService := services.NewMetricService(metricRepo, orderService.GetQueues(), orderCache, reconciler, breakers...)
 
 	// Initialize handlers
 	orderHandler := handlers.NewOrderHandler(orderService)
 	metricHandler := handlers.NewMetricHandler(metricService)
+	queueHandler := handlers.NewQueueHandler(orderService.GetQueues())
+	healthHandler := handlers.NewHealthHandler(breakers, orderService.GetQueues(), warmUp)
 
 	return &Container{
-		Cache: cache,
+		Cache:    orderCache,
+		Breakers: breakers,
 
-		DB:       db,
-		MetricDB: metricDb,
+		DB:       store.db,
+		ShardDBs: store.shardDbs,
+		MetricDB: store.metricDb,
 
 		OrderRepo:  orderRepo,
 		MetricRepo: metricRepo,
 
-		OrderService: orderService,
+		OrderService:  orderService,
+		MetricService: metricService,
+		Reconciler:    reconciler,
+		Archiver:      archiver,
+		WarmUp:        warmUp,
 
 		OrderHandler:  orderHandler,
 		MetricHandler: metricHandler,
+		QueueHandler:  queueHandler,
+		HealthHandler: healthHandler,
 
 		RoutesCfg: &routes.RouterConfig{
-			OrderHandler:  orderHandler,
-			MetricHandler: metricHandler,
+			HealthHandler:  healthHandler,
+			OrderHandler:   orderHandler,
+			MetricHandler:  metricHandler,
+			QueueHandler:   queueHandler,
+			RequestTimeout: time.Duration(appConfig.Server.RequestTimeoutMs) * time.Millisecond,
+			ReplicatedDB:   store.replicatedDb,
 		},
 	}
 }
+
+// storage holds the repositories of the configured storage. The DBs are nil
+// when nothing is stored in one.
+type storage struct {
+	db           *database.DB
+	shardDbs     []*database.DB // db is nil when orders are sharded
+	replicatedDb *database.DB   // db if it has replicas
+	metricDb     *database.DB
+	orders       repository.OrderRepositoryI
+	items        repository.ItemRepositoryI
+	metrics      repository.MetricRepositoryI
+	ledger       repository.LedgerRepositoryI
+	archive      repository.ArchiveRepositoryI
+	unitOfWork   repository.UnitOfWorkI
+}
+
+func newStorage(appConfig config.Config) storage {
+	switch constants.StorageName(appConfig.Storage) {
+	case constants.SQL_STORAGE, "":
+		return newSQLStorage(appConfig)
+	case constants.MEMORY_STORAGE:
+		log.Println("Using in-memory storage, orders and metrics are lost on exit")
+		store := repository.NewMemoryStore()
+		return storage{
+			orders:     repository.NewMemoryOrderRepository(store),
+			items:      repository.NewMemoryItemRepository(store),
+			metrics:    repository.NewMemoryMetricRepository(store),
+			ledger:     repository.NewMemoryLedgerRepository(store),
+			archive:    repository.NewMemoryArchiveRepository(store),
+			unitOfWork: repository.NewMemoryUnitOfWork(store),
+		}
+	default:
+		log.Fatalf("Unknown storage %q", appConfig.Storage)
+		return storage{}
+	}
+}
+
+func newSQLStorage(appConfig config.Config) storage {
+	migrate := !appConfig.Migrations.Manual
+	sqliteCfg := appConfig.SQLite
+	sqliteOpts := database.SQLiteOptions{
+		WAL:          sqliteCfg.WAL,
+		BusyTimeout:  time.Duration(sqliteCfg.BusyTimeoutMs) * time.Millisecond,
+		SingleWriter: sqliteCfg.SingleWriter,
+		ReadConns:    sqliteCfg.ReadConns,
+	}
+	var store storage
+	if len(appConfig.Database.Shards) > 0 {
+		store = newShardedStorage(appConfig, migrate, sqliteOpts)
+	} else {
+		store = newOrderStorage(appConfig, migrate, sqliteOpts)
+	}
+
+	metricDb := database.ConnectPool(appConfig.Metrics.Driver, appConfig.Metrics.DSN, constants.METRICS_SCHEMA, migrate, sqliteOpts)
+	if err := metricDb.ConnectReplicas(appConfig.Metrics.Driver, constants.METRICS_SCHEMA, appConfig.Metrics.Replicas, sqliteOpts); err != nil {
+		log.Fatalf("Error connecting to the metrics replicas: %v", err)
+	}
+	metrics, err := repository.NewMetricRepository(appConfig.Metrics.Driver, metricDb)
+	if err != nil {
+		log.Fatalf("Error creating metric repository: %v", err)
+	}
+	ledger, err := repository.NewLedgerRepository(appConfig.Metrics.Driver, metricDb)
+	if err != nil {
+		log.Fatalf("Error creating ledger repository: %v", err)
+	}
+	store.metricDb, store.metrics, store.ledger = metricDb, metrics, ledger
+	return store
+}
+
+// newOrderStorage keeps orders in the database.dsn DB.
+func newOrderStorage(appConfig config.Config, migrate bool, sqliteOpts database.SQLiteOptions) storage {
+	db := database.ConnectPool(appConfig.Database.Driver, appConfig.Database.DSN, constants.ORDERS_SCHEMA, migrate, sqliteOpts)
+	if err := db.ConnectReplicas(appConfig.Database.Driver, constants.ORDERS_SCHEMA, appConfig.Database.Replicas, sqliteOpts); err != nil {
+		log.Fatalf("Error connecting to the orders replicas: %v", err)
+	}
+	var replicatedDb *database.DB
+	if len(appConfig.Database.Replicas) > 0 {
+		replicatedDb = db
+		log.Printf("Orders are read from %v replicas", len(appConfig.Database.Replicas))
+	}
+	orders, err := repository.NewOrderRepository(appConfig.Database.Driver, db)
+	if err != nil {
+		log.Fatalf("Error creating order repository: %v", err)
+	}
+	items, err := repository.NewItemRepository(appConfig.Database.Driver, db)
+	if err != nil {
+		log.Fatalf("Error creating item repository: %v", err)
+	}
+	archive, err := repository.NewArchiveRepository(appConfig.Database.Driver, db)
+	if err != nil {
+		log.Fatalf("Error creating archive repository: %v", err)
+	}
+	unitOfWork, err := repository.NewUnitOfWork(appConfig.Database.Driver, db)
+	if err != nil {
+		log.Fatalf("Error creating unit of work: %v", err)
+	}
+	return storage{db: db, replicatedDb: replicatedDb, orders: orders, items: items, archive: archive, unitOfWork: unitOfWork}
+}
+
+// newShardedStorage spreads orders over the database.shards DBs.
+func newShardedStorage(appConfig config.Config, migrate bool, sqliteOpts database.SQLiteOptions) storage {
+	driver := appConfig.Database.Driver
+	if len(appConfig.Database.Replicas) > 0 {
+		log.Fatalf("database.replicas are not supported with database.shards")
+	}
+	var names []string
+	var dbs []repository.DBTX
+	var shardDbs []*database.DB
+	for _, shard := range appConfig.Database.Shards {
+		db := database.ConnectPool(driver, shard.DSN, constants.ORDERS_SCHEMA, migrate, sqliteOpts)
+		names = append(names, shard.Name)
+		dbs = append(dbs, db)
+		shardDbs = append(shardDbs, db)
+	}
+	router, err := repository.NewShardRouter(driver, names, dbs)
+	if err != nil {
+		log.Fatalf("Error creating shard router: %v", err)
+	}
+	archive, err := repository.NewShardedArchiveRepository(router)
+	if err != nil {
+		log.Fatalf("Error creating archive repository: %v", err)
+	}
+	unitOfWork, err := repository.NewShardedUnitOfWork(router)
+	if err != nil {
+		log.Fatalf("Error creating unit of work: %v", err)
+	}
+	log.Printf("Orders are sharded over %v", names)
+	return storage{shardDbs: shardDbs, orders: router, items: router, archive: archive, unitOfWork: unitOfWork}
+}
+
+func newCache(appConfig config.Config) cache.CacheI {
+	switch constants.CacheType(appConfig.Cache.Type) {
+	case constants.REDIS_CACHE:
+		return cache.NewRedis(redisOptions(appConfig))
+	case constants.TIERED_CACHE:
+		nearCfg := appConfig.Cache.NearCache
+		ttl := time.Duration(nearCfg.TTLMs) * time.Millisecond
+		near := cache.NewMemory(cache.MemoryOptions{
+			MaxEntries:      nearCfg.MaxEntries,
+			TTL:             ttl,
+			CleanupInterval: time.Duration(appConfig.Cache.CleanupIntervalSeconds) * time.Second,
+		})
+		return cache.NewTieredCache(withBus(appConfig, near), cache.NewRedis(redisOptions(appConfig)))
+	case constants.MEMORY_CACHE, "":
+		cacheCfg := appConfig.Cache
+		memory := cache.NewMemory(cache.MemoryOptions{
+			MaxEntries:      cacheCfg.MaxEntries,
+			TTL:             time.Duration(cacheCfg.TTLSeconds) * time.Second,
+			CompletedTTL:    time.Duration(cacheCfg.CompletedTTLSeconds) * time.Second,
+			CleanupInterval: time.Duration(cacheCfg.CleanupIntervalSeconds) * time.Second,
+		})
+		return withBus(appConfig, memory)
+	default:
+		log.Fatalf("Unknown cache type %q", appConfig.Cache.Type)
+		return nil
+	}
+}
+
+// withBus shares the changes of an in-process cache with the other
+// instances.
+func withBus(appConfig config.Config, memory cache.CacheI) cache.CacheI {
+	var bus cache.BusI
+	switch constants.BusType(appConfig.Cache.Bus) {
+	case "":
+		return memory
+	case constants.REDIS_BUS:
+		bus = cache.NewRedisBus(redisOptions(appConfig))
+	default:
+		log.Fatalf("Unknown cache bus %q", appConfig.Cache.Bus)
+	}
+	broadcast, err := cache.NewBroadcastCache(memory, bus, uuid.New().String())
+	if err != nil {
+		log.Fatalf("Failed to subscribe to the cache bus: %v", err)
+	}
+	return broadcast
+}
+
+func redisOptions(appConfig config.Config) cache.RedisOptions {
+	redisCfg := appConfig.Redis
+	return cache.RedisOptions{
+		Addr:         redisCfg.Addr,
+		Password:     redisCfg.Password,
+		DB:           redisCfg.DB,
+		DialTimeout:  time.Duration(redisCfg.DialTimeoutMs) * time.Millisecond,
+		ReadTimeout:  time.Duration(redisCfg.ReadTimeoutMs) * time.Millisecond,
+		WriteTimeout: time.Duration(redisCfg.WriteTimeoutMs) * time.Millisecond,
+		PoolSize:     redisCfg.PoolSize,
+		TTL:          time.Duration(appConfig.Cache.TTLSeconds) * time.Second,
+		CompletedTTL: time.Duration(appConfig.Cache.CompletedTTLSeconds) * time.Second,
+	}
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"ecom.com/cache"
+	"ecom.com/repository"
+)
+
+// ArchiverStats reports what the retention job moved out of the orders DB.
+type ArchiverStats struct {
+	Runs     int64
+	Archived int64
+	LastRun  time.Time
+}
+
+// Archiver periodically moves Completed orders older than the retention
+// period, with their items, to the archive tables. GetOrder still finds
+// them there, only slower.
+type Archiver struct {
+	repo      repository.ArchiveRepositoryI
+	cache     cache.CacheI
+	interval  time.Duration
+	retention time.Duration
+	batchSize int
+	stats     ArchiverStats
+	mutex     *sync.Mutex
+	// Canceled by Stop, also aborts a run that is in progress.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewArchiver(archiveRepo repository.ArchiveRepositoryI, cache cache.CacheI, interval time.Duration, retention time.Duration, batchSize int) *Archiver {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Archiver{
+		repo:      archiveRepo,
+		cache:     cache,
+		interval:  interval,
+		retention: retention,
+		batchSize: batchSize,
+		mutex:     &sync.Mutex{},
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Start runs Archive every interval until Stop. An interval or retention of
+// zero disables the archiver.
+func (a *Archiver) Start() {
+	if a.interval <= 0 || a.retention <= 0 {
+		return
+	}
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				a.Archive(a.ctx)
+			case <-a.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (a *Archiver) Stop() {
+	a.cancel()
+	a.wg.Wait()
+}
+
+// Archive moves every order past the retention period, one batch per
+// transaction, and returns how many it moved. A run stops early once ctx
+// is done or a batch fails.
+func (a *Archiver) Archive(ctx context.Context) int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.stats.Runs++
+	a.stats.LastRun = time.Now()
+
+	before := time.Now().Add(-a.retention)
+	archived := 0
+	for ctx.Err() == nil {
+		ids, selected, err := a.repo.ArchiveOrders(ctx, before, a.batchSize)
+		for _, id := range ids {
+			a.forget(id)
+		}
+		archived += len(ids)
+		if err != nil {
+			log.Printf("Archiver failed after %v orders: %v", archived, err)
+			break
+		}
+		// Orders that changed since they were selected are skipped, only
+		// an empty selection means there are none left.
+		if selected == 0 {
+			break
+		}
+	}
+	a.stats.Archived += int64(archived)
+	if archived > 0 {
+		log.Printf("Archiver moved %v orders created before %v", archived, before.Format(time.RFC3339))
+	}
+	return archived
+}
+
+// forget drops the cached entries of an archived order, the reconciler
+// would otherwise report them as missing from the DB.
+func (a *Archiver) forget(orderID string) {
+	if err := a.cache.DeleteOrderStatus(orderID); err != nil {
+		log.Printf("Archiver failed to drop cached status of order %v: %v", orderID, err)
+	}
+	if err := a.cache.InvalidateOrder(orderID); err != nil {
+		log.Printf("Archiver failed to invalidate order %v: %v", orderID, err)
+	}
+}
+
+func (a *Archiver) Stats() ArchiverStats {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.stats
+}
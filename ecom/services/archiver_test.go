@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ecom.com/cache"
+	"ecom.com/errors"
+	"ecom.com/models"
+	"ecom.com/repository"
+)
+
+func TestArchiver_Archive(t *testing.T) {
+	tests := []struct {
+		name         string
+		retention    time.Duration
+		batchSize    int
+		wantArchived int
+		wantStats    ArchiverStats
+	}{
+		{
+			name:         "nothing old enough",
+			retention:    time.Hour,
+			batchSize:    10,
+			wantArchived: 0,
+			wantStats:    ArchiverStats{Runs: 1},
+		},
+		{
+			name:         "one batch",
+			retention:    time.Nanosecond,
+			batchSize:    10,
+			wantArchived: 3,
+			wantStats:    ArchiverStats{Runs: 1, Archived: 3},
+		},
+		{
+			name:         "several batches",
+			retention:    time.Nanosecond,
+			batchSize:    2,
+			wantArchived: 3,
+			wantStats:    ArchiverStats{Runs: 1, Archived: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := repository.NewMemoryStore()
+			orders := repository.NewMemoryOrderRepository(store)
+			c := cache.NewMemory(cache.MemoryOptions{})
+			for _, order := range []models.Order{
+				{OrderID: "o1", Status: "Completed"},
+				{OrderID: "o2", Status: "Completed"},
+				{OrderID: "o3", Status: "Completed"},
+				{OrderID: "o4", Status: "Processing"},
+			} {
+				if err := orders.CreateOrder(ctx, &order); err != nil {
+					t.Fatalf("CreateOrder() error = %v", err)
+				}
+				c.SetOrderStatus(order.OrderID, order.Status)
+			}
+			time.Sleep(time.Millisecond)
+
+			a := NewArchiver(repository.NewMemoryArchiveRepository(store), c, 0, tt.retention, tt.batchSize)
+			if got := a.Archive(ctx); got != tt.wantArchived {
+				t.Errorf("Archive() = %v, want %v", got, tt.wantArchived)
+			}
+			for _, id := range []string{"o1", "o2", "o3", "o4"} {
+				_, dbErr := orders.GetOrderByID(ctx, id)
+				_, cacheErr := c.GetOrderStatus(id)
+				archived := id != "o4" && tt.wantArchived > 0
+				if archived && (dbErr == nil || cacheErr != errors.ErrNotFound) {
+					t.Errorf("order %v still live, DB error %v, cache error %v", id, dbErr, cacheErr)
+				}
+				if !archived && (dbErr != nil || cacheErr != nil) {
+					t.Errorf("order %v gone, DB error %v, cache error %v", id, dbErr, cacheErr)
+				}
+			}
+			got := a.Stats()
+			got.LastRun = time.Time{}
+			if got != tt.wantStats {
+				t.Errorf("Stats() = %+v, want %+v", got, tt.wantStats)
+			}
+		})
+	}
+}
+
+func TestArchiver_ArchiveAfterRestore(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	orders := repository.NewMemoryOrderRepository(store)
+	archive := repository.NewMemoryArchiveRepository(store)
+	if err := orders.CreateOrder(ctx, &models.Order{OrderID: "o1", Status: "Completed"}); err != nil {
+		t.Fatalf("CreateOrder() error = %v", err)
+	}
+	retention := 25 * time.Millisecond
+	time.Sleep(2 * retention)
+
+	a := NewArchiver(archive, cache.NewMemory(cache.MemoryOptions{}), 0, retention, 10)
+	if got := a.Archive(ctx); got != 1 {
+		t.Fatalf("Archive() = %v, want 1", got)
+	}
+	if err := archive.RestoreOrder(ctx, "o1"); err != nil {
+		t.Fatalf("RestoreOrder() error = %v", err)
+	}
+	if got := a.Archive(ctx); got != 0 {
+		t.Errorf("Archive() right after restore = %v, want 0", got)
+	}
+	if _, err := orders.GetOrderByID(ctx, "o1"); err != nil {
+		t.Errorf("GetOrderByID() of the restored order error = %v", err)
+	}
+	time.Sleep(2 * retention)
+	if got := a.Archive(ctx); got != 1 {
+		t.Errorf("Archive() once the retention passed since the restore = %v, want 1", got)
+	}
+}
+
+// scriptedArchiveRepo returns one batch per ArchiveOrders call, then none.
+type scriptedArchiveRepo struct {
+	repository.ArchiveRepositoryI
+	batches []scriptedBatch
+}
+
+type scriptedBatch struct {
+	moved    []string
+	selected int
+}
+
+func (r *scriptedArchiveRepo) ArchiveOrders(ctx context.Context, before time.Time, limit int) ([]string, int, error) {
+	if len(r.batches) == 0 {
+		return nil, 0, nil
+	}
+	batch := r.batches[0]
+	r.batches = r.batches[1:]
+	return batch.moved, batch.selected, nil
+}
+
+func TestArchiver_ArchivePastSkippedOrders(t *testing.T) {
+	repo := &scriptedArchiveRepo{batches: []scriptedBatch{
+		{moved: []string{"o1"}, selected: 2}, // o2 changed since it was selected
+		{moved: []string{"o3", "o4"}, selected: 2},
+	}}
+	a := NewArchiver(repo, cache.NewMemory(cache.MemoryOptions{}), 0, time.Hour, 2)
+	if got := a.Archive(context.Background()); got != 3 {
+		t.Errorf("Archive() = %v, want 3", got)
+	}
+	if len(repo.batches) != 0 {
+		t.Errorf("Archive() left %v batches, want all taken", len(repo.batches))
+	}
+}
@@ -1,8 +1,13 @@
 package services
 
 import (
+	"context"
 	"log"
+	"sort"
 
+	"ecom.com/breaker"
+	"ecom.com/cache"
+	"ecom.com/queue"
 	"ecom.com/repository"
 
 	"ecom.com/common"
@@ -10,31 +15,98 @@ import (
 )
 
 type Metric struct {
-	Repo repository.MetricRepositoryI
+	Repo       repository.MetricRepositoryI
+	Queues     map[string]queue.QueueI
+	Cache      cache.CacheI
+	Reconciler *Reconciler
+	Breakers   []*breaker.CircuitBreaker
 }
 
-func NewMetricService(repo repository.MetricRepositoryI) *Metric {
+func NewMetricService(repo repository.MetricRepositoryI, queues map[string]queue.QueueI, cache cache.CacheI, reconciler *Reconciler, breakers ...*breaker.CircuitBreaker) *Metric {
 	return &Metric{
-		Repo: repo,
+		Repo:       repo,
+		Queues:     queues,
+		Cache:      cache,
+		Reconciler: reconciler,
+		Breakers:   breakers,
 	}
 }
 
-func (m *Metric) GetMetrics() (*common.Metrics, error) {
-	totalOrderReceived, err := m.Repo.GetMetricCount()
+// GetMetrics returns zero values for anything the metrics DB could not
+// provide, so breaker state is still reported while it is down. It only
+// fails if ctx ended, the caller is not waiting for the result anymore.
+func (m *Metric) GetMetrics(ctx context.Context) (*common.Metrics, error) {
+	metrics := common.Metrics{
+		CircuitBreakers: CircuitBreakerStatuses(m.Breakers),
+		Queues:          m.queueStatuses(),
+	}
+	if m.Cache != nil {
+		metrics.Cache = cacheStatus(m.Cache.Stats())
+	}
+	if m.Reconciler != nil {
+		stats := m.Reconciler.Stats()
+		metrics.Reconciler = common.ReconcilerStatus{Runs: stats.Runs, Checked: stats.Checked, Missing: stats.Missing,
+			Mismatched: stats.Mismatched, Repaired: stats.Repaired, Suspects: stats.Suspects}
+	}
+	totalOrderReceived, err := m.Repo.GetMetricCount(ctx, string(constants.PROCESSING_TIME))
 	if err != nil {
 		log.Printf("failed to get data from repository %v", err)
+	} else {
+		metrics.TotalOrdersReceived = int64(*totalOrderReceived)
 	}
-	averageProcessingTime, err := m.Repo.GetAverageTime(string(constants.PROCESSING_TIME))
+	averageProcessingTime, err := m.Repo.GetAverageTime(ctx, string(constants.PROCESSING_TIME))
 	if err != nil {
 		log.Printf("failed to get data from repository %v", err)
+	} else {
+		metrics.AverageProcessingTime = *averageProcessingTime
 	}
+	averageRateLimitWaitTime, err := m.Repo.GetAverageTime(ctx, string(constants.RATE_LIMIT_WAIT_TIME))
 	if err != nil {
 		log.Printf("failed to get data from repository %v", err)
+	} else {
+		metrics.AverageRateLimitWaitTime = *averageRateLimitWaitTime
 	}
-	metrics := common.Metrics{
-		TotalOrdersReceived:   int64(*totalOrderReceived),
-		AverageProcessingTime: *averageProcessingTime,
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
 	return &metrics, nil
 }
+
+func (m *Metric) queueStatuses() []common.QueueStatus {
+	names := make([]string, 0, len(m.Queues))
+	for name := range m.Queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	statuses := []common.QueueStatus{}
+	for _, name := range names {
+		stats := m.Queues[name].Stats()
+		statuses = append(statuses, common.QueueStatus{Name: stats.Name, Panics: stats.Panics, FailedItems: stats.FailedItems, LostMetrics: stats.LostMetrics, Paused: stats.Paused})
+	}
+	return statuses
+}
+
+func cacheStatus(stats cache.Stats) common.CacheStatus {
+	status := common.CacheStatus{
+		Name:        stats.Name,
+		Entries:     stats.Entries,
+		Evictions:   stats.Evictions,
+		Expirations: stats.Expirations,
+		Hits:        stats.Hits,
+		Misses:      stats.Misses,
+		HitRatio:    stats.HitRatio(),
+	}
+	for _, tier := range stats.Tiers {
+		status.Tiers = append(status.Tiers, cacheStatus(tier))
+	}
+	return status
+}
+
+func CircuitBreakerStatuses(breakers []*breaker.CircuitBreaker) []common.CircuitBreakerStatus {
+	statuses := []common.CircuitBreakerStatus{}
+	for _, b := range breakers {
+		statuses = append(statuses, common.CircuitBreakerStatus{Name: b.Name(), State: string(b.State()), Trips: b.Trips()})
+	}
+	return statuses
+}
@@ -1,39 +1,75 @@
 package services
 
 import (
+	"context"
 	"database/sql"
+	stderrors "errors"
+	"fmt"
 	"log"
 	"sync"
 	"time"
 
+	"ecom.com/breaker"
 	"ecom.com/cache"
 	"ecom.com/common"
 	"ecom.com/config"
 	"ecom.com/constants"
+	"ecom.com/database"
+	"ecom.com/errors"
 	"ecom.com/logger"
 	"ecom.com/models"
 	"ecom.com/queue"
 	"ecom.com/repository"
-	"github.com/go-redis/redis"
-	"github.com/google/uuid"
+	"golang.org/x/sync/singleflight"
 )
 
 type Order struct {
 	repo                 repository.OrderRepositoryI
 	itemRepo             repository.ItemRepositoryI
+	archiveRepo          repository.ArchiveRepositoryI
+	uow                  repository.UnitOfWorkI
 	orderCreationQueue   queue.QueueI
 	orderProcessingQueue queue.QueueI
 	cache                cache.CacheI
+	// How long unknown order ids are remembered, 0 disables it.
+	notFoundTTL time.Duration
+	// Reads may go to replicas that lag behind the workers' writes.
+	replicated bool
+	// Coalesce DB lookups on cache misses, one in flight per order.
+	statusLookups singleflight.Group
+	orderLookups  singleflight.Group
+	// Orders queued for creation and not stored yet, by id.
+	creating sync.Map
 }
 
-func NewOrderService(appConfig config.Config, orderRepo repository.OrderRepositoryI, itemRepo repository.ItemRepositoryI, metricRepo repository.MetricRepositoryI, cache cache.CacheI) *Order {
+// creation is done once the creation worker stored the order, or failed to.
+type creation struct {
+	done chan struct{}
+	err  error
+}
+
+func NewOrderService(appConfig config.Config, orderRepo repository.OrderRepositoryI, itemRepo repository.ItemRepositoryI, archiveRepo repository.ArchiveRepositoryI, uow repository.UnitOfWorkI, metricRepo repository.MetricRepositoryI, ledgerRepo repository.LedgerRepositoryI, cache cache.CacheI, breakers ...*breaker.CircuitBreaker) *Order {
 	orderService := &Order{
-		repo:     orderRepo,
-		itemRepo: itemRepo,
-		cache:    cache,
+		repo:        orderRepo,
+		itemRepo:    itemRepo,
+		archiveRepo: archiveRepo,
+		uow:         uow,
+		cache:       cache,
+		notFoundTTL: time.Duration(appConfig.Cache.NotFoundTTLSeconds) * time.Second,
+		replicated:  len(appConfig.Database.Replicas) > 0,
+	}
+	creationLimit := appConfig.Queue.CreationRateLimit
+	processingLimit := appConfig.Queue.ProcessingRateLimit
+	orderService.orderCreationQueue = queue.NewQueue(string(constants.ORDER_CREATION_QUEUE), appConfig.Queue.WorkerPool, appConfig.Queue.QueueCapacity,
+		queue.NewRateLimiter(creationLimit.Rate, creationLimit.Burst), orderService.CreateOrderInDB, metricRepo, ledgerRepo, orderRepo, cache, breakers...)
+	if batch := appConfig.Queue.ProcessingBatch; batch.Size > 1 {
+		orderService.orderProcessingQueue = queue.NewBatchQueue(string(constants.ORDER_PROCESSING_QUEUE), appConfig.Queue.WorkerPool, appConfig.Queue.QueueCapacity,
+			queue.NewRateLimiter(processingLimit.Rate, processingLimit.Burst), queue.BatchConfig{Size: batch.Size, Wait: time.Duration(batch.WaitMs) * time.Millisecond},
+			orderService.ProcessOrderBatch, metricRepo, ledgerRepo, orderRepo, cache, breakers...)
+	} else {
+		orderService.orderProcessingQueue = queue.NewQueue(string(constants.ORDER_PROCESSING_QUEUE), appConfig.Queue.WorkerPool, appConfig.Queue.QueueCapacity,
+			queue.NewRateLimiter(processingLimit.Rate, processingLimit.Burst), orderService.ProcessOrder, metricRepo, ledgerRepo, orderRepo, cache, breakers...)
 	}
-	orderService.orderCreationQueue = queue.NewQueue(appConfig.Queue.WorkerPool, appConfig.Queue.QueueCapacity, orderService.CreateOrderInDB, metricRepo, orderRepo, cache)
-	orderService.orderProcessingQueue = queue.NewQueue(appConfig.Queue.WorkerPool, appConfig.Queue.QueueCapacity, orderService.ProcessOrder, metricRepo, orderRepo, cache)
 	return orderService
 }
 
@@ -42,79 +78,214 @@ const (
 	CreationTimeMetricKey   = "creation_time"
 )
 
-func (o *Order) CreateOrder(userID string, itemIDs []string, totalAmount float64) (string, error) {
-	orderID := uuid.New().String()
-
-	err := o.cache.SetOrderStatus(orderID, string(constants.PENDING))
-	if err != nil {
-		log.Printf("Warning: failed to set order status in Redis for orderID %s: %v", orderID, err)
+// CreateOrder only queues the order, ctx is checked so a request that was
+// canceled already does not create one. With replicas it waits until the
+// order is stored, so the consistency token of the response covers it.
+func (o *Order) CreateOrder(ctx context.Context, userID string, itemIDs []string, totalAmount float64) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
 	}
+	orderID := repository.NewOrderID(userID)
 
-	o.orderCreationQueue.Enqueue(queue.Item{Id: orderID, Value: &common.OrderRequest{UserID: userID, ItemIDs: itemIDs, TotalAmount: totalAmount}})
-	return orderID, nil
+	created := &creation{done: make(chan struct{})}
+	o.creating.Store(orderID, created)
+	o.setCachedStatus(orderID, string(constants.PENDING))
+
+	if !o.orderCreationQueue.Enqueue(queue.Item{Id: orderID, Value: &common.OrderRequest{UserID: userID, ItemIDs: itemIDs, TotalAmount: totalAmount}}) {
+		o.creating.Delete(orderID)
+		return orderID, nil
+	}
+	if !o.replicated {
+		return orderID, nil
+	}
+	select {
+	case <-created.done:
+		if created.err != nil {
+			return "", created.err
+		}
+		return orderID, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
 }
 
-func (o *Order) GetOrder(orderID string) (*common.OrderResponse, error) {
-	return o.getOrder(orderID)
+// GetOrder serves the order from the cache and fills the cache on a miss. A
+// fill is dropped if the order changed while it was read from the DB.
+func (o *Order) GetOrder(ctx context.Context, orderID string) (*common.OrderResponse, error) {
+	cached, version, err := o.cache.GetOrder(orderID)
+	if err == nil {
+		return cached, nil
+	}
+	if err != errors.ErrNotFound {
+		log.Printf("Cache error %v", err)
+	}
+
+	order, err := sharedLookup(ctx, &o.orderLookups, orderID, func(ctx context.Context) (interface{}, error) {
+		order, err := o.getOrder(ctx, orderID)
+		if err != nil {
+			return nil, err
+		}
+		if err := o.cache.SetOrder(order, version); err == errors.ErrStaleWrite {
+			log.Printf("Order %v changed while it was read, not caching it", orderID)
+		}
+		return order, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order.(*common.OrderResponse), nil
 }
 
-func (o *Order) GetOrderStatus(orderID string) (string, error) {
+func (o *Order) GetOrderStatus(ctx context.Context, orderID string) (string, error) {
 	status, err := o.cache.GetOrderStatus(orderID)
 	if err == nil {
 		return status, nil
 	}
+	if err == errors.ErrCachedNotFound {
+		return "", sql.ErrNoRows
+	}
 
-	if err != redis.Nil {
-		logger.Logger.Printf("Redis error %v", err)
+	if err != errors.ErrNotFound {
+		logger.Logger.Printf("Cache error %v", err)
 	}
 
-	// Fallback to DB.
-	order, err := o.repo.GetOrderByID(orderID)
+	// Fallback to DB, concurrent misses for the same order share one query.
+	dbStatus, err := sharedLookup(ctx, &o.statusLookups, orderID, func(ctx context.Context) (interface{}, error) {
+		order, err := o.repo.GetOrderByID(ctx, orderID)
+		if err == sql.ErrNoRows && o.replicated {
+			// A replica may not have the order yet.
+			order, err = o.repo.GetOrderByID(database.Primary(ctx), orderID)
+		}
+		if err == sql.ErrNoRows {
+			// Archived statuses are not cached, the reconciler would find
+			// them missing from the orders table.
+			archived, _, err := o.archiveRepo.GetArchivedOrder(ctx, orderID)
+			if err == sql.ErrNoRows {
+				o.cacheNotFound(orderID)
+			}
+			if err != nil {
+				return "", err
+			}
+			return archived.Status, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		o.setCachedStatus(order.OrderID, order.Status)
+		return order.Status, nil
+	})
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return "", sql.ErrNoRows
 		}
 		return "", err
 	}
-
-	_ = o.cache.SetOrderStatus(order.OrderID, order.Status)
-	return order.Status, nil
+	return dbStatus.(string), nil
 }
 
-func (o *Order) ProcessOrder(item queue.Item) {
+// ProcessOrder completes an order. An error makes the queue release the
+// item, the order stays Processing until it is delivered again.
+func (o *Order) ProcessOrder(item queue.Item) error {
 	order, ok := item.Value.(*common.OrderItem)
 	if !ok {
 		log.Printf("Invalid item in queue: %v ", item)
-		return
+		return fmt.Errorf("invalid item %v", item.Id)
 	}
-	if err := o.cache.SetOrderStatus(order.OrderID, string(constants.PROCESSING)); err != nil {
-		log.Println("Error updating cache to Processing:", err)
+	o.setCachedStatus(order.OrderID, string(constants.PROCESSING))
+	o.invalidateOrder(order.OrderID)
+	// Simulating Order Process Delay.
+	time.Sleep(1 * time.Second)
+	//OrderProcess completed.
+	if err := o.repo.UpdateOrderStatus(context.Background(), order.OrderID, string(constants.COMPELETED)); err != nil {
+		log.Println("Error updating order to Completed in DB:", err)
+		return err
+	}
+	o.setCachedStatus(order.OrderID, string(constants.COMPELETED))
+	o.invalidateOrder(order.OrderID)
+	return nil
+}
+
+// ProcessOrderBatch processes a batch of orders and completes them with a
+// single DB transaction. The result for each item is returned in order.
+func (o *Order) ProcessOrderBatch(items []queue.Item) []error {
+	errs := make([]error, len(items))
+	var orderIds []string
+	var positions []int
+	for i, item := range items {
+		order, ok := item.Value.(*common.OrderItem)
+		if !ok {
+			log.Printf("Invalid item in queue: %v ", item)
+			errs[i] = fmt.Errorf("invalid item %v", item.Id)
+			continue
+		}
+		o.setCachedStatus(order.OrderID, string(constants.PROCESSING))
+		o.invalidateOrder(order.OrderID)
+		orderIds = append(orderIds, order.OrderID)
+		positions = append(positions, i)
 	}
 	// Simulating Order Process Delay.
 	time.Sleep(1 * time.Second)
 	//OrderProcess completed.
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-	go func(orderID string, wg *sync.WaitGroup) {
-		defer wg.Done()
-		if err := o.repo.UpdateOrderStatus(orderID, string(constants.COMPELETED)); err != nil {
-			log.Println("Error updating order to Completed in DB:", err)
+	for j, err := range o.repo.UpdateOrderStatusBatch(context.Background(), orderIds, string(constants.COMPELETED)) {
+		orderID := orderIds[j]
+		if err != nil {
+			log.Printf("Error updating order %v to Completed in DB: %v", orderID, err)
+			errs[positions[j]] = err
+			continue
 		}
-	}(order.OrderID, &wg)
+		o.setCachedStatus(orderID, string(constants.COMPELETED))
+		o.invalidateOrder(orderID)
+	}
+	return errs
+}
 
-	if err := o.cache.SetOrderStatus(order.OrderID, string(constants.COMPELETED)); err != nil {
-		log.Printf("Error updating cache ,order %v to Completed: err %v", order.OrderID, err)
+// CreateOrderInDB stores a queued order and hands it to processing. An error
+// makes the queue release the item.
+func (o *Order) CreateOrderInDB(qItem queue.Item) error {
+	err := o.createOrderInDB(qItem)
+	// Not reached on a panic, the retry finishes the creation.
+	if c, found := o.creating.LoadAndDelete(qItem.Id); found {
+		created := c.(*creation)
+		created.err = err
+		close(created.done)
 	}
-	wg.Wait()
+	return err
 }
 
-func (o *Order) CreateOrderInDB(qItem queue.Item) {
-	orderReq, _ := qItem.Value.(*common.OrderRequest)
-	err := o.saveOrderInDB(qItem.Id, *orderReq)
+func (o *Order) createOrderInDB(qItem queue.Item) error {
+	orderReq, ok := qItem.Value.(*common.OrderRequest)
+	if !ok || orderReq == nil {
+		log.Printf("Invalid item in queue: %v ", qItem)
+		return fmt.Errorf("invalid item %v", qItem.Id)
+	}
+	err := o.saveOrderInDB(context.Background(), qItem.Id, *orderReq)
 	if err != nil {
+		// Nothing was written, the order must not show up as Pending.
 		log.Printf("Failed to saveOrderInDb %v err %v", qItem.Id, err)
+		if err := o.cache.DeleteOrderStatus(qItem.Id); err != nil {
+			log.Printf("Error removing cached status of order %v: %v", qItem.Id, err)
+		}
+		return err
 	}
 	o.orderProcessingQueue.Enqueue(queue.Item{Id: qItem.Id, Value: &common.OrderItem{OrderID: qItem.Id}})
+	return nil
+}
+
+// ReprocessOrder queues an order for processing again even if it was
+// processed before. Meant for operators fixing up orders by hand.
+func (o *Order) ReprocessOrder(ctx context.Context, orderID string) error {
+	if _, err := o.repo.GetOrderByID(database.Primary(ctx), orderID); err != nil {
+		return err
+	}
+	o.orderProcessingQueue.Enqueue(queue.Item{Id: orderID, Value: &common.OrderItem{OrderID: orderID}, Force: true})
+	return nil
+}
+
+// Creating reports whether the order is queued for creation and not stored
+// yet, its cached Pending status has no DB row for now.
+func (o *Order) Creating(orderID string) bool {
+	_, found := o.creating.Load(orderID)
+	return found
 }
 
 func (o *Order) GetOrderProcessQueue() queue.QueueI {
@@ -125,50 +296,150 @@ func (o *Order) GetOrderCreationQueue() queue.QueueI {
 	return o.orderCreationQueue
 }
 
-func (o *Order) saveOrderInDB(orderId string, req common.OrderRequest) error {
-	err := o.repo.CreateOrder(&models.Order{
-		OrderID:     orderId,
-		UserID:      req.UserID,
-		TotalAmount: req.TotalAmount,
-		Status:      string(constants.PENDING),
-	})
-	if err != nil {
-		return err
+// GetQueues returns the order queues keyed by name.
+func (o *Order) GetQueues() map[string]queue.QueueI {
+	return map[string]queue.QueueI{
+		o.orderCreationQueue.Name():   o.orderCreationQueue,
+		o.orderProcessingQueue.Name(): o.orderProcessingQueue,
 	}
-	for _, itemId := range req.ItemIDs {
-		err := o.itemRepo.CreateItem(&models.Item{ItemID: itemId, OrderID: orderId})
+}
+
+// saveOrderInDB writes the order and its items in one transaction, either
+// all of them are stored or none.
+func (o *Order) saveOrderInDB(ctx context.Context, orderId string, req common.OrderRequest) error {
+	err := o.uow.Do(ctx, func(repos repository.Repositories) error {
+		err := repos.Orders.CreateOrder(ctx, &models.Order{
+			OrderID:     orderId,
+			UserID:      req.UserID,
+			TotalAmount: req.TotalAmount,
+			Status:      string(constants.PENDING),
+		})
 		if err != nil {
-			log.Printf("Failed to create Item %v err %v", itemId, err)
+			return err
+		}
+		for _, itemId := range req.ItemIDs {
+			if err := repos.Items.CreateItem(ctx, &models.Item{ItemID: itemId, OrderID: orderId}); err != nil {
+				return fmt.Errorf("item %v: %w", itemId, err)
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
+	// Replaces a not found entry cached by a read that raced with the insert.
+	o.setCachedStatus(orderId, string(constants.PENDING))
+	o.invalidateOrder(orderId)
 	return nil
 }
 
-func (o *Order) getOrder(orderID string) (*common.OrderResponse, error) {
-	order, err := o.repo.GetOrderByID(orderID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, sql.ErrNoRows
+// setCachedStatus updates the cached status. The cache refuses to move an
+// order backwards, e.g. a DB read that raced with processing writing
+// Pending over Completed, those writes are only logged.
+func (o *Order) setCachedStatus(orderID, status string) {
+	err := o.cache.SetOrderStatus(orderID, status)
+	if err == errors.ErrStaleWrite {
+		log.Printf("Rejected stale cache write, order %v to %v", orderID, status)
+	} else if err != nil {
+		log.Printf("Error updating cache, order %v to %v: err %v", orderID, status, err)
+	}
+}
+
+// cacheNotFound remembers that orderID does not exist, so repeated lookups
+// of unknown ids do not all reach the DB. An order created meanwhile has a
+// status in the cache already and keeps it.
+func (o *Order) cacheNotFound(orderID string) {
+	if o.notFoundTTL <= 0 {
+		return
+	}
+	err := o.cache.SetOrderNotFound(orderID, o.notFoundTTL)
+	if err != nil && err != errors.ErrStaleWrite {
+		log.Printf("Error caching order %v as not found: %v", orderID, err)
+	}
+}
+
+// invalidateOrder must be called after every change to an order or its
+// items has reached the DB.
+func (o *Order) invalidateOrder(orderID string) {
+	if err := o.cache.InvalidateOrder(orderID); err != nil {
+		log.Printf("Error invalidating cached order %v: %v", orderID, err)
+	}
+}
+
+// getOrder falls back to the archive for orders the retention job moved.
+// Processing updates orders after their requests returned, and a client may
+// read without its consistency token. An order that a replica does not have
+// yet, or has with an older status than the cache, is read again from the
+// primary with its items.
+func (o *Order) getOrder(ctx context.Context, orderID string) (*common.OrderResponse, error) {
+	order, err := o.repo.GetOrderByID(ctx, orderID)
+	if o.replicated && (err == sql.ErrNoRows || (err == nil && o.staleStatus(order))) {
+		ctx = database.Primary(ctx)
+		order, err = o.repo.GetOrderByID(ctx, orderID)
+	}
+	if err == sql.ErrNoRows {
+		archived, items, err := o.archiveRepo.GetArchivedOrder(ctx, orderID)
+		if err != nil {
+			return nil, err
 		}
+		return newOrderResponse(archived, items), nil
+	}
+	if err != nil {
 		return nil, err
 	}
-	items, err := o.itemRepo.GetItemsByOrderId(orderID)
+	items, err := o.itemRepo.GetItemsByOrderId(ctx, orderID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, sql.ErrNoRows
 		}
 		return nil, err
 	}
+	return newOrderResponse(order, items), nil
+}
+
+// staleStatus tells if order has another status than the cache, which the
+// workers update after writing it.
+func (o *Order) staleStatus(order *models.Order) bool {
+	cached, err := o.cache.GetOrderStatus(order.OrderID)
+	return err == nil && cached != order.Status
+}
+
+func newOrderResponse(order *models.Order, items []models.Item) *common.OrderResponse {
 	var itemIds []string
 	for _, item := range items {
 		itemIds = append(itemIds, item.ItemID)
 	}
-	orderResp := &common.OrderResponse{
+	return &common.OrderResponse{
 		OrderID:     order.OrderID,
 		UserID:      order.UserID,
 		ItemIDs:     itemIds,
 		TotalAmount: order.TotalAmount,
 		Status:      order.Status,
 	}
-	return orderResp, nil
+}
+
+// sharedLookup runs fn once for all concurrent callers with the same key.
+// fn gets the context of the caller that started it. If that caller gives
+// up its query is canceled and the callers still waiting start over, so
+// one client going away never fails the others.
+func sharedLookup(ctx context.Context, group *singleflight.Group, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	for {
+		results := group.DoChan(key, func() (interface{}, error) {
+			return fn(ctx)
+		})
+		select {
+		case res := <-results:
+			if isContextErr(res.Err) && ctx.Err() == nil {
+				continue
+			}
+			return res.Val, res.Err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// isContextErr reports whether err only says that a context ended.
+func isContextErr(err error) bool {
+	return stderrors.Is(err, context.Canceled) || stderrors.Is(err, context.DeadlineExceeded)
 }
@@ -0,0 +1,405 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ecom.com/cache"
+	"ecom.com/common"
+	"ecom.com/config"
+	"ecom.com/models"
+	"ecom.com/queue"
+	"ecom.com/repository"
+)
+
+// slowOrderRepo counts lookups and holds each one for delay, long enough
+// for concurrent callers to pile up behind it.
+type slowOrderRepo struct {
+	orders  map[string]*models.Order
+	delay   time.Duration
+	lookups atomic.Int64
+}
+
+func (r *slowOrderRepo) CreateOrder(ctx context.Context, order *models.Order) error {
+	return nil
+}
+
+func (r *slowOrderRepo) UpdateOrderStatus(ctx context.Context, orderId string, status string) error {
+	return nil
+}
+
+func (r *slowOrderRepo) UpdateOrderStatusBatch(ctx context.Context, orderIds []string, status string) []error {
+	return make([]error, len(orderIds))
+}
+
+func (r *slowOrderRepo) GetOrderByID(ctx context.Context, id string) (*models.Order, error) {
+	r.lookups.Add(1)
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	order, ok := r.orders[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *order
+	return &copied, nil
+}
+
+func (r *slowOrderRepo) ListActiveOrders(ctx context.Context, since time.Time, after *models.Order, limit int) ([]*models.Order, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	active := []*models.Order{}
+	for _, order := range r.orders {
+		if order.Status == "Completed" && order.CreatedAt.Before(since) {
+			continue
+		}
+		if after != nil && !order.CreatedAt.Before(after.CreatedAt) &&
+			!(order.CreatedAt.Equal(after.CreatedAt) && order.OrderID < after.OrderID) {
+			continue
+		}
+		active = append(active, order)
+	}
+	sort.Slice(active, func(i, j int) bool {
+		if !active[i].CreatedAt.Equal(active[j].CreatedAt) {
+			return active[i].CreatedAt.After(active[j].CreatedAt)
+		}
+		return active[i].OrderID > active[j].OrderID
+	})
+	if len(active) > limit {
+		active = active[:limit]
+	}
+	return active, nil
+}
+
+// wait holds a query for delay, or until ctx is done like a canceled query.
+func (r *slowOrderRepo) wait(ctx context.Context) error {
+	select {
+	case <-time.After(r.delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type emptyItemRepo struct{}
+
+func (r *emptyItemRepo) CreateItem(ctx context.Context, item *models.Item) error { return nil }
+func (r *emptyItemRepo) GetItem(ctx context.Context, id string) (*models.Item, error) {
+	return nil, sql.ErrNoRows
+}
+func (r *emptyItemRepo) GetItemsByOrderId(ctx context.Context, id string) ([]models.Item, error) {
+	return nil, nil
+}
+func (r *emptyItemRepo) RemoveItem(ctx context.Context, itemId string, orderId string) error {
+	return nil
+}
+
+// fakeUnitOfWork hands out the fake repositories, there is nothing to
+// roll back.
+type fakeUnitOfWork struct {
+	orders repository.OrderRepositoryI
+	items  repository.ItemRepositoryI
+}
+
+func (u *fakeUnitOfWork) Do(ctx context.Context, fn func(repos repository.Repositories) error) error {
+	items := u.items
+	if items == nil {
+		items = &emptyItemRepo{}
+	}
+	return fn(repository.Repositories{Orders: u.orders, Items: items})
+}
+
+// emptyArchive has no archived orders.
+func emptyArchive() repository.ArchiveRepositoryI {
+	return repository.NewMemoryArchiveRepository(repository.NewMemoryStore())
+}
+
+type failingItemRepo struct {
+	emptyItemRepo
+}
+
+func (r *failingItemRepo) CreateItem(ctx context.Context, item *models.Item) error {
+	return sql.ErrConnDone
+}
+
+func newTestOrderService(repo *slowOrderRepo) *Order {
+	appConfig := config.Config{}
+	appConfig.Queue.WorkerPool = 1
+	appConfig.Queue.QueueCapacity = 1
+	return NewOrderService(appConfig, repo, &emptyItemRepo{}, emptyArchive(), &fakeUnitOfWork{orders: repo}, nil, nil, cache.NewMemory(cache.MemoryOptions{}))
+}
+
+func TestOrder_GetOrderStatus_CoalescesMisses(t *testing.T) {
+	tests := []struct {
+		name        string
+		orderID     string
+		callers     int
+		wantStatus  string
+		wantErr     error
+		wantLookups int64
+	}{
+		{
+			name:        "existing order",
+			orderID:     "o1",
+			callers:     50,
+			wantStatus:  "Completed",
+			wantLookups: 1,
+		},
+		{
+			name:        "missing order",
+			orderID:     "missing",
+			callers:     50,
+			wantErr:     sql.ErrNoRows,
+			wantLookups: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &slowOrderRepo{delay: 50 * time.Millisecond, orders: map[string]*models.Order{
+				"o1": {OrderID: "o1", UserID: "u1", Status: "Completed"},
+			}}
+			o := newTestOrderService(repo)
+
+			var wg sync.WaitGroup
+			start := make(chan struct{})
+			for i := 0; i < tt.callers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					status, err := o.GetOrderStatus(context.Background(), tt.orderID)
+					if err != tt.wantErr || status != tt.wantStatus {
+						t.Errorf("GetOrderStatus() = %v, %v, want %v, %v", status, err, tt.wantStatus, tt.wantErr)
+					}
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			if got := repo.lookups.Load(); got != tt.wantLookups {
+				t.Errorf("DB lookups = %v, want %v", got, tt.wantLookups)
+			}
+		})
+	}
+}
+
+func TestOrder_GetOrderStatus_Canceled(t *testing.T) {
+	repo := &slowOrderRepo{delay: 100 * time.Millisecond, orders: map[string]*models.Order{
+		"o1": {OrderID: "o1", UserID: "u1", Status: "Completed"},
+	}}
+	o := newTestOrderService(repo)
+
+	// The caller that starts the lookup goes away, its query is canceled.
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := o.GetOrderStatus(leaderCtx, "o1")
+		leaderErr <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	follower := make(chan string, 1)
+	go func() {
+		status, err := o.GetOrderStatus(context.Background(), "o1")
+		if err != nil {
+			t.Errorf("GetOrderStatus() of waiting caller error = %v", err)
+		}
+		follower <- status
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-leaderErr:
+		if err != context.Canceled {
+			t.Errorf("GetOrderStatus() error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(50 * time.Millisecond):
+		t.Fatalf("GetOrderStatus() did not return after its context was canceled")
+	}
+	// The caller still waiting runs the lookup again instead of failing.
+	if status := <-follower; status != "Completed" {
+		t.Errorf("GetOrderStatus() of waiting caller = %v, want Completed", status)
+	}
+	if got := repo.lookups.Load(); got != 2 {
+		t.Errorf("DB lookups = %v, want 2", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := o.GetOrder(ctx, "o1"); err != context.DeadlineExceeded {
+		t.Errorf("GetOrder() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestOrder_GetOrder_CoalescesMisses(t *testing.T) {
+	repo := &slowOrderRepo{delay: 50 * time.Millisecond, orders: map[string]*models.Order{
+		"o1": {OrderID: "o1", UserID: "u1", TotalAmount: 10, Status: "Pending"},
+	}}
+	o := newTestOrderService(repo)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			order, err := o.GetOrder(context.Background(), "o1")
+			if err != nil || order.OrderID != "o1" {
+				t.Errorf("GetOrder() = %v, %v", order, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := repo.lookups.Load(); got != 1 {
+		t.Errorf("DB lookups = %v, want 1", got)
+	}
+	// Served from the cache now.
+	if _, err := o.GetOrder(context.Background(), "o1"); err != nil || repo.lookups.Load() != 1 {
+		t.Errorf("GetOrder() after fill hit the DB again, lookups = %v, err %v", repo.lookups.Load(), err)
+	}
+}
+
+func TestOrder_GetOrderStatus_CachesNotFound(t *testing.T) {
+	tests := []struct {
+		name        string
+		notFoundTTL int
+		wantLookups int64
+	}{
+		{name: "cached", notFoundTTL: 10, wantLookups: 1},
+		{name: "disabled", notFoundTTL: 0, wantLookups: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &slowOrderRepo{orders: map[string]*models.Order{}}
+			appConfig := config.Config{}
+			appConfig.Queue.WorkerPool = 1
+			appConfig.Queue.QueueCapacity = 1
+			appConfig.Cache.NotFoundTTLSeconds = tt.notFoundTTL
+			o := NewOrderService(appConfig, repo, &emptyItemRepo{}, emptyArchive(), &fakeUnitOfWork{orders: repo}, nil, nil, cache.NewMemory(cache.MemoryOptions{}))
+
+			for i := 0; i < 3; i++ {
+				if _, err := o.GetOrderStatus(context.Background(), "unknown"); err != sql.ErrNoRows {
+					t.Errorf("GetOrderStatus() error = %v, want %v", err, sql.ErrNoRows)
+				}
+			}
+			if got := repo.lookups.Load(); got != tt.wantLookups {
+				t.Errorf("DB lookups = %v, want %v", got, tt.wantLookups)
+			}
+
+			// The order is inserted after it was cached as missing.
+			if err := o.CreateOrderInDB(queue.Item{Id: "unknown", Value: &common.OrderRequest{UserID: "u1", TotalAmount: 10}}); err != nil {
+				t.Fatalf("CreateOrderInDB() error = %v", err)
+			}
+			if status, err := o.GetOrderStatus(context.Background(), "unknown"); err != nil || status != "Pending" {
+				t.Errorf("GetOrderStatus() of new order = %v, %v, want Pending", status, err)
+			}
+		})
+	}
+}
+
+func TestOrder_CreateOrderInDB_Fails(t *testing.T) {
+	repo := &slowOrderRepo{orders: map[string]*models.Order{}}
+	appConfig := config.Config{}
+	appConfig.Queue.WorkerPool = 1
+	appConfig.Queue.QueueCapacity = 1
+	uow := &fakeUnitOfWork{orders: repo, items: &failingItemRepo{}}
+	o := NewOrderService(appConfig, repo, &emptyItemRepo{}, emptyArchive(), uow, nil, nil, cache.NewMemory(cache.MemoryOptions{}))
+
+	orderID, err := o.CreateOrder(context.Background(), "u1", []string{"i1"}, 10)
+	if err != nil {
+		t.Fatalf("CreateOrder() error = %v", err)
+	}
+	if err := o.CreateOrderInDB(queue.Item{Id: orderID, Value: &common.OrderRequest{UserID: "u1", ItemIDs: []string{"i1"}, TotalAmount: 10}}); err == nil {
+		t.Errorf("CreateOrderInDB() of an order whose items fail error = nil")
+	}
+	// The order was rolled back, it must not stay Pending in the cache.
+	if status, err := o.GetOrderStatus(context.Background(), orderID); err != sql.ErrNoRows {
+		t.Errorf("GetOrderStatus() = %v, %v, want %v", status, err, sql.ErrNoRows)
+	}
+}
+
+func TestOrder_CreateOrder_Replicated(t *testing.T) {
+	tests := []struct {
+		name    string
+		items   repository.ItemRepositoryI
+		wantErr bool
+	}{
+		{
+			name: "returns once stored",
+		},
+		{
+			name:    "fails with the insert",
+			items:   &failingItemRepo{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := repository.NewMemoryStore()
+			orders := repository.NewMemoryOrderRepository(store)
+			appConfig := config.Config{}
+			appConfig.Database.Replicas = []string{"replica.db"}
+			appConfig.Queue.WorkerPool = 1
+			appConfig.Queue.QueueCapacity = 1
+			uow := &fakeUnitOfWork{orders: orders, items: tt.items}
+			o := NewOrderService(appConfig, orders, &emptyItemRepo{}, emptyArchive(), uow, repository.NewMemoryMetricRepository(store), nil, cache.NewMemory(cache.MemoryOptions{}))
+			o.GetOrderCreationQueue().StartOrderProcessor()
+			defer o.GetOrderCreationQueue().StopOrderProcessor()
+
+			ctx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			orderID, err := o.CreateOrder(ctx, "u1", []string{"i1"}, 10)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateOrder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if _, err := orders.GetOrderByID(ctx, orderID); err != nil {
+				t.Errorf("GetOrderByID() right after CreateOrder() error = %v", err)
+			}
+			if o.Creating(orderID) {
+				t.Errorf("Creating() of a stored order = true")
+			}
+		})
+	}
+}
+
+func TestOrder_GetOrder_Archived(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	archived := &models.Order{OrderID: "old", UserID: "u1", TotalAmount: 10, Status: "Completed"}
+	if err := repository.NewMemoryOrderRepository(store).CreateOrder(ctx, archived); err != nil {
+		t.Fatalf("CreateOrder() error = %v", err)
+	}
+	if err := repository.NewMemoryItemRepository(store).CreateItem(ctx, &models.Item{ItemID: "i1", OrderID: "old", Amount: 10}); err != nil {
+		t.Fatalf("CreateItem() error = %v", err)
+	}
+	archive := repository.NewMemoryArchiveRepository(store)
+	if ids, _, err := archive.ArchiveOrders(ctx, time.Now().Add(time.Hour), 10); err != nil || len(ids) != 1 {
+		t.Fatalf("ArchiveOrders() = %v, %v, want the order", ids, err)
+	}
+
+	repo := &slowOrderRepo{orders: map[string]*models.Order{}}
+	appConfig := config.Config{}
+	appConfig.Queue.WorkerPool = 1
+	appConfig.Queue.QueueCapacity = 1
+	o := NewOrderService(appConfig, repo, &emptyItemRepo{}, archive, &fakeUnitOfWork{orders: repo}, nil, nil, cache.NewMemory(cache.MemoryOptions{}))
+
+	order, err := o.GetOrder(ctx, "old")
+	if err != nil || order.Status != "Completed" || len(order.ItemIDs) != 1 || order.ItemIDs[0] != "i1" {
+		t.Errorf("GetOrder() of an archived order = %+v, %v, want it with its item", order, err)
+	}
+	if status, err := o.GetOrderStatus(ctx, "old"); err != nil || status != "Completed" {
+		t.Errorf("GetOrderStatus() of an archived order = %v, %v, want Completed", status, err)
+	}
+	if _, err := o.GetOrder(ctx, "missing"); err != sql.ErrNoRows {
+		t.Errorf("GetOrder() of an unknown order error = %v, want %v", err, sql.ErrNoRows)
+	}
+}
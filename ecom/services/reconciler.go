@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"ecom.com/cache"
+	"ecom.com/database"
+	"ecom.com/errors"
+	"ecom.com/repository"
+)
+
+// ReconcilerStats reports how far the cache drifted from the orders DB.
+type ReconcilerStats struct {
+	Runs       int64
+	Checked    int64 // cached statuses compared with the DB
+	Missing    int64 // cached orders with no DB row
+	Mismatched int64 // cached status differs from the DB
+	Repaired   int64
+	Suspects   int64 // mismatches waiting for the next run to confirm them
+}
+
+// Reconciler periodically samples cached statuses, compares them with the
+// orders DB and makes the cache match the DB. An order being created or
+// processed is out of sync for a moment by design, so a mismatch is only
+// repaired once two runs in a row saw it. Orders still queued for creation
+// and archived orders have no row in the orders table and are skipped.
+type Reconciler struct {
+	repo        repository.OrderRepositoryI
+	archiveRepo repository.ArchiveRepositoryI
+	// Reports orders queued for creation and not stored yet.
+	creating   func(orderID string) bool
+	cache      cache.CacheI
+	interval   time.Duration
+	sampleSize int
+	// Mismatches seen by the last run, order id to "cached->db" status.
+	suspects map[string]string
+	stats    ReconcilerStats
+	mutex    *sync.Mutex
+	// Canceled by Stop, also aborts a run that is in progress.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewReconciler(orderRepo repository.OrderRepositoryI, archiveRepo repository.ArchiveRepositoryI, creating func(orderID string) bool, cache cache.CacheI, interval time.Duration, sampleSize int) *Reconciler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Reconciler{
+		repo:        orderRepo,
+		archiveRepo: archiveRepo,
+		creating:    creating,
+		cache:       cache,
+		interval:    interval,
+		sampleSize:  sampleSize,
+		suspects:    map[string]string{},
+		mutex:       &sync.Mutex{},
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// Start runs Reconcile every interval until Stop. An interval of zero
+// disables the reconciler.
+func (r *Reconciler) Start() {
+	if r.interval <= 0 {
+		return
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.Reconcile(r.ctx)
+			case <-r.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (r *Reconciler) Stop() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+// Reconcile checks a sample of cached statuses, plus the suspects of the
+// previous run, against the DB. A run stops early once ctx is done.
+func (r *Reconciler) Reconcile(ctx context.Context) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stats.Runs++
+
+	sample, err := r.cache.SampleOrderIDs(r.sampleSize)
+	if err != nil {
+		log.Printf("Reconciler failed to sample the cache: %v", err)
+	}
+	ids := make([]string, 0, len(r.suspects)+len(sample))
+	for id := range r.suspects {
+		ids = append(ids, id)
+	}
+	for _, id := range sample {
+		if _, found := r.suspects[id]; !found {
+			ids = append(ids, id)
+		}
+	}
+
+	suspects := map[string]string{}
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			break
+		}
+		if r.creating(id) {
+			continue
+		}
+		cached, err := r.cache.GetOrderStatus(id)
+		if err != nil {
+			// Expired or evicted since it was sampled.
+			continue
+		}
+		dbStatus := ""
+		// A replica behind the primary would look like drift.
+		order, err := r.repo.GetOrderByID(database.Primary(ctx), id)
+		if err == nil {
+			dbStatus = order.Status
+		} else if err != sql.ErrNoRows {
+			log.Printf("Reconciler failed to read order %v: %v", id, err)
+			continue
+		} else if r.archived(ctx, id) {
+			continue
+		}
+		r.stats.Checked++
+		if cached == dbStatus {
+			continue
+		}
+		drift := cached + "->" + dbStatus
+		if r.suspects[id] != drift {
+			suspects[id] = drift
+			continue
+		}
+		if dbStatus == "" {
+			r.stats.Missing++
+		} else {
+			r.stats.Mismatched++
+		}
+		if r.repair(id, cached, dbStatus) {
+			r.stats.Repaired++
+		}
+	}
+	r.suspects = suspects
+	r.stats.Suspects = int64(len(suspects))
+}
+
+// archived reports whether the order was moved to the archive, an error
+// counts as archived so the order is not dropped from the cache on it.
+func (r *Reconciler) archived(ctx context.Context, orderID string) bool {
+	_, _, err := r.archiveRepo.GetArchivedOrder(database.Primary(ctx), orderID)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	if err != nil {
+		log.Printf("Reconciler failed to read archived order %v: %v", orderID, err)
+	}
+	return true
+}
+
+// repair makes the cache match the DB, also backwards. The status is only
+// replaced if it is still the one compared with the DB, an order processing
+// moved on since keeps its newer status.
+func (r *Reconciler) repair(orderID, cached, dbStatus string) bool {
+	log.Printf("Reconciler repairing order %v, cached %q, DB %q", orderID, cached, dbStatus)
+	if err := r.cache.ReplaceOrderStatus(orderID, cached, dbStatus); err != nil {
+		if err == errors.ErrStaleWrite {
+			log.Printf("Reconciler left order %v alone, its cached status changed", orderID)
+		} else {
+			log.Printf("Reconciler failed to repair cached status of order %v: %v", orderID, err)
+		}
+		return false
+	}
+	if err := r.cache.InvalidateOrder(orderID); err != nil {
+		log.Printf("Reconciler failed to invalidate order %v: %v", orderID, err)
+		return false
+	}
+	return true
+}
+
+func (r *Reconciler) Stats() ReconcilerStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.stats
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ecom.com/cache"
+	"ecom.com/errors"
+	"ecom.com/models"
+	"ecom.com/repository"
+)
+
+func TestReconciler_Reconcile(t *testing.T) {
+	tests := []struct {
+		name      string
+		cached    map[string]string
+		db        map[string]string
+		creating  []string          // queued for creation
+		archived  map[string]string // in the archive
+		runs      int
+		wantCache map[string]string // "" means not cached
+		wantStats ReconcilerStats
+	}{
+		{
+			name:      "in sync",
+			cached:    map[string]string{"o1": "Completed"},
+			db:        map[string]string{"o1": "Completed"},
+			runs:      2,
+			wantCache: map[string]string{"o1": "Completed"},
+			wantStats: ReconcilerStats{Runs: 2, Checked: 2},
+		},
+		{
+			name:      "single run only suspects",
+			cached:    map[string]string{"o1": "Pending"},
+			runs:      1,
+			wantCache: map[string]string{"o1": "Pending"},
+			wantStats: ReconcilerStats{Runs: 1, Checked: 1, Suspects: 1},
+		},
+		{
+			name:      "no DB row",
+			cached:    map[string]string{"o1": "Pending"},
+			runs:      2,
+			wantCache: map[string]string{"o1": ""},
+			wantStats: ReconcilerStats{Runs: 2, Checked: 2, Missing: 1, Repaired: 1},
+		},
+		{
+			name:      "cache ahead of DB",
+			cached:    map[string]string{"o1": "Completed", "o2": "Processing"},
+			db:        map[string]string{"o1": "Pending", "o2": "Processing"},
+			runs:      2,
+			wantCache: map[string]string{"o1": "Pending", "o2": "Processing"},
+			wantStats: ReconcilerStats{Runs: 2, Checked: 4, Mismatched: 1, Repaired: 1},
+		},
+		{
+			name:      "cache behind DB",
+			cached:    map[string]string{"o1": "Pending"},
+			db:        map[string]string{"o1": "Completed"},
+			runs:      3,
+			wantCache: map[string]string{"o1": "Completed"},
+			wantStats: ReconcilerStats{Runs: 3, Checked: 3, Mismatched: 1, Repaired: 1},
+		},
+		{
+			name:      "queued for creation",
+			cached:    map[string]string{"o1": "Pending"},
+			creating:  []string{"o1"},
+			runs:      2,
+			wantCache: map[string]string{"o1": "Pending"},
+			wantStats: ReconcilerStats{Runs: 2},
+		},
+		{
+			name:      "archived",
+			cached:    map[string]string{"o1": "Completed"},
+			archived:  map[string]string{"o1": "Completed"},
+			runs:      2,
+			wantCache: map[string]string{"o1": "Completed"},
+			wantStats: ReconcilerStats{Runs: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &slowOrderRepo{orders: map[string]*models.Order{}}
+			for id, status := range tt.db {
+				repo.orders[id] = &models.Order{OrderID: id, Status: status}
+			}
+			archive := archiveWith(t, tt.archived)
+			creating := map[string]bool{}
+			for _, id := range tt.creating {
+				creating[id] = true
+			}
+			c := cache.NewMemory(cache.MemoryOptions{})
+			for id, status := range tt.cached {
+				c.SetOrderStatus(id, status)
+			}
+			r := NewReconciler(repo, archive, func(id string) bool { return creating[id] }, c, 0, 10)
+			for i := 0; i < tt.runs; i++ {
+				r.Reconcile(context.Background())
+			}
+			for id, want := range tt.wantCache {
+				got, err := c.GetOrderStatus(id)
+				if want == "" {
+					if err != errors.ErrNotFound {
+						t.Errorf("GetOrderStatus(%v) = %v, %v, want %v", id, got, err, errors.ErrNotFound)
+					}
+					continue
+				}
+				if err != nil || got != want {
+					t.Errorf("GetOrderStatus(%v) = %v, %v, want %v", id, got, err, want)
+				}
+			}
+			if got := r.Stats(); got != tt.wantStats {
+				t.Errorf("Stats() = %+v, want %+v", got, tt.wantStats)
+			}
+		})
+	}
+}
+
+// archiveWith returns an archive holding orders, order id to status.
+func archiveWith(t *testing.T, orders map[string]string) repository.ArchiveRepositoryI {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	for id, status := range orders {
+		if err := repository.NewMemoryOrderRepository(store).CreateOrder(ctx, &models.Order{OrderID: id, Status: status}); err != nil {
+			t.Fatalf("CreateOrder() error = %v", err)
+		}
+	}
+	archive := repository.NewMemoryArchiveRepository(store)
+	if ids, _, err := archive.ArchiveOrders(ctx, time.Now().Add(time.Hour), len(orders)+1); err != nil || len(ids) != len(orders) {
+		t.Fatalf("ArchiveOrders() = %v, %v, want %v orders", ids, err, len(orders))
+	}
+	return archive
+}
+
+// advancingOrderRepo has processing move the order on in the cache right
+// after the reconciler read it from the DB.
+type advancingOrderRepo struct {
+	*slowOrderRepo
+	cache  cache.CacheI
+	status string
+}
+
+func (r *advancingOrderRepo) GetOrderByID(ctx context.Context, id string) (*models.Order, error) {
+	order, err := r.slowOrderRepo.GetOrderByID(ctx, id)
+	r.cache.SetOrderStatus(id, r.status)
+	return order, err
+}
+
+func TestReconciler_RepairKeepsNewerStatus(t *testing.T) {
+	c := cache.NewMemory(cache.MemoryOptions{})
+	c.SetOrderStatus("o1", "Processing")
+	repo := &slowOrderRepo{orders: map[string]*models.Order{"o1": {OrderID: "o1", Status: "Pending"}}}
+	r := NewReconciler(repo, emptyArchive(), func(string) bool { return false }, c, 0, 10)
+	r.Reconcile(context.Background())
+
+	r.repo = &advancingOrderRepo{slowOrderRepo: repo, cache: c, status: "Completed"}
+	r.Reconcile(context.Background())
+	if got, err := c.GetOrderStatus("o1"); err != nil || got != "Completed" {
+		t.Errorf("GetOrderStatus(o1) = %v, %v, want Completed", got, err)
+	}
+	if stats := r.Stats(); stats.Mismatched != 1 || stats.Repaired != 0 {
+		t.Errorf("Stats() = %+v, want the mismatch found and not repaired", stats)
+	}
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ecom.com/cache"
+	"ecom.com/models"
+	"ecom.com/repository"
+)
+
+// WarmUpStats describes the last warm-up.
+type WarmUpStats struct {
+	Loaded   int64 // statuses written to the cache
+	Batches  int64
+	Duration time.Duration
+	// TimedOut is set when the budget ran out before every order was loaded.
+	TimedOut bool
+}
+
+// WarmUp preloads the cache with the statuses of in-flight and recent orders
+// after a start, so the first reads do not all go to the DB. The instance
+// reports ready once it is done.
+type WarmUp struct {
+	repo      repository.OrderRepositoryI
+	cache     cache.CacheI
+	window    time.Duration // orders created this long ago or later are loaded
+	batchSize int
+	budget    time.Duration // 0 means no limit
+	ready     atomic.Bool
+	stats     WarmUpStats
+	mutex     *sync.Mutex
+}
+
+func NewWarmUp(orderRepo repository.OrderRepositoryI, cache cache.CacheI, window time.Duration, batchSize int, budget time.Duration) *WarmUp {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	return &WarmUp{
+		repo:      orderRepo,
+		cache:     cache,
+		window:    window,
+		batchSize: batchSize,
+		budget:    budget,
+		mutex:     &sync.Mutex{},
+	}
+}
+
+// Run loads the cache batch by batch, newest orders first, until everything
+// is loaded, the budget is spent, ctx is done or the DB fails. A query still
+// running when the budget is spent is canceled. The instance is ready
+// afterwards in every case, a cold cache only costs latency.
+func (w *WarmUp) Run(ctx context.Context) {
+	defer w.ready.Store(true)
+	start := time.Now()
+	if w.budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.budget)
+		defer cancel()
+	}
+	since := start.Add(-w.window)
+	stats := WarmUpStats{}
+	var after *models.Order
+	for {
+		if ctx.Err() != nil {
+			stats.TimedOut = ctx.Err() == context.DeadlineExceeded
+			break
+		}
+		orders, err := w.repo.ListActiveOrders(ctx, since, after, w.batchSize)
+		if err != nil && ctx.Err() != nil {
+			stats.TimedOut = ctx.Err() == context.DeadlineExceeded
+			break
+		}
+		if err != nil {
+			log.Printf("Cache warm-up stopped, failed to list orders: %v", err)
+			break
+		}
+		stats.Batches++
+		for _, order := range orders {
+			// Rejected if the order moved on since it was read.
+			if err := w.cache.SetOrderStatus(order.OrderID, order.Status); err == nil {
+				stats.Loaded++
+			}
+		}
+		if len(orders) < w.batchSize {
+			break
+		}
+		after = orders[len(orders)-1]
+	}
+	stats.Duration = time.Since(start)
+	log.Printf("Cache warm-up loaded %v statuses in %v batches, %v, timed out %v", stats.Loaded, stats.Batches, stats.Duration, stats.TimedOut)
+	w.mutex.Lock()
+	w.stats = stats
+	w.mutex.Unlock()
+}
+
+// Skip marks the instance ready without loading anything.
+func (w *WarmUp) Skip() {
+	w.ready.Store(true)
+}
+
+func (w *WarmUp) Ready() bool {
+	return w.ready.Load()
+}
+
+func (w *WarmUp) Stats() WarmUpStats {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.stats
+}
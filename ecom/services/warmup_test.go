@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ecom.com/cache"
+	"ecom.com/errors"
+	"ecom.com/models"
+)
+
+func TestWarmUp_Run(t *testing.T) {
+	now := time.Now()
+	orders := map[string]*models.Order{
+		"pending":          {OrderID: "pending", Status: "Pending", CreatedAt: now.Add(-2 * time.Hour)},
+		"processing":       {OrderID: "processing", Status: "Processing", CreatedAt: now},
+		"recent-completed": {OrderID: "recent-completed", Status: "Completed", CreatedAt: now.Add(-time.Minute)},
+		"old-completed":    {OrderID: "old-completed", Status: "Completed", CreatedAt: now.Add(-2 * time.Hour)},
+	}
+	tests := []struct {
+		name       string
+		batchSize  int
+		budget     time.Duration
+		delay      time.Duration
+		wantCached []string
+		wantStats  WarmUpStats
+	}{
+		{
+			name:       "one batch",
+			batchSize:  10,
+			wantCached: []string{"processing", "recent-completed", "pending"},
+			wantStats:  WarmUpStats{Loaded: 3, Batches: 1},
+		},
+		{
+			name:       "small batches",
+			batchSize:  2,
+			wantCached: []string{"processing", "recent-completed", "pending"},
+			wantStats:  WarmUpStats{Loaded: 3, Batches: 2},
+		},
+		{
+			// The second query is canceled when the budget runs out.
+			name:       "budget runs out",
+			batchSize:  1,
+			budget:     75 * time.Millisecond,
+			delay:      50 * time.Millisecond,
+			wantCached: []string{"processing"},
+			wantStats:  WarmUpStats{Loaded: 1, Batches: 1, TimedOut: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cache.NewMemory(cache.MemoryOptions{})
+			w := NewWarmUp(&slowOrderRepo{orders: orders, delay: tt.delay}, c, time.Hour, tt.batchSize, tt.budget)
+			if w.Ready() {
+				t.Fatalf("Ready() before Run = true")
+			}
+			w.Run(context.Background())
+			if !w.Ready() {
+				t.Errorf("Ready() after Run = false")
+			}
+			for _, id := range tt.wantCached {
+				if got, err := c.GetOrderStatus(id); err != nil || got != orders[id].Status {
+					t.Errorf("GetOrderStatus(%v) = %v, %v, want %v", id, got, err, orders[id].Status)
+				}
+			}
+			if _, err := c.GetOrderStatus("old-completed"); err != errors.ErrNotFound {
+				t.Errorf("GetOrderStatus(old-completed) error = %v, want %v", err, errors.ErrNotFound)
+			}
+			got := w.Stats()
+			got.Duration = 0
+			if got != tt.wantStats {
+				t.Errorf("Stats() = %+v, want %+v", got, tt.wantStats)
+			}
+		})
+	}
+}
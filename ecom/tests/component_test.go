@@ -2,6 +2,7 @@ package tests
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"ecom.com/common"
+	"ecom.com/models"
 	"ecom.com/queue"
 
 	"github.com/stretchr/testify/assert"
@@ -42,7 +44,7 @@ func TestCreateOrderAPI(t *testing.T) {
 // It verifies that the status is initially "Pending" then transitions to "Completed" after processing.
 func TestGetOrderStatusAPI(t *testing.T) {
 	// Create order using the service, which also sets Redis.
-	orderID, err := globalTestContainer.OrderService.CreateOrder("test-user-status", []string{"item1", "item2"}, 50.0)
+	orderID, err := globalTestContainer.OrderService.CreateOrder(context.Background(), "test-user-status", []string{"item1", "item2"}, 50.0)
 	assert.Nil(t, err)
 
 	var response map[string]interface{}
@@ -73,7 +75,7 @@ func TestMetricsAPI(t *testing.T) {
 	// Create and process a few orders.
 	count := 3
 	for i := 0; i < count; i++ {
-		_, err := globalTestContainer.OrderService.CreateOrder("user"+strconv.Itoa(i), []string{"item1", "item2"}, 100.0)
+		_, err := globalTestContainer.OrderService.CreateOrder(context.Background(), "user"+strconv.Itoa(i), []string{"item1", "item2"}, 100.0)
 		assert.Nil(t, err)
 	}
 	// Allow processing to complete.
@@ -92,49 +94,44 @@ func TestMetricsAPI(t *testing.T) {
 }
 
 func TestDatabaseOperations0(t *testing.T) {
-	orderID := 5
+	orderID := "5"
 	userID := "db-test-user"
 	// itemIDs := []string{"item1", "item2"}
 	amount := 75.0
-	var status string
-	_, err := globalTestContainer.DB.Exec("INSERT INTO orders (order_id, user_id, total_amount, status) VALUES (?, ?, ?, ?)", orderID, userID, amount, "Pending")
+	err := globalTestContainer.OrderRepo.CreateOrder(context.Background(), &models.Order{OrderID: orderID, UserID: userID, TotalAmount: amount, Status: "Pending"})
 	assert.Nil(t, err)
-	var orderIDOut, userId string
-	var amountOut float64
-	err = globalTestContainer.DB.QueryRow("SELECT order_id, user_id, total_amount, status  FROM orders WHERE order_id = ?", orderID).Scan(&orderIDOut, &userId, &amountOut, &status)
+	order, err := globalTestContainer.OrderRepo.GetOrderByID(context.Background(), orderID)
 	assert.Nil(t, err)
-	assert.Equal(t, "Pending", status)
-	assert.Equal(t, amount, amountOut)
-	assert.Equal(t, "db-test-user", userId)
+	assert.Equal(t, "Pending", order.Status)
+	assert.Equal(t, amount, order.TotalAmount)
+	assert.Equal(t, "db-test-user", order.UserID)
 }
 
 // TestDatabaseOperations verifies that creating an order inserts the correct record into the orders DB.
 func TestDatabaseOperations(t *testing.T) {
-	orderID, err := globalTestContainer.OrderService.CreateOrder("db-test-user", []string{"item1", "item2"}, 75.0)
+	orderID, err := globalTestContainer.OrderService.CreateOrder(context.Background(), "db-test-user", []string{"item1", "item2"}, 75.0)
 	assert.Nil(t, err)
-	time.Sleep(1 * time.Second)
-	var status string
-	var orderIDOut, userId string
-	var amount float64
-	err = globalTestContainer.DB.QueryRow("SELECT order_id, user_id, total_amount, status  FROM orders WHERE order_id = ?", orderID).Scan(&orderIDOut, &userId, &amount, &status)
-	assert.Nil(t, err)
-	assert.Equal(t, 75.0, amount)
-	assert.Equal(t, "db-test-user", userId)
-	assert.Equal(t, "Pending", status)
+	// Processing completes the order about a second after it is stored.
+	order := waitForOrder(t, orderID, "Pending", 5*time.Second)
+	if order == nil {
+		return
+	}
+	assert.Equal(t, 75.0, order.TotalAmount)
+	assert.Equal(t, "db-test-user", order.UserID)
+	assert.Equal(t, "Pending", order.Status)
 }
 
 // TestQueueProcessing tests that an order added to the queue is processed and updated to "Completed"
 // in both the orders DB and the Redis cache.
 func TestQueueProcessing(t *testing.T) {
-	orderID, err := globalTestContainer.OrderService.CreateOrder("queue-test-user", []string{"item1", "item2"}, 200.0)
+	orderID, err := globalTestContainer.OrderService.CreateOrder(context.Background(), "queue-test-user", []string{"item1", "item2"}, 200.0)
 	assert.Nil(t, err)
 	time.Sleep(4 * time.Second)
 
 	// Verify orders DB status.
-	var status string
-	err = globalTestContainer.DB.QueryRow("SELECT status FROM orders WHERE order_id = ?", orderID).Scan(&status)
+	order, err := globalTestContainer.OrderRepo.GetOrderByID(context.Background(), orderID)
 	assert.Nil(t, err)
-	assert.Equal(t, "Completed", status)
+	assert.Equal(t, "Completed", order.Status)
 
 	// Verify Redis cache status.
 	cachedStatus, err := globalTestContainer.Cache.GetOrderStatus(orderID)
@@ -145,16 +142,21 @@ func TestQueueProcessing(t *testing.T) {
 // TestConcurrentQueueProcessing tests the queue's ability to process multiple orders concurrently.
 func TestConcurrentQueueProcessing(t *testing.T) {
 	var wg sync.WaitGroup
+	var mu sync.Mutex
 	numOrders := 15
+	orderIDs := []string{}
 	for i := 0; i < numOrders; i++ {
 		wg.Add(1)
 		go func(i int) {
 			defer wg.Done()
-			orderID, err := globalTestContainer.OrderService.CreateOrder("concurrent-user"+strconv.Itoa(i), []string{"item1", "item2"}, 150.0)
+			orderID, err := globalTestContainer.OrderService.CreateOrder(context.Background(), "concurrent-user"+strconv.Itoa(i), []string{"item1", "item2"}, 150.0)
 			if err != nil {
 				t.Errorf("Error creating order: %v", err)
 				return
 			}
+			mu.Lock()
+			orderIDs = append(orderIDs, orderID)
+			mu.Unlock()
 			globalTestContainer.OrderService.GetOrderProcessQueue().Enqueue(queue.Item{Id: orderID, Value: &common.OrderItem{OrderID: orderID}})
 		}(i)
 	}
@@ -163,14 +165,28 @@ func TestConcurrentQueueProcessing(t *testing.T) {
 	// Wait for all orders to be processed.
 	time.Sleep(10 * time.Second)
 
-	// Verify each order is marked as "Completed" in the orders DB.
-	rows, err := globalTestContainer.DB.Query("SELECT status FROM orders")
-	assert.Nil(t, err)
-	defer rows.Close()
-	for rows.Next() {
-		var status string
-		err = rows.Scan(&status)
+	// Verify each order of this test is marked as "Completed" in the orders DB, other tests leave theirs pending.
+	assert.Len(t, orderIDs, numOrders)
+	for _, orderID := range orderIDs {
+		order, err := globalTestContainer.OrderRepo.GetOrderByID(context.Background(), orderID)
 		assert.Nil(t, err)
-		assert.Equal(t, "Completed", status)
+		assert.Equal(t, "Completed", order.Status)
+	}
+}
+
+// waitForOrder polls the orders DB until the order has status, it fails t
+// and returns nil once timeout passed.
+func waitForOrder(t *testing.T, orderID, status string, timeout time.Duration) *models.Order {
+	deadline := time.Now().Add(timeout)
+	for {
+		order, err := globalTestContainer.OrderRepo.GetOrderByID(context.Background(), orderID)
+		if err == nil && order.Status == status {
+			return order
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("order %v = %+v, %v, want status %v within %v", orderID, order, err, status, timeout)
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
 }
@@ -2,17 +2,21 @@ package tests
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"testing"
 	"time"
 
 	"ecom.com/config"
-	"ecom.com/database"
+	"ecom.com/constants"
+	"ecom.com/handlers"
 	"ecom.com/routes"
 	"ecom.com/server"
 
@@ -24,33 +28,54 @@ import (
 var globalTestRouter *gin.Engine
 var globalTestContainer *server.Container
 
+// TestMain runs the suite on in-memory storage and again on SQLite, every
+// run starts empty.
 func TestMain(m *testing.M) {
 	gin.SetMode(gin.TestMode)
 
+	dir, err := os.MkdirTemp("", "ecom-tests")
+	if err != nil {
+		log.Fatalf("Error creating the test directory: %v", err)
+	}
+	exitCode := 0
+	for _, storage := range []constants.StorageName{constants.MEMORY_STORAGE, constants.SQL_STORAGE} {
+		log.Printf("Running the tests on %v storage", storage)
+		if code := runSuite(m, storage, dir); code != 0 {
+			exitCode = code
+		}
+	}
+	os.RemoveAll(dir)
+	os.Exit(exitCode)
+}
+
+func runSuite(m *testing.M, storage constants.StorageName, dir string) int {
 	testConfig := config.Config{}
 	testConfig.Server.Port = "8081"
+	testConfig.Storage = string(storage)
 	testConfig.Database.Driver = "sqlite3"
 	testConfig.Metrics.Driver = "sqlite3"
-	testConfig.Database.DSN = "orders_test.db"
-	testConfig.Metrics.DSN = "metrics_test.db"
+	testConfig.Database.DSN = filepath.Join(dir, "orders_test.db")
+	testConfig.Metrics.DSN = filepath.Join(dir, "metrics_test.db")
 	testConfig.Queue.WorkerPool = 5
 	testConfig.Queue.QueueCapacity = 500
+	testConfig.Cache.NotFoundTTLSeconds = 60
 	testConfig.Redis.Addr = "localhost:6379"
 	testConfig.Redis.Password = ""
 	testConfig.Redis.DB = 1
 
 	globalTestContainer = server.NewContainer(testConfig)
-	defer database.CloseDB(globalTestContainer.DB)
-	defer database.CloseDB(globalTestContainer.MetricDB)
+	defer globalTestContainer.DB.Close()
+	defer globalTestContainer.MetricDB.Close()
 
 	globalTestContainer.OrderService.GetOrderProcessQueue().StartOrderProcessor()
 	globalTestContainer.OrderService.GetOrderCreationQueue().StartOrderProcessor()
+	defer globalTestContainer.OrderService.GetOrderProcessQueue().StopOrderProcessor()
+	defer globalTestContainer.OrderService.GetOrderCreationQueue().StopOrderProcessor()
 
 	// Initialize and assign router
 	globalTestRouter = gin.Default()
 	routes.RegisterRoutes(globalTestRouter, globalTestContainer.RoutesCfg)
-	exitCode := m.Run()
-	os.Exit(exitCode)
+	return m.Run()
 }
 
 func TestCreateOrder(t *testing.T) {
@@ -74,10 +99,15 @@ func TestCreateOrder(t *testing.T) {
 }
 
 func TestGetOrderStatusNotFound(t *testing.T) {
-	req, _ := http.NewRequest("GET", "/api/v1/orders/non-existent-id", nil)
-	resp := httptest.NewRecorder()
-	globalTestRouter.ServeHTTP(resp, req)
-	assert.Equal(t, http.StatusNotFound, resp.Code)
+	for _, path := range []string{"/api/v1/orders/non-existent-id", "/api/v1/orders/status/non-existent-id"} {
+		// The second request is served by the cached not-found.
+		for i := 0; i < 2; i++ {
+			req, _ := http.NewRequest("GET", path, nil)
+			resp := httptest.NewRecorder()
+			globalTestRouter.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusNotFound, resp.Code, path)
+		}
+	}
 }
 
 func TestCreateOrderInvalidPayload(t *testing.T) {
@@ -133,3 +163,32 @@ func TestGetMetrics(t *testing.T) {
 	assert.Contains(t, metrics, "total_orders_received")
 	assert.Contains(t, metrics, "average_processing_time")
 }
+
+func TestCanceledRequests(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		method   string
+		path     string
+		wantCode int
+	}{
+		{name: "client gone, order status", ctx: canceled, method: "GET", path: "/api/v1/orders/status/unknown-order", wantCode: handlers.StatusClientClosedRequest},
+		{name: "client gone, order", ctx: canceled, method: "GET", path: "/api/v1/orders/unknown-order", wantCode: handlers.StatusClientClosedRequest},
+		{name: "deadline passed, metrics", ctx: expired, method: "GET", path: "/api/v1/metrics", wantCode: http.StatusGatewayTimeout},
+		{name: "deadline passed, create", ctx: expired, method: "POST", path: "/api/v1/orders", wantCode: http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := bytes.NewBufferString(`{"user_id": "u1", "item_ids": ["item1"], "total_amount": 1}`)
+			req, _ := http.NewRequestWithContext(tt.ctx, tt.method, tt.path, body)
+			req.Header.Set("Content-Type", "application/json")
+			resp := httptest.NewRecorder()
+			globalTestRouter.ServeHTTP(resp, req)
+			assert.Equal(t, tt.wantCode, resp.Code)
+		})
+	}
+}